@@ -0,0 +1,199 @@
+package realdebrid
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// failoverCooldown is how long the failover accounts are used before
+// trying the primary account again
+const failoverCooldown = 10 * time.Minute
+
+// accounts routes API calls between the primary API key and the
+// failover keys.
+//
+// The primary key is used until its account is rate limited or can't
+// be used, e.g. because it is locked or out of traffic, then the next
+// key is used for failoverCooldown before going back to the primary.
+//
+// Torrent and download IDs are only valid on the account they belong
+// to, so the calls on a torrent or download are sent with the key of
+// the account it was listed or added with instead. The key is added to
+// each request as it is sent so a retry after a failover uses the new
+// key.
+type accounts struct {
+	mu      sync.Mutex
+	root    string         // URL of the API, other requests aren't signed
+	keys    []string       // primary key first then the failover keys
+	current int            // index of the key in use
+	until   time.Time      // when to go back to the primary key
+	owners  map[string]int // index of the key of each torrent and download by ID
+}
+
+// newAccounts makes an accounts from the primary key and the failover keys
+func newAccounts(primary string, failover []string) *accounts {
+	a := &accounts{
+		root:   rootURL,
+		owners: map[string]int{},
+	}
+	if primary != "" {
+		a.keys = append(a.keys, primary)
+	}
+	for _, key := range failover {
+		if key != "" && key != primary {
+			a.keys = append(a.keys, key)
+		}
+	}
+	return a
+}
+
+// currentKey returns the index of the key to use for calls which
+// aren't on a torrent or download
+//
+// Call with mu held.
+func (a *accounts) currentKey() int {
+	if a.current != 0 && time.Now().After(a.until) {
+		fs.Debugf(nil, "realdebrid: switching back to the primary account")
+		a.current = 0
+	}
+	return a.current
+}
+
+// ownerID returns the ID of the torrent or download the API path p
+// calls, or "" if it isn't a call on one
+func ownerID(p string) string {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	if len(parts) != 3 || (parts[0] != "torrents" && parts[0] != "downloads") {
+		return ""
+	}
+	switch parts[1] {
+	case "info", "delete", "selectFiles":
+		return parts[2]
+	}
+	return ""
+}
+
+// sign adds the API key to use to req
+func (a *accounts) sign(req *http.Request) error {
+	if !strings.HasPrefix(req.URL.String(), a.root) {
+		// e.g. a download from a host
+		return nil
+	}
+	a.mu.Lock()
+	if len(a.keys) == 0 {
+		a.mu.Unlock()
+		return nil
+	}
+	i, ok := a.owners[ownerID(strings.TrimPrefix(req.URL.String(), a.root))]
+	if !ok || i >= len(a.keys) {
+		i = a.currentKey()
+	}
+	key := a.keys[i]
+	a.mu.Unlock()
+	query := req.URL.Query()
+	query.Set("auth_token", key)
+	req.URL.RawQuery = query.Encode()
+	return nil
+}
+
+// used returns the index of the key resp was the answer to, or 0 if
+// it wasn't signed
+func (a *accounts) used(resp *http.Response) int {
+	if a == nil || resp == nil || resp.Request == nil {
+		return 0
+	}
+	key := resp.Request.URL.Query().Get("auth_token")
+	for i := range a.keys {
+		if a.keys[i] == key {
+			return i
+		}
+	}
+	return 0
+}
+
+// tag sets the account of the items of a listing page to the one of
+// the key resp was the answer to and returns it
+func (a *accounts) tag(page []api.Item, resp *http.Response) int {
+	i := a.used(resp)
+	for j := range page {
+		page[j].Account = i
+	}
+	return i
+}
+
+// setOwner records that the torrent or download with ID id belongs to
+// the account of the key with index i
+func (a *accounts) setOwner(id string, i int) {
+	if a == nil || id == "" {
+		return
+	}
+	a.mu.Lock()
+	a.owners[id] = i
+	a.mu.Unlock()
+}
+
+// own records the accounts of the torrents and downloads in lists,
+// forgetting the ones no longer in them
+func (a *accounts) own(lists ...[]api.Item) {
+	if a == nil {
+		return
+	}
+	owners := map[string]int{}
+	for _, list := range lists {
+		for _, item := range list {
+			owners[item.ID] = item.Account
+		}
+	}
+	a.mu.Lock()
+	a.owners = owners
+	a.mu.Unlock()
+}
+
+// failover moves on from the key with index i to the next account if
+// there is one, unless that was done already
+func (a *accounts) failover(i int, reason string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.keys) < 2 || i != a.current {
+		return
+	}
+	a.current = (a.current + 1) % len(a.keys)
+	a.until = time.Now().Add(failoverCooldown)
+	fs.Logf(nil, "realdebrid: %s - switching to account %d of %d", reason, a.current+1, len(a.keys))
+}
+
+// isAccountError returns whether err means the account it came from
+// can't be used for now, rather than the call failing
+func isAccountError(err error) bool {
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode {
+	case api.CodeTooManyRequests, api.CodeTrafficExhausted, api.CodeFairUsageLimit, api.CodeHosterNotFree:
+		return true
+	}
+	return apiErr.Fatal()
+}
+
+// checkResponse fails over to the next account if resp, the error
+// response err was made from, shows the account it was sent with can't
+// be used at the moment
+//
+// Responses from the download hosts are ignored.
+func (a *accounts) checkResponse(resp *http.Response, err error) {
+	if resp.Request == nil || !strings.HasPrefix(resp.Request.URL.String(), a.root) {
+		return
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		a.failover(a.used(resp), "rate limited")
+	} else if isAccountError(err) {
+		a.failover(a.used(resp), err.Error())
+	}
+}
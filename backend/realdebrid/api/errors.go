@@ -0,0 +1,121 @@
+package api
+
+import "fmt"
+
+// Error is an error returned by the RealDebrid API
+//
+// See https://api.real-debrid.com/#api_error_codes for the codes.
+type Error struct {
+	StatusCode int    `json:"-"`                       // HTTP status code
+	Code       string `json:"error"`                   // error code as a string, e.g. "bad_token"
+	ErrorCode  int    `json:"error_code"`              // error code as a number
+	Details    string `json:"error_details,omitempty"` // extra information, if any
+	Sentinel   error  `json:"-"`                       // error this is also reported as, if any
+}
+
+// Error codes returned by the API
+const (
+	CodeSlowDown                     = 5
+	CodeResourceNotFound             = 7
+	CodeBadToken                     = 8
+	CodePermissionDenied             = 9
+	CodeAccountLocked                = 14
+	CodeAccountNotActivated          = 15
+	CodeUnsupportedHoster            = 16
+	CodeHosterInMaintenance          = 17
+	CodeHosterLimitReached           = 18
+	CodeHosterTemporarilyUnavailable = 19
+	CodeHosterNotFree                = 20
+	CodeTooManyActiveDownloads       = 21
+	CodeIPAddressNotAllowed          = 22
+	CodeTrafficExhausted             = 23
+	CodeFileUnavailable              = 24
+	CodeServiceUnavailable           = 25
+	CodeFileNotAllowed               = 28
+	CodeTorrentTooBig                = 29
+	CodeTorrentFileInvalid           = 30
+	CodeActionAlreadyDone            = 31
+	CodeTorrentAlreadyActive         = 33
+	CodeTooManyRequests              = 34
+	CodeInfringingFile               = 35
+	CodeFairUsageLimit               = 36
+)
+
+// Errors to compare API errors with using errors.Is
+var (
+	ErrResourceNotFound       = &Error{ErrorCode: CodeResourceNotFound, Code: "resource_not_found"}
+	ErrBadToken               = &Error{ErrorCode: CodeBadToken, Code: "bad_token"}
+	ErrPermissionDenied       = &Error{ErrorCode: CodePermissionDenied, Code: "permission_denied"}
+	ErrTrafficExhausted       = &Error{ErrorCode: CodeTrafficExhausted, Code: "traffic_exhausted"}
+	ErrFileUnavailable        = &Error{ErrorCode: CodeFileUnavailable, Code: "file_unavailable"}
+	ErrTooManyActiveDownloads = &Error{ErrorCode: CodeTooManyActiveDownloads, Code: "too_many_active_downloads"}
+	ErrTooManyRequests        = &Error{ErrorCode: CodeTooManyRequests, Code: "too_many_requests"}
+	ErrInfringingFile         = &Error{ErrorCode: CodeInfringingFile, Code: "infringing_file"}
+	ErrFairUsageLimit         = &Error{ErrorCode: CodeFairUsageLimit, Code: "fair_usage_limit"}
+)
+
+// Error satisfies the error interface
+func (e *Error) Error() string {
+	s := "realdebrid error"
+	if e.Code != "" {
+		s += fmt.Sprintf(" %s (%d)", e.Code, e.ErrorCode)
+	}
+	if e.Details != "" {
+		s += ": " + e.Details
+	}
+	if e.StatusCode != 0 {
+		s += fmt.Sprintf(" [HTTP %d]", e.StatusCode)
+	}
+	return s
+}
+
+// Is returns whether target is an *Error with the same error code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && e.Code != "" && t.ErrorCode == e.ErrorCode
+}
+
+// Unwrap returns the Sentinel so errors.Is matches it too
+func (e *Error) Unwrap() error {
+	return e.Sentinel
+}
+
+// Temporary returns whether the call may succeed if tried again
+// shortly, which makes the pacer retry it
+func (e *Error) Temporary() bool {
+	switch e.ErrorCode {
+	case CodeSlowDown, CodeHosterTemporarilyUnavailable, CodeServiceUnavailable, CodeTooManyRequests:
+		return true
+	}
+	return false
+}
+
+// Retry returns whether the operation is worth retrying later as a
+// whole, e.g. once a download slot is free
+func (e *Error) Retry() bool {
+	switch e.ErrorCode {
+	case CodeHosterInMaintenance, CodeHosterLimitReached, CodeTooManyActiveDownloads:
+		return true
+	}
+	return e.Temporary()
+}
+
+// Fatal returns whether the error means the account can't be used at
+// all so there is no point in carrying on
+func (e *Error) Fatal() bool {
+	switch e.ErrorCode {
+	case CodeBadToken, CodeAccountLocked, CodeAccountNotActivated, CodeIPAddressNotAllowed:
+		return true
+	}
+	return false
+}
+
+// NoRetry returns whether retrying can never succeed
+func (e *Error) NoRetry() bool {
+	switch e.ErrorCode {
+	case CodeUnsupportedHoster, CodeHosterNotFree, CodeFileUnavailable, CodeFileNotAllowed,
+		CodeTorrentTooBig, CodeTorrentFileInvalid, CodeInfringingFile:
+		return true
+	}
+	return false
+}
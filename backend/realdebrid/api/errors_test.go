@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestError(t *testing.T) {
+	var e Error
+	require.NoError(t, json.Unmarshal([]byte(`{"error":"infringing_file","error_code":35}`), &e))
+	e.StatusCode = 503
+	assert.Equal(t, "realdebrid error infringing_file (35) [HTTP 503]", e.Error())
+
+	err := fmt.Errorf("couldn't unrestrict link: %w", &e)
+	assert.True(t, errors.Is(err, ErrInfringingFile))
+	assert.False(t, errors.Is(err, ErrBadToken))
+	assert.True(t, e.NoRetry())
+	assert.False(t, e.Retry())
+	assert.False(t, e.Fatal())
+
+	sentinel := errors.New("sentinel")
+	e = Error{Code: "bad_token", ErrorCode: CodeBadToken, Sentinel: sentinel}
+	assert.True(t, errors.Is(&e, ErrBadToken))
+	assert.True(t, errors.Is(&e, sentinel))
+	assert.True(t, e.Fatal())
+
+	e = Error{Code: "too_many_requests", ErrorCode: CodeTooManyRequests}
+	assert.True(t, e.Temporary())
+	assert.True(t, e.Retry())
+
+	// errors which didn't come from the API match nothing
+	e = Error{Details: "Bad Gateway", StatusCode: 502}
+	assert.False(t, errors.Is(&e, &Error{}))
+}
@@ -39,7 +39,9 @@ type Item struct {
 	OriginalLink    string       `json:"link,omitempty"`
 	Name            string       `json:"filename,omitempty"`
 	Size            int64        `json:"filesize,omitempty"`
+	Bytes           int64        `json:"bytes,omitempty"`
 	Status          string       `json:"status,omitempty"`
+	Progress        float64      `json:"progress,omitempty"`
 	StreamLink      string       ``
 	Type            string       `json:"type,omitempty"`
 	TranscodeStatus string       ``
@@ -47,14 +49,19 @@ type Item struct {
 	MimeType        string       `json:"mimeType,omitempty"`
 	Ended           string       `json:"added,omitempty"`
 	Generated       string       `json:"generated,omitempty"`
+	LinkGenerated   string       ``
 	Links           []string     `json:"links,omitempty"`
 	Files           []File       `json:"files,omitempty"`
 	TorrentHash     string       `json:"hash,omitempty"`
+	FileID          int64        `json:"-"`                 // RealDebrid ID of a file in its torrent, 0 if not known
+	Account         int          `json:"account,omitempty"` // index of the API key the item belongs to
 }
 
 type File struct {
-	ID       int64 `json:"id,omitempty"`
-	Selected int64 `json:"selected,omitempty"`
+	ID       int64  `json:"id,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Bytes    int64  `json:"bytes,omitempty"`
+	Selected int64  `json:"selected,omitempty"`
 }
 
 // Breadcrumb is part the breadcrumb trail for a file or folder.  It
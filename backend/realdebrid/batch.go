@@ -0,0 +1,59 @@
+package realdebrid
+
+import (
+	"context"
+	"net/url"
+	"sync"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// unrestrictBatch unrestricts links[i] into items[i] for each i in
+// batch, unrestrict_concurrency at a time, returning the HTTP status
+// and error of each call by index
+//
+// RealDebrid has no call to unrestrict several links at once so the
+// calls are pipelined instead, sharing the pacer.
+func (f *Fs) unrestrictBatch(ctx context.Context, links []string, batch []int, items []api.Item) (codes []int, errs []error) {
+	codes = make([]int, len(links))
+	errs = make([]error, len(links))
+	if len(batch) == 0 {
+		return codes, errs
+	}
+	concurrency := f.opt.UnrestrictConc
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	tokens := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, index := range batch {
+		index := index
+		tokens <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+			opts := rest.Opts{
+				Method: "POST",
+				Path:   "/unrestrict/link",
+				MultipartParams: url.Values{
+					"link": {links[index]},
+				},
+				Parameters: f.baseParams(),
+			}
+			errs[index] = f.pacer.Call(func() (bool, error) {
+				items[index] = api.Item{}
+				resp, err := f.srv.CallJSON(ctx, &opts, nil, &items[index])
+				if resp != nil {
+					codes[index] = resp.StatusCode
+				}
+				return shouldRetry(ctx, resp, err)
+			})
+		}()
+	}
+	wg.Wait()
+	return codes, errs
+}
@@ -0,0 +1,196 @@
+package realdebrid
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// Conflict policies for files which end up with the same name in a
+// directory
+const (
+	conflictKeepBoth    = "keep-both"
+	conflictKeepNewest  = "keep-newest"
+	conflictKeepLargest = "keep-largest"
+	conflictError       = "error"
+)
+
+// conflictEntry is one of the files involved in a conflict
+type conflictEntry struct {
+	Name      string `json:"name"`
+	TorrentID string `json:"torrent_id,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Size      int64  `json:"size"`
+	Kept      bool   `json:"kept"`
+}
+
+// conflict describes a set of files which mapped to the same name
+type conflict struct {
+	Path    string          `json:"path"`
+	Policy  string          `json:"policy"`
+	Entries []conflictEntry `json:"entries"`
+}
+
+// shortHash returns a short identifier for item which is stable
+// between listings
+func shortHash(item *api.Item) string {
+	id := item.TorrentHash
+	if id == "" {
+		id = item.ID
+	}
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	return id
+}
+
+// suffixName inserts suffix before the extension of name, or at the
+// end if item is a folder
+func suffixName(item *api.Item, suffix string) string {
+	if item.Type == api.ItemTypeFolder {
+		return item.Name + " [" + suffix + "]"
+	}
+	ext := path.Ext(item.Name)
+	return strings.TrimSuffix(item.Name, ext) + " [" + suffix + "]" + ext
+}
+
+// resolveConflicts applies the conflict_policy to files in items which
+// share the same name, recording what it did against dirID.
+//
+// Folders are never hidden, e.g. two torrents of the same name, and
+// neither is anything in a locked folder, so if a folder is involved
+// all the items are kept with the newer ones getting the short hash of
+// their torrent added to their names.
+//
+// It returns the items which should be shown in the directory.
+func (f *Fs) resolveConflicts(dirID string, items []api.Item) ([]api.Item, error) {
+	groups := map[string][]int{}
+	var names []string
+	for i := range items {
+		key := f.nameKey(items[i].Name)
+		if _, ok := groups[key]; !ok {
+			names = append(names, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+	var conflicts []conflict
+	drop := map[int]bool{}
+	for _, key := range names {
+		group := groups[key]
+		if len(group) < 2 {
+			continue
+		}
+		// Oldest first so the original owner of a name keeps it
+		sort.SliceStable(group, func(a, b int) bool {
+			return items[group[a]].CreatedAt < items[group[b]].CreatedAt
+		})
+		keep := group[0]
+		policy := f.opt.ConflictPolicy
+		if f.dirLocked(dirID) {
+			// nothing is hidden in a locked folder
+			policy = conflictKeepBoth
+		}
+		for _, i := range group {
+			if items[i].Type == api.ItemTypeFolder {
+				policy = conflictKeepBoth
+			}
+		}
+		switch policy {
+		case conflictError:
+			return nil, fmt.Errorf("%d files named %q in the same directory", len(group), items[keep].Name)
+		case conflictKeepNewest:
+			for _, i := range group {
+				if items[i].CreatedAt >= items[keep].CreatedAt {
+					keep = i
+				}
+			}
+		case conflictKeepLargest:
+			for _, i := range group {
+				if items[i].Size > items[keep].Size {
+					keep = i
+				}
+			}
+		}
+		c := conflict{
+			Policy: policy,
+		}
+		for _, i := range group {
+			item := &items[i]
+			kept := i == keep || policy == conflictKeepBoth
+			if kept && i != keep {
+				item.Name = suffixName(item, shortHash(item))
+			}
+			if !kept {
+				drop[i] = true
+			}
+			torrentID := item.ParentID
+			if item.Type == api.ItemTypeFolder {
+				torrentID = item.ID
+			}
+			c.Entries = append(c.Entries, conflictEntry{
+				Name:      item.Name,
+				TorrentID: torrentID,
+				Hash:      item.TorrentHash,
+				Size:      item.Size,
+				Kept:      kept,
+			})
+		}
+		fs.Debugf(f, "Resolved conflict on %q using %s", items[keep].Name, policy)
+		conflicts = append(conflicts, c)
+	}
+	f.conflictsMu.Lock()
+	if len(conflicts) > 0 {
+		f.conflicts[dirID] = conflicts
+	} else {
+		delete(f.conflicts, dirID)
+	}
+	f.conflictsMu.Unlock()
+	if len(drop) == 0 {
+		return items, nil
+	}
+	kept := items[:0]
+	for i := range items {
+		if !drop[i] {
+			kept = append(kept, items[i])
+		}
+	}
+	return kept, nil
+}
+
+// listConflicts walks the directory tree under dir and returns all the
+// name conflicts found
+func (f *Fs) listConflicts(ctx context.Context, dir string) (out []conflict, err error) {
+	entries, err := f.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	dirID, err := f.dirCache.FindDir(ctx, dir, false)
+	if err != nil {
+		return nil, err
+	}
+	f.conflictsMu.Lock()
+	for _, c := range f.conflicts[dirID] {
+		c.Path = dir
+		out = append(out, c)
+	}
+	f.conflictsMu.Unlock()
+	for _, entry := range entries {
+		if d, ok := entry.(fs.Directory); ok {
+			if d.ID() == byHashDirID || d.ID() == recentDirID || d.ID() == unselectedDirID {
+				// same torrents again
+				continue
+			}
+			sub, err := f.listConflicts(ctx, d.Remote())
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, sub...)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,217 @@
+package realdebrid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// coordinationTTL is how long a lease in the coordination_file lasts
+// unless it is renewed
+const coordinationTTL = 30 * time.Minute
+
+// lockSuffix is added to the name of the coordination_file for the
+// lock file held while the lease is changed
+const lockSuffix = ".lock"
+
+// lockStale is how old a lock file has to be before it is taken to be
+// left behind by an instance which crashed while holding it. The lock
+// is only held for the few file operations changing the lease.
+const lockStale = time.Minute
+
+// errLockBusy is returned by lock if another instance holds the lock
+var errLockBusy = errors.New("coordination_file is locked by another instance")
+
+// errNotLeader is returned by anything which would change the library
+// state on an instance which isn't the one saving it
+var errNotLeader = errors.New("not allowed as another instance sharing the coordination_file keeps the library state")
+
+// lease is the record kept in the coordination_file
+type lease struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// coordinator makes sure only one of several instances sharing an
+// account does maintenance at a time by holding a lease in a file on
+// storage they all share
+type coordinator struct {
+	mu       sync.Mutex
+	fileName string
+	owner    string // identifies this instance
+	leader   bool   // whether we held the lease last time we looked
+}
+
+// coordinators holds the coordinators by file name so all the Fs in
+// this process act as one instance
+var (
+	coordinatorsMu sync.Mutex
+	coordinators   = map[string]*coordinator{}
+)
+
+// getCoordinator returns the coordinator using fileName, returning nil
+// if it is empty
+func getCoordinator(fileName string) *coordinator {
+	if fileName == "" {
+		return nil
+	}
+	coordinatorsMu.Lock()
+	defer coordinatorsMu.Unlock()
+	if c, ok := coordinators[fileName]; ok {
+		return c
+	}
+	host, _ := os.Hostname()
+	c := &coordinator{
+		fileName: fileName,
+		owner:    fmt.Sprintf("%s:%d:%d", host, os.Getpid(), time.Now().UnixNano()),
+	}
+	coordinators[fileName] = c
+	return c
+}
+
+// readLease reads the lease in the file, returning the zero lease if
+// there isn't one
+func (c *coordinator) readLease() (l lease, err error) {
+	data, err := ioutil.ReadFile(c.fileName)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return l, err
+	}
+	err = json.Unmarshal(data, &l)
+	if err != nil {
+		// treat a corrupt file as no lease so it gets replaced
+		fs.Debugf(nil, "realdebrid: ignoring corrupt coordination_file: %v", err)
+		return lease{}, nil
+	}
+	return l, nil
+}
+
+// isLeaderAt returns whether this instance holds the lease at now,
+// taking or renewing it if possible
+//
+// A nil coordinator is always the leader.
+func (c *coordinator) isLeaderAt(now time.Time) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	leader, err := c.acquire(now)
+	if err != nil {
+		fs.Errorf(nil, "realdebrid: coordination_file: %v", err)
+		leader = false
+	}
+	if leader != c.leader {
+		if leader {
+			fs.Infof(nil, "realdebrid: this instance now does the maintenance of the library")
+		} else {
+			fs.Infof(nil, "realdebrid: another instance does the maintenance of the library")
+		}
+		c.leader = leader
+	}
+	return leader
+}
+
+// lock takes the lock file next to the coordination_file, which only
+// one instance can create, returning the function to drop it again
+//
+// A lock file older than lockStale is removed and taken over.
+func (c *coordinator) lock() (unlock func(), err error) {
+	name := c.fileName + lockSuffix
+	for tries := 0; tries < 2; tries++ {
+		fh, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_, _ = fh.WriteString(c.owner)
+			_ = fh.Close()
+			return func() { _ = os.Remove(name) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		info, err := os.Stat(name)
+		if err != nil || time.Since(info.ModTime()) < lockStale {
+			return nil, errLockBusy
+		}
+		fs.Logf(nil, "realdebrid: removing stale lock file %q", name)
+		_ = os.Remove(name)
+	}
+	return nil, errLockBusy
+}
+
+// acquire takes or renews the lease if it is free, expired or ours
+//
+// The lease is only changed while holding the lock file so two
+// instances can't both take it. If another instance holds the lock
+// the lease is only read.
+func (c *coordinator) acquire(now time.Time) (bool, error) {
+	unlock, err := c.lock()
+	if errors.Is(err, errLockBusy) {
+		l, err := c.readLease()
+		if err != nil {
+			return false, err
+		}
+		return l.Owner == c.owner && now.Before(l.Expires), nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	l, err := c.readLease()
+	if err != nil {
+		return false, err
+	}
+	if l.Owner != "" && l.Owner != c.owner && now.Before(l.Expires) {
+		return false, nil
+	}
+	data, err := json.Marshal(lease{Owner: c.owner, Expires: now.Add(coordinationTTL)})
+	if err != nil {
+		return false, err
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", c.fileName, os.Getpid())
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err == nil {
+		err = os.Rename(tmp, c.fileName)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
+
+// release gives up the lease if we hold it
+func (c *coordinator) release() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leader = false
+	unlock, err := c.lock()
+	if err != nil {
+		return
+	}
+	defer unlock()
+	l, err := c.readLease()
+	if err == nil && l.Owner == c.owner {
+		_ = os.Remove(c.fileName)
+	}
+}
+
+// checkLeader returns errNotLeader if another instance sharing the
+// coordination_file keeps the library state, as changes to it made
+// here wouldn't be saved
+func (f *Fs) checkLeader() error {
+	if !f.coord.isLeaderAt(time.Now()) {
+		return errNotLeader
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package realdebrid
+
+import (
+	"io"
+	"sort"
+)
+
+// plays counts how many times each file was opened from the start and
+// finished how many times it was read through to the end, by
+// modTimeKey. Both are protected by openedMu.
+var plays = map[string]int64{}
+var finished = map[string]int64{}
+
+// fileCount is how often a file was played and finished
+type fileCount struct {
+	File     string `json:"file"`
+	Plays    int64  `json:"plays"`
+	Finished int64  `json:"finished"`
+}
+
+// countPlay records that fileKey was opened from the start
+//
+// Opens at other offsets are seeks or the later chunks of a read so
+// they aren't counted.
+func countPlay(fileKey string, offset int64) {
+	if fileKey == "" || offset != 0 {
+		return
+	}
+	openedMu.Lock()
+	plays[fileKey]++
+	openedMu.Unlock()
+}
+
+// countFinished records that fileKey was read through to the end
+func countFinished(fileKey string) {
+	if fileKey == "" {
+		return
+	}
+	openedMu.Lock()
+	finished[fileKey]++
+	openedMu.Unlock()
+}
+
+// fileCounts returns the counts of the files played most, at most max
+// of them
+func fileCounts(max int) (out []fileCount) {
+	openedMu.Lock()
+	for file, n := range plays {
+		out = append(out, fileCount{File: file, Plays: n, Finished: finished[file]})
+	}
+	for file, n := range finished {
+		if _, ok := plays[file]; !ok {
+			out = append(out, fileCount{File: file, Finished: n})
+		}
+	}
+	openedMu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Plays != out[j].Plays {
+			return out[i].Plays > out[j].Plays
+		}
+		if out[i].Finished != out[j].Finished {
+			return out[i].Finished > out[j].Finished
+		}
+		return out[i].File < out[j].File
+	})
+	if len(out) > max {
+		out = out[:max]
+	}
+	return out
+}
+
+// finishCounter counts its file as finished when reading it reaches
+// the end of the file
+type finishCounter struct {
+	io.ReadCloser
+	fileKey string
+	offset  int64 // offset of the next byte to read
+	size    int64 // size of the file
+	counted bool
+}
+
+// Read reads from the download, counting the file once at the end
+func (r *finishCounter) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && !r.counted && r.size > 0 && r.offset >= r.size {
+		r.counted = true
+		countFinished(r.fileKey)
+	}
+	return n, err
+}
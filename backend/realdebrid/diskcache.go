@@ -0,0 +1,366 @@
+package realdebrid
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// cacheBlockSize is the size of the blocks files are cached in
+const cacheBlockSize = 4 * 1024 * 1024
+
+// blockCache is a size limited least recently used cache of blocks of
+// files on local disk
+//
+// mu only protects the index of the blocks so blocks are read and
+// written without holding it. Blocks are written to a temporary file
+// which is renamed into place so they are never seen half written.
+type blockCache struct {
+	dir     string
+	maxSize int64
+	mu      sync.Mutex
+	size    int64                    // total size of the blocks
+	lru     *list.List               // of *cacheBlock, most recently used first
+	blocks  map[string]*list.Element // by block name
+}
+
+// cacheBlock is a block stored in the cache
+type cacheBlock struct {
+	name string
+	size int64
+}
+
+// blockCaches holds the caches by directory so Fs using the same
+// directory share one
+var (
+	blockCachesMu sync.Mutex
+	blockCaches   = map[string]*blockCache{}
+)
+
+// getBlockCache returns the cache in dir, creating it if needed
+func getBlockCache(dir string, maxSize int64) (*blockCache, error) {
+	blockCachesMu.Lock()
+	defer blockCachesMu.Unlock()
+	if c, ok := blockCaches[dir]; ok {
+		return c, nil
+	}
+	c, err := newBlockCache(dir, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	blockCaches[dir] = c
+	return c, nil
+}
+
+// newBlockCache makes a cache in dir, picking up the blocks already
+// there with the least recently modified ones evicted first
+func newBlockCache(dir string, maxSize int64) (*blockCache, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make disk cache directory: %w", err)
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read disk cache directory: %w", err)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ModTime().After(infos[j].ModTime())
+	})
+	c := &blockCache{
+		dir:     dir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		blocks:  map[string]*list.Element{},
+	}
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if filepath.Ext(info.Name()) == ".tmp" {
+			// left by a write which didn't finish
+			_ = os.Remove(filepath.Join(dir, info.Name()))
+			continue
+		}
+		c.blocks[info.Name()] = c.lru.PushBack(&cacheBlock{name: info.Name(), size: info.Size()})
+		c.size += info.Size()
+	}
+	c.mu.Lock()
+	evicted := c.evict()
+	c.mu.Unlock()
+	c.removeFiles(evicted)
+	return c, nil
+}
+
+// cacheKey returns the key of the blocks of o in the cache
+//
+// It is made of the modTimeKey of o, which has the RealDebrid file ID
+// for files of torrents, and its size so blocks kept from before a
+// file changed or a key was reused aren't read back for another file.
+func cacheKey(o *Object) string {
+	return fmt.Sprintf("%s:%d", o.fileKey(), o.size)
+}
+
+// blockName returns the name of the block at index of the file key
+func blockName(key string, index int64) string {
+	sum := sha1.Sum([]byte(key))
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), index)
+}
+
+// get returns the block called name if it is cached
+func (c *blockCache) get(name string) ([]byte, bool) {
+	c.mu.Lock()
+	e, ok := c.blocks[name]
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(filepath.Join(c.dir, name))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.blocks[name] != e {
+		// evicted or replaced while it was read
+		return data, err == nil
+	}
+	if err != nil {
+		fs.Debugf(nil, "disk cache: dropping unreadable block %q: %v", name, err)
+		c.remove(e)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return data, true
+}
+
+// put stores data as the block called name
+func (c *blockCache) put(name string, data []byte) {
+	c.mu.Lock()
+	_, ok := c.blocks[name]
+	c.mu.Unlock()
+	if ok {
+		return
+	}
+	err := c.write(name, data)
+	if err != nil {
+		fs.Errorf(nil, "disk cache: failed to store block: %v", err)
+		return
+	}
+	c.mu.Lock()
+	if _, ok := c.blocks[name]; ok {
+		// stored at the same time by another reader
+		c.mu.Unlock()
+		return
+	}
+	c.blocks[name] = c.lru.PushFront(&cacheBlock{name: name, size: int64(len(data))})
+	c.size += int64(len(data))
+	evicted := c.evict()
+	c.mu.Unlock()
+	c.removeFiles(evicted)
+}
+
+// write writes data to a temporary file and renames it to the block
+// called name
+func (c *blockCache) write(name string, data []byte) error {
+	fh, err := ioutil.TempFile(c.dir, name+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(data)
+	closeErr := fh.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(fh.Name(), filepath.Join(c.dir, name))
+	}
+	if err != nil {
+		_ = os.Remove(fh.Name())
+	}
+	return err
+}
+
+// remove takes the block in e out of the cache, returning the name of
+// its file for removeFiles
+//
+// Call with mu held.
+func (c *blockCache) remove(e *list.Element) string {
+	b := c.lru.Remove(e).(*cacheBlock)
+	delete(c.blocks, b.name)
+	c.size -= b.size
+	return b.name
+}
+
+// removeFiles deletes the files of the blocks called names
+//
+// Call without mu held.
+func (c *blockCache) removeFiles(names []string) {
+	for _, name := range names {
+		err := os.Remove(filepath.Join(c.dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			fs.Errorf(nil, "disk cache: failed to remove block: %v", err)
+		}
+	}
+}
+
+// invalidate removes all the blocks of the file key which was size
+// bytes long
+func (c *blockCache) invalidate(key string, size int64) {
+	var removed []string
+	c.mu.Lock()
+	for index := int64(0); index*cacheBlockSize < size; index++ {
+		if e, ok := c.blocks[blockName(key, index)]; ok {
+			removed = append(removed, c.remove(e))
+		}
+	}
+	c.mu.Unlock()
+	c.removeFiles(removed)
+}
+
+// evict takes the least recently used blocks out of the cache until it
+// fits in maxSize, returning their names for removeFiles
+//
+// Call with mu held.
+func (c *blockCache) evict() (evicted []string) {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		evicted = append(evicted, c.remove(c.lru.Back()))
+	}
+	return evicted
+}
+
+// cacheReader reads an object through the disk cache a block at a time
+type cacheReader struct {
+	ctx     context.Context
+	o       *Object
+	cache   *blockCache
+	key     string          // identifies the file in the cache
+	options []fs.OpenOption // passed on when blocks are downloaded
+	offset  int64           // offset of the next byte to read
+	end     int64           // offset after the last byte to read
+	buf     []byte          // rest of the current block
+}
+
+// newCacheReader reads o from offset for limit bytes, or to the end
+// if limit is -1
+//
+// options are the options for the download without the range, which
+// is set for each block.
+func newCacheReader(ctx context.Context, o *Object, cache *blockCache, options []fs.OpenOption, offset, limit int64) *cacheReader {
+	end := o.size
+	if limit >= 0 && offset+limit < end {
+		end = offset + limit
+	}
+	return &cacheReader{
+		ctx:     ctx,
+		o:       o,
+		cache:   cache,
+		key:     cacheKey(o),
+		options: options,
+		offset:  offset,
+		end:     end,
+	}
+}
+
+// Read bytes from the cache, fetching blocks which aren't cached
+func (r *cacheReader) Read(p []byte) (n int, err error) {
+	if len(r.buf) == 0 {
+		if r.offset >= r.end {
+			return 0, io.EOF
+		}
+		index := r.offset / cacheBlockSize
+		block, err := r.block(index)
+		if err != nil {
+			return 0, err
+		}
+		start := r.offset - index*cacheBlockSize
+		if start >= int64(len(block)) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		block = block[start:]
+		if remaining := r.end - r.offset; int64(len(block)) > remaining {
+			block = block[:remaining]
+		}
+		r.buf = block
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.offset += int64(n)
+	return n, nil
+}
+
+// block returns the block at index from the cache or downloads it
+func (r *cacheReader) block(index int64) ([]byte, error) {
+	name := blockName(r.key, index)
+	if data, ok := r.cache.get(name); ok {
+		return data, nil
+	}
+	start := index * cacheBlockSize
+	end := start + cacheBlockSize
+	if end > r.o.size {
+		end = r.o.size
+	}
+	downloadURL := r.o.url
+	if url, ok := r.o.fs.validURL(r.o.originalLink, time.Now()); ok {
+		downloadURL = url
+	}
+	data, err := r.fetch(downloadURL, start, end)
+	if err != nil && r.o.originalLink != "" && r.ctx.Err() == nil {
+		fs.Debugf(r.o, "disk cache: retrying block %d from a new download link: %v", index, err)
+		item, unrestrictErr := r.o.fs.unrestrict(r.ctx, r.o.originalLink)
+		if unrestrictErr != nil {
+			return nil, unrestrictErr
+		}
+		if sizeErr := r.o.checkSize(item); sizeErr != nil {
+			return nil, sizeErr
+		}
+		data, err = r.fetch(item.Link, start, end)
+		if err == nil {
+			r.o.fs.rememberURL(r.o.originalLink, item.Link, time.Now())
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.cache.put(name, data)
+	return data, nil
+}
+
+// fetch downloads the bytes from start up to end of downloadURL
+//
+// The download takes a download slot while it runs so max_downloads
+// holds for files read through the cache too.
+func (r *cacheReader) fetch(downloadURL string, start, end int64) ([]byte, error) {
+	if err := r.o.fs.slots.acquire(r.ctx); err != nil {
+		return nil, err
+	}
+	defer r.o.fs.slots.release()
+	options := append(append([]fs.OpenOption(nil), r.options...), &fs.RangeOption{Start: start, End: end - 1})
+	in, err := r.o.download(r.ctx, downloadURL, options)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, end-start)
+	_, err = io.ReadFull(in, data)
+	closeErr := in.Close()
+	if err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+	return data, nil
+}
+
+// Close the reader
+func (r *cacheReader) Close() error {
+	r.buf = nil
+	return nil
+}
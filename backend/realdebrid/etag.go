@@ -0,0 +1,85 @@
+package realdebrid
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxValidators is the number of entries in validators above which
+// they are all dropped
+const maxValidators = 10000
+
+// validator holds the cache validators a download server returned for
+// a direct link
+type validator struct {
+	etag         string // ETag header
+	lastModified string // Last-Modified header
+	size         int64  // size of the whole file or -1 if unknown
+}
+
+// validators holds the validators of direct links by link, so checking
+// a link again can be a conditional request which the server answers
+// with 304 Not Modified if nothing changed
+var validators = map[string]validator{}
+var validatorsMu sync.Mutex
+
+// responseSize returns the size of the whole file resp is for, or -1
+// if it isn't known
+func responseSize(resp *http.Response) int64 {
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp.ContentLength
+	}
+	// Content-Range: bytes 0-99/1234
+	contentRange := resp.Header.Get("Content-Range")
+	i := strings.LastIndexByte(contentRange, '/')
+	if i < 0 {
+		return -1
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// rememberValidator records the validators resp returned for url, if
+// it has any
+func rememberValidator(url string, resp *http.Response) {
+	v := validator{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		size:         responseSize(resp),
+	}
+	if v.etag == "" && v.lastModified == "" {
+		return
+	}
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	if len(validators) > maxValidators {
+		validators = map[string]validator{}
+	}
+	validators[url] = v
+}
+
+// storedValidator returns the validators stored for url, if any
+func storedValidator(url string) (validator, bool) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	v, ok := validators[url]
+	return v, ok
+}
+
+// conditionalHeaders returns the headers making a request for url
+// conditional on the stored validators, or nil if there are none
+func conditionalHeaders(url string) map[string]string {
+	v, ok := storedValidator(url)
+	if !ok {
+		return nil
+	}
+	if v.etag != "" {
+		return map[string]string{"If-None-Match": v.etag}
+	}
+	return map[string]string{"If-Modified-Since": v.lastModified}
+}
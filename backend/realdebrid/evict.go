@@ -0,0 +1,157 @@
+package realdebrid
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// Eviction policies for max_torrents
+const (
+	evictOldestUnwatched = "oldest-unwatched-first"
+	evictLargest         = "largest-first"
+)
+
+// opened records when a file of each torrent was last opened by
+// torrent ID and accessed when each file was by modTimeKey. Both are
+// protected by openedMu.
+var opened = map[string]int64{}
+var accessed = map[string]int64{}
+var openedMu sync.Mutex
+
+// markOpened records that the file fileKey of torrentID was opened
+func markOpened(torrentID, fileKey string) {
+	now := time.Now().Unix()
+	openedMu.Lock()
+	if torrentID != "" {
+		opened[torrentID] = now
+	}
+	if fileKey != "" {
+		accessed[fileKey] = now
+	}
+	openedMu.Unlock()
+}
+
+// addedAt returns when torrent was added as a unix time
+func addedAt(torrent *api.Item) int64 {
+	t, err := time.Parse(time.RFC3339, torrent.Ended)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+// evictionOrder returns the indexes of torrents in the order they
+// should be evicted by policy
+func evictionOrder(torrents []api.Item, policy string) []int {
+	order := make([]int, len(torrents))
+	for i := range order {
+		order[i] = i
+	}
+	openedMu.Lock()
+	lastOpened := func(i int) int64 {
+		return opened[torrents[i].ID]
+	}
+	switch policy {
+	case evictLargest:
+		sort.SliceStable(order, func(a, b int) bool {
+			return torrents[order[a]].Bytes > torrents[order[b]].Bytes
+		})
+	default:
+		// never opened first, then least recently opened, oldest
+		// first within the same time
+		sort.SliceStable(order, func(a, b int) bool {
+			oa, ob := lastOpened(order[a]), lastOpened(order[b])
+			if oa != ob {
+				return oa < ob
+			}
+			return addedAt(&torrents[order[a]]) < addedAt(&torrents[order[b]])
+		})
+	}
+	openedMu.Unlock()
+	return order
+}
+
+// deleteTorrent deletes the torrent with ID id from the account
+func (f *Fs) deleteTorrent(ctx context.Context, id string) error {
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	opts := rest.Opts{
+		Method:     "DELETE",
+		Path:       "/torrents/delete/" + id,
+		Parameters: f.baseParams(),
+		NoResponse: true,
+	}
+	var resp *http.Response
+	err := f.pacer.Call(func() (bool, error) {
+		var err error
+		resp, err = f.srv.Call(ctx, &opts)
+		return shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't delete torrent %q: %w", id, err)
+	}
+	markRemoved(id)
+	return nil
+}
+
+// evictTorrents deletes torrents chosen by eviction_policy until there
+// are no more than max_torrents left, leaving those in locked folders
+// alone
+//
+// The torrents are deleted without holding listMu and a copy of the
+// list without them is swapped in afterwards.
+//
+// Call without listMu held.
+func (f *Fs) evictTorrents(ctx context.Context) {
+	listMu.RLock()
+	list := append([]api.Item(nil), torrents...)
+	listMu.RUnlock()
+	excess := len(list) - f.opt.MaxTorrents
+	if f.opt.MaxTorrents <= 0 || excess <= 0 || f.opt.ReadOnly {
+		return
+	}
+	evict := map[string]bool{}
+	for _, i := range evictionOrder(list, f.opt.EvictionPolicy) {
+		torrent := list[i]
+		if excess == 0 {
+			break
+		}
+		if f.torrentLocked(torrent.Name) {
+			continue
+		}
+		excess--
+		if operations.SkipDestructive(ctx, torrent.Name, "evict torrent") {
+			continue
+		}
+		err := f.deleteTorrent(ctx, torrent.ID)
+		if err != nil {
+			fs.Errorf(f, "Failed to evict torrent %q: %v", torrent.Name, err)
+			continue
+		}
+		fs.Logf(f, "Evicted torrent %q to stay within max_torrents %d", torrent.Name, f.opt.MaxTorrents)
+		f.runHook(eventRemove, &torrent)
+		evict[torrent.ID] = true
+	}
+	if len(evict) == 0 {
+		return
+	}
+	listMu.Lock()
+	kept := make([]api.Item, 0, len(torrents))
+	for _, torrent := range torrents {
+		if !evict[torrent.ID] {
+			kept = append(kept, torrent)
+		}
+	}
+	torrents = kept
+	listMu.Unlock()
+}
@@ -0,0 +1,90 @@
+package realdebrid
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// fileIDs holds the RealDebrid IDs of the selected files of each
+// torrent by torrent ID, in the order of the links of the torrent.
+//
+// Files of torrents are identified by their file ID in modTimeKey. The
+// position of a file among the links can't be used as it changes when
+// more files of the torrent are selected, the file ID doesn't.
+var fileIDs = map[string][]int64{}
+var fileIDsMu sync.Mutex
+
+// selectedIDs returns the IDs of the selected files in files in the
+// order RealDebrid gives the links of them
+func selectedIDs(files []api.File) (ids []int64) {
+	for _, file := range files {
+		if file.Selected == 1 {
+			ids = append(ids, file.ID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// torrentFileIDs returns the file IDs of the links of torrent, reading
+// the files of the torrent if they aren't known yet. It returns nil if
+// they can't be read or don't match the links.
+//
+// Call without listMu held.
+func (f *Fs) torrentFileIDs(ctx context.Context, torrent *api.Item) []int64 {
+	if len(torrent.Links) == 0 {
+		return nil
+	}
+	fileIDsMu.Lock()
+	ids, ok := fileIDs[torrent.ID]
+	fileIDsMu.Unlock()
+	if ok && len(ids) == len(torrent.Links) {
+		return ids
+	}
+	files, err := f.torrentFileList(ctx, torrent.ID)
+	if err != nil {
+		fs.Debugf(f, "Torrent %q: %v", torrent.Name, err)
+		return nil
+	}
+	ids = selectedIDs(files)
+	if len(ids) != len(torrent.Links) {
+		fs.Debugf(f, "Torrent %q: %d selected files for %d links", torrent.Name, len(ids), len(torrent.Links))
+		return nil
+	}
+	fileIDsMu.Lock()
+	fileIDs[torrent.ID] = ids
+	fileIDsMu.Unlock()
+	adoptPositionKeys(torrent.TorrentHash, ids)
+	return ids
+}
+
+// copyFileIDs returns a copy of fileIDs
+func copyFileIDs() map[string][]int64 {
+	fileIDsMu.Lock()
+	defer fileIDsMu.Unlock()
+	out := make(map[string][]int64, len(fileIDs))
+	for id, ids := range fileIDs {
+		out[id] = append([]int64(nil), ids...)
+	}
+	return out
+}
+
+// pruneFileIDs forgets the file IDs of the torrents which aren't in
+// current, the torrents in the library
+func pruneFileIDs(current []api.Item) {
+	live := make(map[string]bool, len(current))
+	for _, torrent := range current {
+		live[torrent.ID] = true
+	}
+	fileIDsMu.Lock()
+	defer fileIDsMu.Unlock()
+	for id := range fileIDs {
+		if !live[id] {
+			delete(fileIDs, id)
+		}
+	}
+}
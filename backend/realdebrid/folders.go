@@ -0,0 +1,174 @@
+package realdebrid
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// ruleFolderPrefix starts the IDs of the folders made by
+// regex_folders, followed by their path
+const ruleFolderPrefix = ".folder/"
+
+// categoryFolders are the folders torrents are sorted into without
+// regex_folders
+var categoryFolders = []string{"shows", "movies", "default"}
+
+// ruleFolder is a destination of regex_folders
+type ruleFolder struct {
+	path string         // where matching torrents go, e.g. "shows/anime"
+	re   *regexp.Regexp // matches the torrent names
+}
+
+// cleanDir returns dir in the canonical form of folder paths: cleaned,
+// without leading or trailing slashes, and "" for the root
+func cleanDir(dir string) string {
+	return strings.Trim(path.Clean("/"+dir), "/")
+}
+
+// parentDir returns the canonical path of the folder dir is in
+func parentDir(dir string) string {
+	return cleanDir(path.Dir(cleanDir(dir)))
+}
+
+// isInDir returns whether the folder dir is parent or inside it
+func isInDir(dir, parent string) bool {
+	dir, parent = cleanDir(dir), cleanDir(parent)
+	return parent == "" || dir == parent || strings.HasPrefix(dir, parent+"/")
+}
+
+// parseRuleFolders parses the "path=regex" entries of regex_folders
+func parseRuleFolders(entries fs.CommaSepList) (out []ruleFolder, err error) {
+	for _, entry := range entries {
+		i := strings.IndexByte(entry, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("bad regex_folders entry %q - want path=regex", entry)
+		}
+		dir := cleanDir(strings.TrimSpace(entry[:i]))
+		if dir == "" || strings.HasPrefix(dir, ".") {
+			return nil, fmt.Errorf("bad regex_folders path in %q", entry)
+		}
+		re, err := regexp.Compile(entry[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("bad regex_folders regex in %q: %w", entry, err)
+		}
+		out = append(out, ruleFolder{path: dir, re: re})
+	}
+	return out, nil
+}
+
+// isCategoryFolder returns whether dir is one of the categoryFolders
+func isCategoryFolder(dir string) bool {
+	for _, c := range categoryFolders {
+		if dir == c {
+			return true
+		}
+	}
+	return false
+}
+
+// folderID returns the directory ID of the sorting folder at dir
+func folderID(dir string) string {
+	if isCategoryFolder(dir) {
+		return dir
+	}
+	return ruleFolderPrefix + dir
+}
+
+// isFolderID returns whether dirID is a sorting folder, returning its
+// path if so
+func isFolderID(dirID string) (dir string, ok bool) {
+	if isCategoryFolder(dirID) {
+		return dirID, true
+	}
+	if strings.HasPrefix(dirID, ruleFolderPrefix) {
+		return strings.TrimPrefix(dirID, ruleFolderPrefix), true
+	}
+	return "", false
+}
+
+// subFolders returns the folders made by regex_folders, including the
+// intermediate ones, directly inside parent which is "" for the root
+//
+// The categoryFolders are left out of the root as they are always
+// there.
+func (f *Fs) subFolders(parent string) (result []api.Item) {
+	seen := map[string]bool{}
+	for _, rule := range f.ruleFolders() {
+		for dir := rule.path; dir != ""; dir = parentDir(dir) {
+			if parentDir(dir) != parent || seen[dir] || (parent == "" && isCategoryFolder(dir)) {
+				continue
+			}
+			seen[dir] = true
+			result = append(result, api.Item{
+				ID:        folderID(dir),
+				Name:      path.Base(dir),
+				Type:      api.ItemTypeFolder,
+				Generated: "2006-01-02T15:04:05.000Z",
+			})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// folderItems returns the contents of the sorting folder at dir: the
+// regex_folders inside it, the torrents sorted into it and, with the
+// merged root_layout or sort_downloads, the downloads sorted into it
+//
+// The torrents flatten_single shows as their file are returned in flat
+// instead, for flatFiles to list once listMu is released.
+//
+// Call with listMu held.
+func (f *Fs) folderItems(dir string) (result, flat []api.Item) {
+	result = f.subFolders(dir)
+	for _, torrent := range torrents {
+		if f.category(torrent.Name) != dir || !f.statusShown(torrent.Status) {
+			continue
+		}
+		if f.flattened(&torrent) {
+			flat = append(flat, torrent)
+			continue
+		}
+		torrent.Type = api.ItemTypeFolder
+		result = append(result, torrent)
+	}
+	if f.opt.RootLayout == layoutMerged {
+		for _, item := range hosterDownloads() {
+			if f.category(item.Name) == dir {
+				result = append(result, item)
+			}
+		}
+	} else if f.opt.SortDownloads {
+		result = append(result, f.importedItems(dir)...)
+	}
+	return result, flat
+}
+
+// dirTotals returns the number of files and their total size under
+// the folder item, counting all the torrents inside it, or ok false if
+// they aren't known
+func (f *Fs) dirTotals(item *api.Item) (files, size int64, ok bool) {
+	if len(item.Links) > 0 {
+		// a torrent folder
+		return int64(len(item.Links)), item.Bytes, true
+	}
+	dir, isFolder := isFolderID(item.ID)
+	if !isFolder {
+		return 0, 0, false
+	}
+	listMu.RLock()
+	defer listMu.RUnlock()
+	for i := range torrents {
+		if isInDir(f.category(torrents[i].Name), dir) {
+			files += int64(len(torrents[i].Links))
+			size += torrents[i].Bytes
+		}
+	}
+	return files, size, true
+}
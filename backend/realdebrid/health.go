@@ -0,0 +1,119 @@
+package realdebrid
+
+import (
+	"context"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// States of a file in the health command
+const (
+	stateOK         = "ok"
+	stateExpired    = "link-expired"
+	statePending    = "pending-download"
+	stateBroken     = "broken"
+	stateTakenDown  = "taken-down"
+	stateQuarantine = "quarantined"
+)
+
+// healthResult is the state of one file
+type healthResult struct {
+	Path      string `json:"path"`
+	State     string `json:"state"`
+	TorrentID string `json:"torrent_id,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// torrentStatusOf returns the RealDebrid status of the torrent with
+// torrentID, or "" if it isn't known
+func torrentStatusOf(torrentID string) string {
+	listMu.RLock()
+	defer listMu.RUnlock()
+	if i := torrentIndex(torrentID); i >= 0 {
+		return torrents[i].Status
+	}
+	return ""
+}
+
+// objectState returns the state of o at now, worst first
+func (o *Object) objectState(now time.Time) string {
+	if isTakenDown(o.originalLink) {
+		return stateTakenDown
+	}
+	status := torrentStatusOf(o.ParentID)
+	if isQuarantined(status) {
+		return stateQuarantine
+	}
+	if isBadStatus(status) || (o.ParentID != "" && isBroken(o.ParentID)) {
+		return stateBroken
+	}
+	if o.url == "" || o.size == 0 || (status != "" && status != "downloaded") {
+		return statePending
+	}
+	if o.linkExpired(now) {
+		return stateExpired
+	}
+	return stateOK
+}
+
+// health returns the state of the files under dir
+//
+// Only the files whose state is in states are returned, or those which
+// aren't ok if states is empty.
+func (f *Fs) health(ctx context.Context, dir string, now time.Time, states map[string]bool) (out []healthResult, err error) {
+	entries, err := f.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		switch x := entry.(type) {
+		case fs.Directory:
+			if x.ID() == byHashDirID || x.ID() == recentDirID || x.ID() == unselectedDirID {
+				// same torrents again
+				continue
+			}
+			sub, err := f.health(ctx, x.Remote(), now, states)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, sub...)
+		case *Object:
+			state := x.objectState(now)
+			if len(states) == 0 && state == stateOK || len(states) > 0 && !states[state] {
+				continue
+			}
+			r := healthResult{Path: x.remote, State: state, TorrentID: x.ParentID}
+			if state == stateQuarantine {
+				r.Reason = torrentStatusOf(x.ParentID)
+			}
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// healthCommand runs the health backend command
+func (f *Fs) healthCommand(ctx context.Context, arg []string, opt map[string]string) (interface{}, error) {
+	dir := ""
+	if len(arg) > 0 {
+		dir = parsePath(arg[0])
+	}
+	states := map[string]bool{}
+	for _, state := range splitTags(opt["state"]) {
+		states[state] = true
+	}
+	if _, all := opt["all"]; all {
+		for _, state := range []string{stateOK, stateExpired, statePending, stateBroken, stateTakenDown, stateQuarantine} {
+			states[state] = true
+		}
+	}
+	out, err := f.health(ctx, dir, time.Now(), states)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = []healthResult{}
+	}
+	return out, nil
+}
@@ -0,0 +1,167 @@
+package realdebrid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"path"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// Events passed to the hooks
+const (
+	eventAdd    = "add"
+	eventRemove = "remove"
+	eventRepair = "repair"
+)
+
+// hookTimeout is how long a hook may run before it is killed
+const hookTimeout = time.Minute
+
+// hookEvent is the JSON passed to a hook on its standard input
+type hookEvent struct {
+	Event     string    `json:"event"`
+	Path      string    `json:"path"`
+	Name      string    `json:"name"`
+	TorrentID string    `json:"torrent_id"`
+	Hash      string    `json:"hash"`
+	Time      time.Time `json:"time"`
+}
+
+// category returns the folder a torrent called name is sorted into in
+// "folders" folder_mode
+//
+// Torrents pinned by locking their folder stay in it whatever the
+// sorting rules say, as do those moved into a folder by the
+// backend/realdebrid/move rc call.
+func (f *Fs) category(name string) string {
+	if category, ok := frozenCategory(name); ok {
+		return category
+	}
+	if category, ok := placedCategory(name); ok {
+		return category
+	}
+	if category, ok := f.matchCategory(name); ok {
+		return category
+	}
+	return "default"
+}
+
+// matchCategory returns the folder name is sorted into by
+// regex_folders, regex_shows or regex_movies, falling back to what
+// the name_parser makes of it if set, or false if none of them match
+func (f *Fs) matchCategory(name string) (string, bool) {
+	rules := f.sortRules()
+	sorter := rules.sorter
+	if sorter == nil {
+		// not made by NewFs so sort by the options as they are
+		s, err := newRegexSorter(rules.folders, f.opt.RegexShows, f.opt.RegexMovies)
+		if err != nil {
+			return "", false
+		}
+		sorter = s
+	}
+	if category, ok := sorter.sort(name); ok || rules.parser == nil {
+		return category, ok
+	}
+	return f.parseName(name).category()
+}
+
+// torrentPath returns the path of the folder of torrent relative to
+// the root of the remote
+func (f *Fs) torrentPath(torrent *api.Item) string {
+	name := f.standardName(torrent.Name, torrent.ID)
+	if f.opt.SharedFolder != "folders" {
+		return name
+	}
+	if f.opt.RootLayout == layoutSplit {
+		return path.Join("torrents", f.category(torrent.Name), name)
+	}
+	return path.Join(f.category(torrent.Name), name)
+}
+
+// hookCommand returns the command configured for event
+func (f *Fs) hookCommand(event string) string {
+	switch event {
+	case eventAdd:
+		return f.opt.OnAdd
+	case eventRemove:
+		return f.opt.OnRemove
+	case eventRepair:
+		return f.opt.OnRepair
+	}
+	return ""
+}
+
+// runHook records event for watchers and runs the hook configured for
+// it, if any, in the background with the details of torrent
+func (f *Fs) runHook(event string, torrent *api.Item) {
+	e := hookEvent{
+		Event:     event,
+		Path:      f.torrentPath(torrent),
+		Name:      torrent.Name,
+		TorrentID: torrent.ID,
+		Hash:      torrent.TorrentHash,
+		Time:      time.Now(),
+	}
+	recordEvent(libraryEvent{hookEvent: e})
+	command := f.hookCommand(event)
+	if command == "" {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		fs.Errorf(f, "Failed to encode %s event: %v", event, err)
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, command, event)
+		cmd.Stdin = bytes.NewReader(data)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			fs.Errorf(f, "Hook %q for %s of %q failed: %v: %s", command, event, torrent.Name, err, bytes.TrimSpace(out))
+			return
+		}
+		fs.Debugf(f, "Hook %q for %s of %q ran", command, event, torrent.Name)
+	}()
+}
+
+// torrentChanges runs the add and remove hooks for the differences
+// between the torrents in old and current
+//
+// A torrent which was repaired is removed and added again with a new
+// ID, so a torrent whose hash is in both isn't reported. Nothing is
+// reported for the first list as nothing was known before it.
+func (f *Fs) torrentChanges(old, current []api.Item) {
+	if len(old) == 0 {
+		return
+	}
+	oldIDs := make(map[string]bool, len(old))
+	oldHashes := make(map[string]bool, len(old))
+	for _, torrent := range old {
+		oldIDs[torrent.ID] = true
+		oldHashes[torrent.TorrentHash] = true
+	}
+	newIDs := make(map[string]bool, len(current))
+	newHashes := make(map[string]bool, len(current))
+	for _, torrent := range current {
+		newIDs[torrent.ID] = true
+		newHashes[torrent.TorrentHash] = true
+	}
+	for i := range current {
+		if !oldIDs[current[i].ID] && !oldHashes[current[i].TorrentHash] {
+			f.runHook(eventAdd, &current[i])
+		}
+	}
+	for i := range old {
+		if !newIDs[old[i].ID] && !newHashes[old[i].TorrentHash] {
+			f.runHook(eventRemove, &old[i])
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package realdebrid
+
+import (
+	"github.com/rclone/rclone/backend/realdebrid/api"
+)
+
+// Root layouts in "folders" folder_mode
+const (
+	layoutMerged = "merged"
+	layoutSplit  = "split"
+)
+
+// IDs of the branches of the root in the split layout
+const (
+	splitTorrentsDirID  = ".torrents"
+	splitDownloadsDirID = ".downloads"
+)
+
+// splitItems returns the branches of the root in the split layout
+func splitItems() (result []api.Item) {
+	for _, branch := range []struct{ id, name string }{
+		{splitTorrentsDirID, "torrents"},
+		{splitDownloadsDirID, "downloads"},
+	} {
+		result = append(result, api.Item{
+			ID:        branch.id,
+			Name:      branch.name,
+			Type:      api.ItemTypeFolder,
+			Generated: "2006-01-02T15:04:05.000Z",
+		})
+	}
+	return result
+}
+
+// hosterDownloads returns the entries of the /downloads list which
+// don't belong to any torrent, i.e. links unrestricted from hosters
+//
+// Call with listMu held.
+func hosterDownloads() (result []api.Item) {
+	links := map[string]bool{}
+	for _, torrent := range torrents {
+		for _, link := range torrent.Links {
+			links[link] = true
+		}
+	}
+	for i, item := range cached {
+		if item.OriginalLink == "" || links[item.OriginalLink] || cachedLinks[item.OriginalLink] != i {
+			continue
+		}
+		item.Type = api.ItemTypeFile
+		result = append(result, item)
+	}
+	return result
+}
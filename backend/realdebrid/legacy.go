@@ -0,0 +1,126 @@
+package realdebrid
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// legacyDirID is the ID of the /legacy directory in the root which
+// lists the downloads imported by import-downloads
+const legacyDirID = "legacy"
+
+// legacy holds the hoster links of the imported downloads which don't
+// belong to any torrent
+var legacy = map[string]bool{}
+var legacyMu sync.Mutex
+
+// isLegacy returns whether link was imported into /legacy
+func isLegacy(link string) bool {
+	legacyMu.Lock()
+	defer legacyMu.Unlock()
+	return legacy[link]
+}
+
+// legacyLinks returns the imported links sorted
+func legacyLinks() (out []string) {
+	legacyMu.Lock()
+	for link := range legacy {
+		out = append(out, link)
+	}
+	legacyMu.Unlock()
+	sort.Strings(out)
+	return out
+}
+
+// legacyCategory returns the folder the imported download item is
+// sorted into with sort_downloads, or "" if it stays in /legacy
+//
+// Downloads are matched by their file names with the same regexes as
+// torrents, but those which match none of them stay in /legacy rather
+// than going into default.
+func (f *Fs) legacyCategory(item *api.Item) string {
+	if !f.opt.SortDownloads {
+		return ""
+	}
+	category, _ := f.matchCategory(item.Name)
+	return category
+}
+
+// legacyItems returns the downloads imported into /legacy which
+// aren't sorted into a folder
+//
+// Call with listMu held.
+func (f *Fs) legacyItems() (result []api.Item) {
+	return f.importedItems("")
+}
+
+// importedItems returns the imported downloads whose legacyCategory
+// is category
+//
+// Call with listMu held.
+func (f *Fs) importedItems(category string) (result []api.Item) {
+	legacyMu.Lock()
+	defer legacyMu.Unlock()
+	for _, item := range cached {
+		if legacy[item.OriginalLink] && f.legacyCategory(&item) == category {
+			item.Type = api.ItemTypeFile
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// importResult is the output of the import-downloads command
+type importResult struct {
+	Matched  int `json:"matched"`
+	Legacy   int `json:"legacy"`
+	Imported int `json:"imported"`
+}
+
+// importDownloads sorts the entries of the /downloads list into those
+// belonging to a torrent, which are listed with it already, and the
+// rest which are imported into /legacy
+func (f *Fs) importDownloads(ctx context.Context) (*importResult, error) {
+	listMu.RLock()
+	empty := len(cached) == 0
+	listMu.RUnlock()
+	if empty {
+		_, err := f.refreshLibrary(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	listMu.Lock()
+	links := map[string]bool{}
+	for _, torrent := range torrents {
+		for _, link := range torrent.Links {
+			links[link] = true
+		}
+	}
+	result := &importResult{}
+	legacyMu.Lock()
+	for _, item := range cached {
+		switch {
+		case item.OriginalLink == "":
+		case links[item.OriginalLink]:
+			result.Matched++
+		default:
+			result.Legacy++
+			if !legacy[item.OriginalLink] {
+				legacy[item.OriginalLink] = true
+				result.Imported++
+			}
+		}
+	}
+	legacyMu.Unlock()
+	listMu.Unlock()
+	if result.Imported > 0 {
+		fs.Infof(f, "import-downloads: imported %d downloads into /%s", result.Imported, legacyDirID)
+		f.saveState()
+	}
+	return result, nil
+}
@@ -0,0 +1,114 @@
+package realdebrid
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// linkRefreshResult is the outcome of refreshing the link of one file
+type linkRefreshResult struct {
+	Path  string `json:"path"`
+	Link  string `json:"link,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// updateCachedLink records link as the new direct link of the hoster
+// link originalLink, generated at now, so listings use it from now on
+func updateCachedLink(originalLink, link string, now time.Time) {
+	listMu.Lock()
+	defer listMu.Unlock()
+	if j, ok := cachedLinks[originalLink]; ok {
+		cached[j].Link = link
+		cached[j].Generated = now.UTC().Format(generatedLayout)
+	}
+}
+
+// refreshLink unrestricts the link of o again
+func (f *Fs) refreshLink(ctx context.Context, o *Object) linkRefreshResult {
+	r := linkRefreshResult{Path: o.remote}
+	if o.originalLink == "" {
+		r.Error = "no hoster link to unrestrict"
+		return r
+	}
+	if isTakenDown(o.originalLink) {
+		r.Error = takenDownError(o.originalLink).Error()
+		return r
+	}
+	item, err := f.unrestrict(ctx, o.originalLink)
+	if err == nil && item.Link == "" {
+		err = errors.New("no download link returned")
+	}
+	if err == nil {
+		err = o.checkSize(item)
+	}
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	now := time.Now()
+	updateCachedLink(o.originalLink, item.Link, now)
+	f.rememberURL(o.originalLink, item.Link, now)
+	o.url = item.Link
+	o.generated = now
+	r.Link = item.Link
+	return r
+}
+
+// linkRefresh unrestricts the links of all the files under dir again
+func (f *Fs) linkRefresh(ctx context.Context, dir string) (out []linkRefreshResult, err error) {
+	entries, err := f.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		switch x := entry.(type) {
+		case fs.Directory:
+			if x.ID() == byHashDirID || x.ID() == recentDirID || x.ID() == unselectedDirID {
+				// same torrents again
+				continue
+			}
+			sub, err := f.linkRefresh(ctx, x.Remote())
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, sub...)
+		case *Object:
+			r := f.refreshLink(ctx, x)
+			if r.Error != "" {
+				fs.Errorf(x, "Failed to refresh link: %s", r.Error)
+			}
+			out = append(out, r)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+	return out, nil
+}
+
+// linkRefreshCommand runs the link-refresh backend command
+func (f *Fs) linkRefreshCommand(ctx context.Context, arg []string) (interface{}, error) {
+	if err := checkOnline(); err != nil {
+		return nil, err
+	}
+	dir := ""
+	if len(arg) > 0 {
+		dir = parsePath(arg[0])
+	}
+	if _, err := f.dirCache.FindDir(ctx, dir, false); err != nil {
+		// a single file
+		o, err := f.NewObject(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		obj, ok := o.(*Object)
+		if !ok {
+			return nil, errors.New("not a RealDebrid file")
+		}
+		return []linkRefreshResult{f.refreshLink(ctx, obj)}, nil
+	}
+	return f.linkRefresh(ctx, dir)
+}
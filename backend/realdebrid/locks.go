@@ -0,0 +1,192 @@
+package realdebrid
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// errFolderLocked is returned when changing something inside a locked
+// folder
+var errFolderLocked = errors.New("folder is locked - use the unlock backend command first")
+
+// lockedFolders holds the sorting folders locked by the lock command by
+// path, e.g. "shows/anime". Nothing automatic moves or deletes the
+// torrents inside them and the mount refuses to change them.
+//
+// frozen pins the folder of each torrent which was inside a locked
+// folder when it was locked by torrent name, so that changing the
+// sorting rules doesn't move it out. Both are protected by locksMu.
+var lockedFolders = map[string]bool{}
+var frozen = map[string]string{}
+var locksMu sync.Mutex
+
+// frozenCategory returns the folder the torrent called name is pinned
+// to, or false if it isn't
+func frozenCategory(name string) (string, bool) {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	category, ok := frozen[name]
+	return category, ok
+}
+
+// lockedFolder returns the locked folder dir is in, or false if there
+// isn't one
+func lockedFolder(dir string) (string, bool) {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	for locked := range lockedFolders {
+		if isInDir(dir, locked) {
+			return locked, true
+		}
+	}
+	return "", false
+}
+
+// lockedList returns the locked folders sorted
+func lockedList() []string {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	out := make([]string, 0, len(lockedFolders))
+	for dir := range lockedFolders {
+		out = append(out, dir)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// torrentLocked returns whether the torrent called name is in a locked
+// folder
+func (f *Fs) torrentLocked(name string) bool {
+	if f.opt.SharedFolder != "folders" {
+		return false
+	}
+	_, locked := lockedFolder(f.category(name))
+	return locked
+}
+
+// dirLocked returns whether the directory with dirID, a sorting folder
+// or a torrent, is in a locked folder
+func (f *Fs) dirLocked(dirID string) bool {
+	if dir, ok := isFolderID(dirID); ok {
+		_, locked := lockedFolder(dir)
+		return locked
+	}
+	listMu.RLock()
+	i := torrentIndex(strings.TrimPrefix(dirID, byHashPrefix))
+	var name string
+	if i >= 0 {
+		name = torrents[i].Name
+	}
+	listMu.RUnlock()
+	return i >= 0 && f.torrentLocked(name)
+}
+
+// lockFolder locks dir, pinning the torrents inside it, and returns how
+// many were pinned
+//
+// Call with listMu held.
+func (f *Fs) lockFolder(dir string) int {
+	pins := map[string]string{}
+	for i := range torrents {
+		category := f.category(torrents[i].Name)
+		if isInDir(category, dir) {
+			pins[torrents[i].Name] = category
+		}
+	}
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	lockedFolders[dir] = true
+	for name, category := range pins {
+		frozen[name] = category
+	}
+	return len(pins)
+}
+
+// unlockFolder unlocks dir, unpinning the torrents inside it which
+// aren't in another locked folder, and returns whether it was locked
+func unlockFolder(dir string) bool {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	if !lockedFolders[dir] {
+		return false
+	}
+	delete(lockedFolders, dir)
+	for name, category := range frozen {
+		if !isInDir(category, dir) {
+			continue
+		}
+		keep := false
+		for locked := range lockedFolders {
+			keep = keep || isInDir(category, locked)
+		}
+		if !keep {
+			delete(frozen, name)
+		}
+	}
+	return true
+}
+
+// lockResult is the output of the lock and unlock commands
+type lockResult struct {
+	Folder string `json:"folder"`
+	Locked bool   `json:"locked"`
+	Pinned int    `json:"pinned,omitempty"`
+}
+
+// folderForPath returns the sorting folder at remote
+func (f *Fs) folderForPath(ctx context.Context, remote string) (string, error) {
+	if f.opt.SharedFolder != "folders" {
+		return "", errors.New("folders can only be locked in \"folders\" folder_mode")
+	}
+	dirID, err := f.dirCache.FindDir(ctx, remote, false)
+	if err != nil {
+		return "", err
+	}
+	dir, ok := isFolderID(dirID)
+	if !ok {
+		return "", errors.New("not a sorting folder")
+	}
+	return dir, nil
+}
+
+// lockCommand runs the lock backend command
+//
+// With a path it locks that folder, without one it lists the locked
+// folders.
+func (f *Fs) lockCommand(ctx context.Context, arg []string) (interface{}, error) {
+	if len(arg) == 0 {
+		return lockedList(), nil
+	}
+	dir, err := f.folderForPath(ctx, parsePath(arg[0]))
+	if err != nil {
+		return nil, err
+	}
+	listMu.RLock()
+	pinned := f.lockFolder(dir)
+	listMu.RUnlock()
+	fs.Infof(f, "Locked %q with %d torrents", dir, pinned)
+	f.saveState()
+	return lockResult{Folder: dir, Locked: true, Pinned: pinned}, nil
+}
+
+// unlockCommand runs the unlock backend command
+func (f *Fs) unlockCommand(ctx context.Context, arg []string) (interface{}, error) {
+	if len(arg) != 1 {
+		return nil, errors.New("need exactly one folder")
+	}
+	dir, err := f.folderForPath(ctx, parsePath(arg[0]))
+	if err != nil {
+		return nil, err
+	}
+	if !unlockFolder(dir) {
+		return nil, errors.New("folder isn't locked")
+	}
+	fs.Infof(f, "Unlocked %q", dir)
+	f.saveState()
+	return lockResult{Folder: dir}, nil
+}
@@ -0,0 +1,177 @@
+package realdebrid
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// missEntry is a lookup which found nothing
+type missEntry struct {
+	expires   time.Time // when the entry stops being used
+	lastcheck int64     // lastcheck when the lookup was made
+}
+
+// missCache remembers paths which weren't found for negative_cache_time
+// so sync, which stats many paths which don't exist, doesn't walk the
+// listings again for each one.
+//
+// Entries are dropped when the library is refreshed as the path may
+// exist now.
+type missCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]missEntry
+}
+
+// newMissCache makes a missCache keeping entries for ttl, returning nil
+// if ttl is 0
+func newMissCache(ttl time.Duration) *missCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &missCache{
+		ttl:     ttl,
+		entries: map[string]missEntry{},
+	}
+}
+
+// lookupKey returns the key for a lookup of path
+func lookupKey(path string, directoriesOnly, filesOnly bool) string {
+	switch {
+	case directoriesOnly:
+		return "d:" + path
+	case filesOnly:
+		return "f:" + path
+	}
+	return "a:" + path
+}
+
+// missed returns whether key is known not to exist
+func (c *missCache) missed(key string, now time.Time) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	if now.After(e.expires) || e.lastcheck != atomic.LoadInt64(&lastcheck) {
+		delete(c.entries, key)
+		return false
+	}
+	return true
+}
+
+// add remembers that key doesn't exist
+func (c *missCache) add(key string, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) > maxMisses {
+		// drop the expired entries so the map doesn't grow forever
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = missEntry{
+		expires:   now.Add(c.ttl),
+		lastcheck: atomic.LoadInt64(&lastcheck),
+	}
+}
+
+// maxMisses is the number of entries in a missCache above which expired
+// entries are cleaned out
+const maxMisses = 10000
+
+// pathIndex holds the items of the directories listed since the last
+// refresh by directory ID and nameKey, so that stats of paths in a
+// directory which has already been listed, e.g. by lsjson --stat, are
+// answered without listing it again.
+//
+// The index is dropped whenever lastcheck changes as the listings may
+// be different after a refresh.
+type pathIndex struct {
+	mu        sync.Mutex
+	lastcheck int64
+	dirs      map[string]map[string]api.Item
+}
+
+// newPathIndex makes an empty pathIndex
+func newPathIndex() *pathIndex {
+	return &pathIndex{
+		dirs: map[string]map[string]api.Item{},
+	}
+}
+
+// current drops the index if the library was refreshed since it was
+// made
+//
+// Call with mu held.
+func (x *pathIndex) current() {
+	check := atomic.LoadInt64(&lastcheck)
+	if x.lastcheck != check {
+		x.lastcheck = check
+		x.dirs = map[string]map[string]api.Item{}
+	}
+}
+
+// put replaces the items of the directory dirID with items, which are
+// by nameKey
+func (x *pathIndex) put(dirID string, items map[string]api.Item) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.current()
+	x.dirs[dirID] = items
+}
+
+// get returns the item called key in the directory dirID, with listed
+// false if the directory isn't in the index so may need listing
+func (x *pathIndex) get(dirID, key string) (info *api.Item, listed bool) {
+	if x == nil {
+		return nil, false
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.current()
+	items, listed := x.dirs[dirID]
+	if item, ok := items[key]; ok {
+		return &item, true
+	}
+	return nil, listed
+}
+
+// lookup finds path like readMetaDataForPath
+//
+// Paths known not to exist return fs.ErrorObjectNotFound straight away
+// and concurrent lookups of the same path share a single listing.
+func (f *Fs) lookup(ctx context.Context, path string, directoriesOnly bool, filesOnly bool) (info *api.Item, err error) {
+	key := lookupKey(path, directoriesOnly, filesOnly)
+	if f.misses.missed(key, time.Now()) {
+		return nil, fs.ErrorObjectNotFound
+	}
+	v, err, _ := f.lookups.Do(key, func() (interface{}, error) {
+		info, err := f.readMetaDataForPath(ctx, path, directoriesOnly, filesOnly)
+		if err == fs.ErrorObjectNotFound {
+			f.misses.add(key, time.Now())
+		}
+		return info, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*api.Item), nil
+}
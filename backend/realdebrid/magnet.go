@@ -0,0 +1,328 @@
+package realdebrid
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// magnetSuffix is the extension of files which are uploaded to add a
+// torrent
+const magnetSuffix = ".magnet"
+
+// What to do with a torrent being added which is already in the
+// library
+const (
+	duplicateMapExisting = "map-existing"
+	duplicateSkip        = "skip"
+	duplicateAddAnyway   = "add-anyway"
+)
+
+// errDuplicate is returned when adding a torrent already in the
+// library with on_duplicate=skip
+var errDuplicate = errors.New("torrent is already in the library")
+
+var (
+	hashRe = regexp.MustCompile(`(?i)^[0-9a-f]{40}$`)
+	btihRe = regexp.MustCompile(`(?i)xt=urn:btih:([0-9a-z]+)`)
+	// \b would treat _ as part of a word so "Show_S02E03" wouldn't match
+	episodeRe = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])s(\d{1,2})[ ._-]?e(\d{1,3})(?:[^a-z0-9]|$)`)
+	seasonRe  = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:s|season[ ._-]?)(\d{1,2})(?:[^a-z0-9]|$)`)
+)
+
+// isMagnet returns whether remote is a file which adds a torrent when
+// uploaded
+func isMagnet(remote string) bool {
+	return strings.HasSuffix(strings.ToLower(remote), magnetSuffix)
+}
+
+// parseMagnet returns the magnet link in the contents of a .magnet
+// file, which may also be a bare info hash
+func parseMagnet(data []byte) (string, error) {
+	magnet := strings.TrimSpace(string(data))
+	if hashRe.MatchString(magnet) {
+		return "magnet:?xt=urn:btih:" + magnet, nil
+	}
+	if !strings.HasPrefix(magnet, "magnet:?") {
+		return "", errors.New("want a magnet link or an info hash")
+	}
+	return magnet, nil
+}
+
+// magnetHash returns the info hash of magnet as lower case hex, or ""
+// if it hasn't got one
+func magnetHash(magnet string) string {
+	m := btihRe.FindStringSubmatch(magnet)
+	if m == nil {
+		return ""
+	}
+	hash := m[1]
+	if len(hash) == 32 {
+		// base32 encoded
+		raw, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		if err != nil {
+			return ""
+		}
+		return hex.EncodeToString(raw)
+	}
+	if !hashRe.MatchString(hash) {
+		return ""
+	}
+	return strings.ToLower(hash)
+}
+
+// findDuplicate returns the torrent in the library with the same info
+// hash as magnet, or nil if there isn't one
+func findDuplicate(magnet string) *api.Item {
+	hash := magnetHash(magnet)
+	if hash == "" {
+		return nil
+	}
+	listMu.RLock()
+	defer listMu.RUnlock()
+	for i := range torrents {
+		if strings.EqualFold(torrents[i].TorrentHash, hash) {
+			torrent := torrents[i]
+			return &torrent
+		}
+	}
+	return nil
+}
+
+// selectionHints returns the season and episode the path of a .magnet
+// file asks for, or 0 if it doesn't
+//
+// The episode is taken from the file name, eg "Foo S02E03.magnet", and
+// the season from either that or a directory, eg "Foo/Season 2/".
+func selectionHints(remote string) (season, episode int) {
+	leaf := strings.TrimSuffix(path.Base(remote), path.Ext(remote))
+	if m := episodeRe.FindStringSubmatch(leaf); m != nil {
+		season, _ = strconv.Atoi(m[1])
+		episode, _ = strconv.Atoi(m[2])
+		return season, episode
+	}
+	parts := strings.Split(remote, "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		part := parts[i]
+		if i == len(parts)-1 {
+			part = leaf
+		}
+		if m := seasonRe.FindStringSubmatch(part); m != nil {
+			season, _ = strconv.Atoi(m[1])
+			return season, 0
+		}
+	}
+	return 0, 0
+}
+
+// matchesHints returns whether filePath inside a torrent is the season
+// and episode asked for
+func matchesHints(filePath string, season, episode int) bool {
+	for _, m := range episodeRe.FindAllStringSubmatch(filePath, -1) {
+		s, _ := strconv.Atoi(m[1])
+		e, _ := strconv.Atoi(m[2])
+		if s == season && (episode == 0 || e == episode) {
+			return true
+		}
+	}
+	if episode != 0 {
+		return false
+	}
+	for _, m := range seasonRe.FindAllStringSubmatch(filePath, -1) {
+		s, _ := strconv.Atoi(m[1])
+		if s == season {
+			return true
+		}
+	}
+	return false
+}
+
+// selectFiles returns the files of a torrent to select for remote
+//
+// Files matching select_exclude are left out, then the hints in the
+// path choose among the rest. It returns "all" if nothing is left
+// out.
+func (f *Fs) selectFiles(remote string, files []api.File) string {
+	var candidates []api.File
+	for _, file := range files {
+		if f.selectExclude == nil || !f.selectExclude.MatchString(file.Path) {
+			candidates = append(candidates, file)
+		}
+	}
+	if len(candidates) == 0 {
+		fs.Debugf(f, "%s: select_exclude matches every file so selecting all", remote)
+		candidates = files
+	}
+	season, episode := selectionHints(remote)
+	var ids []string
+	for _, file := range candidates {
+		if season == 0 || matchesHints(file.Path, season, episode) {
+			ids = append(ids, strconv.FormatInt(file.ID, 10))
+		}
+	}
+	if len(ids) == 0 {
+		fs.Debugf(f, "%s: no files match season %d episode %d so ignoring them", remote, season, episode)
+		for _, file := range candidates {
+			ids = append(ids, strconv.FormatInt(file.ID, 10))
+		}
+	}
+	if len(ids) == len(files) {
+		return "all"
+	}
+	return strings.Join(ids, ",")
+}
+
+// addMagnet adds magnet to the account, selecting the files in it
+// chosen by the path of remote
+//
+// If the torrent is already in the library on_duplicate decides what
+// happens.
+func (f *Fs) addMagnet(ctx context.Context, magnet, remote string) (torrent api.Item, err error) {
+	if f.opt.OnDuplicate != duplicateAddAnyway {
+		if existing := findDuplicate(magnet); existing != nil {
+			if f.opt.OnDuplicate == duplicateSkip {
+				return torrent, fmt.Errorf("%w as %q", errDuplicate, f.torrentPath(existing))
+			}
+			fs.Infof(f, "%s: torrent is already in the library as %q so not adding it again", remote, f.torrentPath(existing))
+			f.placeExisting(existing, remote)
+			return *existing, nil
+		}
+	}
+	return f.addTorrent(ctx, magnet, func(files []api.File) string {
+		return f.selectFiles(remote, files)
+	})
+}
+
+// placeExisting moves existing, the torrent already in the library
+// which the .magnet file at remote would have added, into the sorting
+// folder remote was uploaded to so it shows up there as if it had been
+// added
+func (f *Fs) placeExisting(existing *api.Item, remote string) {
+	if f.opt.SharedFolder != "folders" || remote == "" {
+		return
+	}
+	dir := parentDir(remote)
+	for dir != "" && !f.isSortingFolder(dir) {
+		dir = parentDir(dir)
+	}
+	from := f.category(existing.Name)
+	if dir == "" || dir == from {
+		return
+	}
+	if _, locked := lockedFolder(from); locked {
+		return
+	}
+	if _, locked := lockedFolder(dir); locked {
+		return
+	}
+	place(existing.Name, dir)
+	fs.Infof(f, "Moved %q from %q to %q where it was uploaded", existing.Name, from, dir)
+	f.saveState()
+}
+
+// addTorrent adds magnet to the account, selecting the files returned
+// by choose, which is given the files of the torrent
+func (f *Fs) addTorrent(ctx context.Context, magnet string, choose func(files []api.File) string) (torrent api.Item, err error) {
+	opts := rest.Opts{
+		Method: "POST",
+		Path:   "/torrents/addMagnet",
+		MultipartParams: url.Values{
+			"magnet": {magnet},
+		},
+		Parameters: f.baseParams(),
+	}
+	var resp *http.Response
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.CallJSON(ctx, &opts, nil, &torrent)
+		return shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return torrent, fmt.Errorf("couldn't add magnet: %w", err)
+	}
+	// the torrent is only known to the account it was added to
+	torrent.Account = f.accounts.used(resp)
+	f.accounts.setOwner(torrent.ID, torrent.Account)
+	opts = rest.Opts{
+		Method:     "GET",
+		Path:       "/torrents/info/" + torrent.ID,
+		Parameters: f.baseParams(),
+	}
+	for tries := 0; ; tries++ {
+		err = f.pacer.Call(func() (bool, error) {
+			resp, err = f.srv.CallJSON(ctx, &opts, nil, &torrent)
+			return shouldRetry(ctx, resp, err)
+		})
+		if err != nil {
+			return torrent, fmt.Errorf("couldn't read torrent info: %w", err)
+		}
+		if torrent.Status == "waiting_files_selection" || tries >= 5 {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if torrent.Status != "waiting_files_selection" || len(torrent.Files) == 0 {
+		// selecting now would select everything as the files aren't known
+		return torrent, fmt.Errorf("torrent %s is still %q without a list of files so none were selected", torrent.ID, torrent.Status)
+	}
+	opts = rest.Opts{
+		Method: "POST",
+		Path:   "/torrents/selectFiles/" + torrent.ID,
+		MultipartParams: url.Values{
+			"files": {choose(torrent.Files)},
+		},
+		Parameters: f.baseParams(),
+		NoResponse: true,
+	}
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.Call(ctx, &opts)
+		return shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return torrent, fmt.Errorf("couldn't select files: %w", err)
+	}
+	return torrent, nil
+}
+
+// putMagnet adds the torrent in the .magnet file being uploaded
+//
+// The file itself isn't stored - the torrent shows up in the library
+// once it has been downloaded.
+func (f *Fs) putMagnet(ctx context.Context, in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(in, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	magnet, err := parseMagnet(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", src.Remote(), err)
+	}
+	torrent, err := f.addMagnet(ctx, magnet, path.Join(f.root, src.Remote()))
+	if err != nil {
+		return nil, err
+	}
+	fs.Infof(f, "Added torrent %s from %s", torrent.ID, src.Remote())
+	forceRefresh()
+	return &Object{
+		fs:          f,
+		remote:      src.Remote(),
+		hasMetaData: true,
+		size:        int64(len(data)),
+		modTime:     src.ModTime(ctx),
+		id:          torrent.ID,
+	}, nil
+}
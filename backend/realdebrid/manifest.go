@@ -0,0 +1,96 @@
+package realdebrid
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// manifestEntry describes one file in the manifest_file
+type manifestEntry struct {
+	Size        int64  `json:"size"`
+	Hash        string `json:"hash,omitempty"`
+	TorrentID   string `json:"torrent_id"`
+	LinkExpires string `json:"link_expires,omitempty"`
+}
+
+// lastManifest is what was last written to the manifest_file so it is
+// only written when the library changes. It is protected by
+// manifestMu which also serialises the writes.
+var lastManifest []byte
+var manifestMu sync.Mutex
+
+// manifest returns the files of the library by their paths from the
+// root of the remote
+func (f *Fs) manifest() map[string]manifestEntry {
+	out := map[string]manifestEntry{}
+	listMu.RLock()
+	defer listMu.RUnlock()
+	for i := range torrents {
+		torrent := &torrents[i]
+		dir := f.torrentPath(torrent)
+		for _, link := range torrent.Links {
+			j, ok := cachedLinks[link]
+			if !ok || isTakenDown(link) {
+				continue
+			}
+			item := &cached[j]
+			entry := manifestEntry{
+				Size:      item.Size,
+				Hash:      torrent.TorrentHash,
+				TorrentID: torrent.ID,
+			}
+			if generated := parseGenerated(item.Generated); !generated.IsZero() && f.opt.LinkValidity > 0 {
+				entry.LinkExpires = generated.Add(time.Duration(f.opt.LinkValidity)).Format(time.RFC3339)
+			}
+			out[path.Join(dir, f.standardName(item.Name, item.ID))] = entry
+		}
+	}
+	return out
+}
+
+// writeManifest writes the manifest_file if set and the library has
+// changed since it was last written
+func (f *Fs) writeManifest() {
+	if f.opt.ManifestFile == "" {
+		return
+	}
+	data, err := json.MarshalIndent(f.manifest(), "", "\t")
+	if err != nil {
+		fs.Errorf(f, "Failed to make manifest: %v", err)
+		return
+	}
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	if bytes.Equal(data, lastManifest) {
+		return
+	}
+	err = writeFileAtomic(f.opt.ManifestFile, data)
+	if err != nil {
+		fs.Errorf(f, "Failed to write manifest: %v", err)
+		return
+	}
+	lastManifest = data
+}
+
+// writeFileAtomic writes data to fileName through a temporary file so
+// readers never see it half written
+func writeFileAtomic(fileName string, data []byte) error {
+	tmp := fileName + ".tmp"
+	err := ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write %q: %w", fileName, err)
+	}
+	err = os.Rename(tmp, fileName)
+	if err != nil {
+		return fmt.Errorf("failed to write %q: %w", fileName, err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package realdebrid
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotFields are the top level fields of a librarySnapshot as
+// stored, which migrations work on so they can change their types
+type snapshotFields map[string]json.RawMessage
+
+// snapshotMigration upgrades the fields of a snapshot of one version
+// to the next
+type snapshotMigration func(fields snapshotFields) error
+
+// snapshotMigrations upgrade snapshots of the version they are stored
+// under to the version after, so every snapshotVersion bump needs one
+// here
+var snapshotMigrations = map[int]snapshotMigration{
+	1: migrateRepairs,
+	2: migrateFileKeys,
+}
+
+// get decodes the field called name into v, leaving v alone if it is
+// missing
+func (fields snapshotFields) get(name string, v interface{}) error {
+	raw, ok := fields[name]
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("bad %s: %w", name, err)
+	}
+	return nil
+}
+
+// set encodes v into the field called name
+func (fields snapshotFields) set(name string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	fields[name] = raw
+	return nil
+}
+
+// migrateRepairs gives the broken torrents of version 1, which were
+// only a list of IDs, repair timings starting from when the snapshot
+// was taken
+func migrateRepairs(fields snapshotFields) error {
+	var broken []string
+	var created time.Time
+	repairs := map[string]brokenTorrent{}
+	for name, v := range map[string]interface{}{"broken": &broken, "created": &created, "repairs": &repairs} {
+		if err := fields.get(name, v); err != nil {
+			return err
+		}
+	}
+	for _, id := range broken {
+		if _, ok := repairs[id]; !ok {
+			repairs[id] = brokenTorrent{Since: created}
+		}
+	}
+	return fields.set("repairs", repairs)
+}
+
+// migrateFileKeys marks the keys of torrent files in version 2, which
+// were the torrent hash and the number of the file among the links of
+// the torrent, as positionKey keys. They are moved over to the file ID
+// by adoptPositionKeys once the file IDs of the torrent are known.
+func migrateFileKeys(fields snapshotFields) error {
+	for _, name := range []string{"mod_times", "accessed", "plays", "finished"} {
+		times := map[string]int64{}
+		if err := fields.get(name, &times); err != nil {
+			return err
+		}
+		migrated := make(map[string]int64, len(times))
+		for key, t := range times {
+			if hash, number, ok := splitNumberKey(key); ok {
+				key = positionKey(hash, number)
+			}
+			migrated[key] = t
+		}
+		if err := fields.set(name, migrated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitNumberKey splits a key of a torrent file of version 2 into the
+// torrent hash and the number of the file
+func splitNumberKey(key string) (hash string, number int, ok bool) {
+	i := strings.IndexByte(key, '/')
+	if i <= 0 {
+		return "", 0, false
+	}
+	number, err := strconv.Atoi(key[i+1:])
+	if err != nil || number <= 0 {
+		return "", 0, false
+	}
+	return key[:i], number, true
+}
+
+// decodeSnapshot decodes the snapshot in data, migrating it to the
+// current snapshotVersion if it is older. It returns the version it
+// was stored as.
+func decodeSnapshot(data []byte) (s *librarySnapshot, version int, err error) {
+	var fields snapshotFields
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, 0, err
+	}
+	err = fields.get("version", &version)
+	if err != nil {
+		return nil, 0, err
+	}
+	if version == 0 {
+		// written before the version was stored
+		version = 1
+	}
+	if version > snapshotVersion {
+		return nil, version, fmt.Errorf("snapshot version %d is newer than the supported version %d", version, snapshotVersion)
+	}
+	for v := version; v < snapshotVersion; v++ {
+		migrate, ok := snapshotMigrations[v]
+		if !ok {
+			return nil, version, fmt.Errorf("no migration from snapshot version %d", v)
+		}
+		if err = migrate(fields); err != nil {
+			return nil, version, fmt.Errorf("failed to migrate snapshot from version %d: %w", v, err)
+		}
+	}
+	if err = fields.set("version", snapshotVersion); err != nil {
+		return nil, version, err
+	}
+	data, err = json.Marshal(fields)
+	if err != nil {
+		return nil, version, err
+	}
+	s = new(librarySnapshot)
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, version, err
+	}
+	return s, version, nil
+}
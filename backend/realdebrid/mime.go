@@ -0,0 +1,57 @@
+package realdebrid
+
+import (
+	"mime"
+	"path"
+	"strings"
+)
+
+// mediaMimeTypes are the mime types of files often found in torrents,
+// which the system mime tables get wrong or don't know, e.g. .ts is
+// often taken as TypeScript
+var mediaMimeTypes = map[string]string{
+	".3gp":  "video/3gpp",
+	".7z":   "application/x-7z-compressed",
+	".aac":  "audio/aac",
+	".ass":  "text/x-ssa",
+	".avi":  "video/x-msvideo",
+	".flac": "audio/flac",
+	".flv":  "video/x-flv",
+	".iso":  "application/x-iso9660-image",
+	".m2ts": "video/mp2t",
+	".m4a":  "audio/mp4",
+	".m4v":  "video/x-m4v",
+	".mka":  "audio/x-matroska",
+	".mkv":  "video/x-matroska",
+	".mov":  "video/quicktime",
+	".mp3":  "audio/mpeg",
+	".mp4":  "video/mp4",
+	".mpg":  "video/mpeg",
+	".mpeg": "video/mpeg",
+	".nfo":  "text/plain; charset=utf-8",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".rar":  "application/vnd.rar",
+	".srt":  "application/x-subrip",
+	".ssa":  "text/x-ssa",
+	".sub":  "text/plain; charset=utf-8",
+	".ts":   "video/mp2t",
+	".vob":  "video/dvd",
+	".vtt":  "text/vtt",
+	".webm": "video/webm",
+	".wmv":  "video/x-ms-wmv",
+}
+
+// mimeTypeFromName guesses the mime type of the file called name from
+// its extension for when RealDebrid doesn't return one, returning ""
+// if it isn't known
+func mimeTypeFromName(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if mimeType, ok := mediaMimeTypes[ext]; ok {
+		return mimeType
+	}
+	return mime.TypeByExtension(ext)
+}
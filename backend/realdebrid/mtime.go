@@ -0,0 +1,26 @@
+package realdebrid
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// nameDateRe matches a date in a release name like "2024.05.01",
+// "2024-05-01" or "2024 05 01"
+var nameDateRe = regexp.MustCompile(`(?:^|[^0-9])((?:19|20)[0-9]{2})[. _-]([01][0-9])[. _-]([0-3][0-9])(?:[^0-9]|$)`)
+
+// nameDate returns the date in name in UTC, if it has a valid one
+func nameDate(name string) (t time.Time, ok bool) {
+	for _, m := range nameDateRe.FindAllStringSubmatch(name, -1) {
+		year, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		day, _ := strconv.Atoi(m[3])
+		t = time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+		// reject dates which don't exist, e.g. 2024.02.31
+		if t.Year() == year && int(t.Month()) == month && t.Day() == day {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
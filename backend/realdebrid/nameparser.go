@@ -0,0 +1,224 @@
+package realdebrid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fshttp"
+)
+
+// nameParserTimeout is how long an external name_parser has to answer
+const nameParserTimeout = 10 * time.Second
+
+var (
+	crossEpisodeRe = regexp.MustCompile(`(?i)\b(\d{1,2})x(\d{2,3})\b`)
+	yearRe         = regexp.MustCompile(`\b((?:19|20)[0-9]{2})\b`)
+	qualityRe      = regexp.MustCompile(`(?i)\b(2160p|1080p|720p|576p|480p|4k|uhd)\b`)
+)
+
+// releaseInfo is what a nameParser makes of a release name. Fields
+// which couldn't be worked out are left zero.
+type releaseInfo struct {
+	Title   string `json:"title"`
+	Year    int    `json:"year,omitempty"`
+	Season  int    `json:"season,omitempty"`
+	Episode int    `json:"episode,omitempty"`
+	Quality string `json:"quality,omitempty"`
+}
+
+// category returns the folder a release is sorted into when none of
+// the sorting regexes match it, or false if it should go into default
+func (r releaseInfo) category() (string, bool) {
+	switch {
+	case r.Season > 0 || r.Episode > 0:
+		return "shows", true
+	case r.Year > 0:
+		return "movies", true
+	}
+	return "", false
+}
+
+// nameParser picks a release name like "Some.Show.S02E03.1080p.WEB"
+// apart
+type nameParser interface {
+	parse(ctx context.Context, name string) (releaseInfo, error)
+}
+
+// heuristicParser is the built in nameParser which goes by the usual
+// scene naming conventions
+type heuristicParser struct{}
+
+// parse returns what can be found in name. The title is everything
+// before the first year, episode or quality marker.
+func (heuristicParser) parse(ctx context.Context, name string) (releaseInfo, error) {
+	var r releaseInfo
+	end := len(name)
+	cut := func(loc []int) {
+		if loc != nil && loc[0] > 0 && loc[0] < end {
+			end = loc[0]
+		}
+	}
+	if m := episodeRe.FindStringSubmatchIndex(name); m != nil {
+		r.Season, _ = strconv.Atoi(name[m[2]:m[3]])
+		r.Episode, _ = strconv.Atoi(name[m[4]:m[5]])
+		cut(m)
+	} else if m := crossEpisodeRe.FindStringSubmatchIndex(name); m != nil {
+		r.Season, _ = strconv.Atoi(name[m[2]:m[3]])
+		r.Episode, _ = strconv.Atoi(name[m[4]:m[5]])
+		cut(m)
+	} else if m := seasonRe.FindStringSubmatchIndex(name); m != nil {
+		r.Season, _ = strconv.Atoi(name[m[2]:m[3]])
+		cut(m)
+	}
+	// the last year is the release year, so "2001.A.Space.Odyssey.1968"
+	// keeps its title
+	if years := yearRe.FindAllStringSubmatchIndex(name, -1); years != nil {
+		m := years[len(years)-1]
+		r.Year, _ = strconv.Atoi(name[m[2]:m[3]])
+		cut(m)
+	}
+	if m := qualityRe.FindStringSubmatchIndex(name); m != nil {
+		r.Quality = strings.ToLower(name[m[2]:m[3]])
+		cut(m)
+	}
+	title := groupTagRe.ReplaceAllString(name[:end], "")
+	r.Title = strings.Join(wordRe.FindAllString(title, -1), " ")
+	return r, nil
+}
+
+// commandParser is a nameParser which runs an external program with
+// the name as its argument and reads a JSON releaseInfo from its
+// standard output
+type commandParser struct {
+	command string
+}
+
+// parse runs the program on name
+func (p commandParser) parse(ctx context.Context, name string) (r releaseInfo, err error) {
+	ctx, cancel := context.WithTimeout(ctx, nameParserTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.command, name)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return r, fmt.Errorf("name_parser %q failed: %w: %s", p.command, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if err = json.Unmarshal(out, &r); err != nil {
+		return r, fmt.Errorf("name_parser %q returned bad JSON: %w", p.command, err)
+	}
+	return r, nil
+}
+
+// httpParser is a nameParser which asks a web service, passing the
+// name as the name query parameter and reading a JSON releaseInfo
+// from the response
+type httpParser struct {
+	endpoint string
+	client   *http.Client
+}
+
+// parse asks the service about name
+func (p httpParser) parse(ctx context.Context, name string) (r releaseInfo, err error) {
+	ctx, cancel := context.WithTimeout(ctx, nameParserTimeout)
+	defer cancel()
+	u, err := url.Parse(p.endpoint)
+	if err != nil {
+		return r, err
+	}
+	q := u.Query()
+	q.Set("name", name)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return r, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return r, fmt.Errorf("name_parser %q failed: %w", p.endpoint, err)
+	}
+	defer fs.CheckClose(resp.Body, &err)
+	if resp.StatusCode != http.StatusOK {
+		return r, fmt.Errorf("name_parser %q failed: %s", p.endpoint, resp.Status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return r, fmt.Errorf("name_parser %q returned bad JSON: %w", p.endpoint, err)
+	}
+	return r, nil
+}
+
+// cachedParser remembers what an external nameParser said about each
+// name as names are sorted over and over while listing. Failures
+// are remembered too and fall back to the built in parser so a broken
+// parser doesn't stall every listing.
+type cachedParser struct {
+	parser nameParser
+	mu     sync.Mutex
+	cache  map[string]releaseInfo
+}
+
+// parse returns the cached result for name, asking parser first if
+// there isn't one
+func (p *cachedParser) parse(ctx context.Context, name string) (releaseInfo, error) {
+	p.mu.Lock()
+	r, ok := p.cache[name]
+	p.mu.Unlock()
+	if ok {
+		return r, nil
+	}
+	r, err := p.parser.parse(ctx, name)
+	if err != nil {
+		fs.Errorf(nil, "realdebrid: %v", err)
+		r, _ = heuristicParser{}.parse(ctx, name)
+	}
+	p.mu.Lock()
+	p.cache[name] = r
+	p.mu.Unlock()
+	return r, nil
+}
+
+// newNameParser returns the nameParser configured by name_parser: nil
+// if empty, a web service if it is an http or https URL, "builtin"
+// for the built in one, otherwise a program to run
+func newNameParser(ctx context.Context, config string) (nameParser, error) {
+	switch {
+	case config == "":
+		return nil, nil
+	case config == "builtin":
+		return heuristicParser{}, nil
+	case strings.HasPrefix(config, "http://") || strings.HasPrefix(config, "https://"):
+		if _, err := url.Parse(config); err != nil {
+			return nil, err
+		}
+		return &cachedParser{
+			parser: httpParser{endpoint: config, client: fshttp.NewClient(ctx)},
+			cache:  map[string]releaseInfo{},
+		}, nil
+	}
+	return &cachedParser{
+		parser: commandParser{command: config},
+		cache:  map[string]releaseInfo{},
+	}, nil
+}
+
+// parseName returns what the name_parser makes of name, or the built
+// in parser if name_parser isn't set
+func (f *Fs) parseName(name string) releaseInfo {
+	parser := f.sortRules().parser
+	if parser == nil {
+		parser = heuristicParser{}
+	}
+	r, _ := parser.parse(context.Background(), name)
+	return r
+}
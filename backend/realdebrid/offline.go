@@ -0,0 +1,78 @@
+package realdebrid
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// offlineRetry is how often the API is tried again while offline
+const offlineRetry = 30 * time.Second
+
+// errOffline is returned by operations which need RealDebrid while it
+// can't be reached
+var errOffline = errors.New("realdebrid: provider unreachable - serving the library read only from cache")
+
+// offlineSince is when RealDebrid became unreachable as a Unix time,
+// or 0 if it is reachable, and offlineRetryAt when to try it again.
+var offlineSince, offlineRetryAt int64
+
+// isOffline returns whether RealDebrid is currently unreachable
+func isOffline() bool {
+	return atomic.LoadInt64(&offlineSince) != 0
+}
+
+// checkOnline returns errOffline if RealDebrid is unreachable
+func checkOnline() error {
+	if isOffline() {
+		return errOffline
+	}
+	return nil
+}
+
+// offlineRetryDue returns whether the library may be refreshed, which
+// is always while online and every offlineRetry while offline
+func offlineRetryDue(now time.Time) bool {
+	return !isOffline() || now.Unix() >= atomic.LoadInt64(&offlineRetryAt)
+}
+
+// unreachable returns whether err means RealDebrid couldn't be reached
+// as opposed to it refusing the request
+func unreachable(err error) bool {
+	var apiErr *api.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500
+	}
+	return err != nil
+}
+
+// goOffline records that the refresh failed with err because
+// RealDebrid couldn't be reached
+func (f *Fs) goOffline(err error) {
+	now := time.Now()
+	atomic.StoreInt64(&offlineRetryAt, now.Add(offlineRetry).Unix())
+	if atomic.CompareAndSwapInt64(&offlineSince, 0, now.Unix()) {
+		fs.Errorf(f, "RealDebrid unreachable, serving the library read only from cache: %v", err)
+	}
+}
+
+// goOnline records that RealDebrid can be reached again
+func (f *Fs) goOnline() {
+	if since := atomic.SwapInt64(&offlineSince, 0); since != 0 {
+		fs.Logf(f, "RealDebrid reachable again after %v", time.Since(time.Unix(since, 0)).Round(time.Second))
+	}
+}
+
+// refreshFailed handles err from refreshing the library, going
+// offline and carrying on with the cached library if RealDebrid
+// couldn't be reached
+func (f *Fs) refreshFailed(err error) (saved bool, _ error) {
+	if unreachable(err) {
+		f.goOffline(err)
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,112 @@
+package realdebrid
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// maxOpenURLs is the number of entries in openURLs above which expired
+// entries are cleaned out
+const maxOpenURLs = 10000
+
+// openURL is a direct link which was opened successfully
+type openURL struct {
+	url     string    // the direct link
+	expires time.Time // when to stop using it without checking
+}
+
+// openURLs holds the direct links which opened successfully by hoster
+// link, so that players opening the same file many times in a row to
+// probe it use a link known to work instead of each finding out the
+// link has expired and unrestricting it again. They are shared by all
+// the Objects of a file.
+var openURLs = map[string]openURL{}
+var openURLsMu sync.Mutex
+
+// validURL returns the direct link for link which opened successfully
+// within open_cache_time, if any
+func (f *Fs) validURL(link string, now time.Time) (string, bool) {
+	if f.opt.OpenCacheTime <= 0 || link == "" {
+		return "", false
+	}
+	openURLsMu.Lock()
+	defer openURLsMu.Unlock()
+	u, ok := openURLs[link]
+	if !ok || now.After(u.expires) {
+		return "", false
+	}
+	return u.url, true
+}
+
+// rememberURL records that the direct link url for link opened
+// successfully
+func (f *Fs) rememberURL(link, url string, now time.Time) {
+	if f.opt.OpenCacheTime <= 0 || link == "" {
+		return
+	}
+	openURLsMu.Lock()
+	defer openURLsMu.Unlock()
+	if len(openURLs) > maxOpenURLs {
+		for key, u := range openURLs {
+			if now.After(u.expires) {
+				delete(openURLs, key)
+			}
+		}
+	}
+	openURLs[link] = openURL{url: url, expires: now.Add(time.Duration(f.opt.OpenCacheTime))}
+}
+
+// forgetURL drops the direct link for link after it failed
+func forgetURL(link string) {
+	openURLsMu.Lock()
+	delete(openURLs, link)
+	openURLsMu.Unlock()
+}
+
+// openDownload opens the download of o with options
+//
+// A direct link which opened within open_cache_time is used straight
+// away. Otherwise if the link of o fails, e.g. because it has expired,
+// the hoster link is unrestricted again once and the new link is
+// remembered for the next open. A link which is past link_validity
+// isn't tried at all.
+func (o *Object) openDownload(ctx context.Context, options []fs.OpenOption) (io.ReadCloser, error) {
+	if url, ok := o.fs.validURL(o.originalLink, time.Now()); ok {
+		in, err := o.download(ctx, url, options)
+		if err == nil {
+			return in, nil
+		}
+		fs.Debugf(o, "Remembered download link failed: %v", err)
+		forgetURL(o.originalLink)
+	}
+	if o.originalLink != "" && o.linkExpired(time.Now()) && !isTakenDown(o.originalLink) {
+		fs.Debugf(o, "Download link expired at %v, unrestricting it again", o.linkExpiresAt())
+	} else {
+		in, err := o.download(ctx, o.url, options)
+		if err == nil {
+			o.fs.rememberURL(o.originalLink, o.url, time.Now())
+			return in, nil
+		}
+		if o.originalLink == "" || ctx.Err() != nil || isTakenDown(o.originalLink) {
+			return nil, err
+		}
+		fs.Debugf(o, "Download link failed, unrestricting it again: %v", err)
+	}
+	item, unrestrictErr := o.fs.unrestrict(ctx, o.originalLink)
+	if unrestrictErr != nil {
+		return nil, unrestrictErr
+	}
+	if err := o.checkSize(item); err != nil {
+		return nil, err
+	}
+	in, err := o.download(ctx, item.Link, options)
+	if err != nil {
+		return nil, err
+	}
+	o.fs.rememberURL(o.originalLink, item.Link, time.Now())
+	return in, nil
+}
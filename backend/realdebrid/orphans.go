@@ -0,0 +1,160 @@
+package realdebrid
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// The /.orphaned view lists the torrents which disappeared from the
+// account without rclone removing them, e.g. deleted in the web UI, so
+// they can be added again with the reacquire command. The folders in it
+// have IDs of orphanedPrefix followed by the info hash.
+const (
+	orphanedDirID  = ".orphaned"
+	orphanedPrefix = orphanedDirID + "/"
+)
+
+// orphans are the torrents which have gone missing, protected by listMu
+var orphans []api.Item
+
+// removed holds the IDs of the torrents deleted by rclone so they
+// aren't taken as orphans
+var removed = map[string]bool{}
+var removedMu sync.Mutex
+
+// markRemoved records that rclone deleted the torrent with id
+func markRemoved(id string) {
+	removedMu.Lock()
+	removed[id] = true
+	removedMu.Unlock()
+}
+
+// findOrphans adds the torrents in old which are missing from current
+// to orphans, and forgets the orphans which have come back
+//
+// Call with listMu held exclusively.
+func (f *Fs) findOrphans(old, current []api.Item) {
+	if len(old) == 0 {
+		return
+	}
+	ids := make(map[string]bool, len(current))
+	hashes := make(map[string]bool, len(current))
+	for _, torrent := range current {
+		ids[torrent.ID] = true
+		hashes[strings.ToLower(torrent.TorrentHash)] = true
+	}
+	kept := orphans[:0]
+	for _, orphan := range orphans {
+		if !hashes[strings.ToLower(orphan.TorrentHash)] {
+			kept = append(kept, orphan)
+		}
+	}
+	orphans = kept
+	removedMu.Lock()
+	defer removedMu.Unlock()
+	for _, torrent := range old {
+		if ids[torrent.ID] {
+			continue
+		}
+		if removed[torrent.ID] {
+			delete(removed, torrent.ID)
+			continue
+		}
+		hash := strings.ToLower(torrent.TorrentHash)
+		if hash == "" || hashes[hash] || orphanIndex(hash) >= 0 {
+			continue
+		}
+		fs.Logf(f, "Torrent %q was removed from the account - see %s", torrent.Name, orphanedDirID)
+		orphans = append(orphans, torrent)
+	}
+}
+
+// orphanIndex returns the index in orphans of the torrent with hash or
+// -1 if not found
+//
+// Call with listMu held.
+func orphanIndex(hash string) int {
+	for i := range orphans {
+		if strings.EqualFold(orphans[i].TorrentHash, hash) {
+			return i
+		}
+	}
+	return -1
+}
+
+// orphanItems returns an empty folder for each orphan
+//
+// Call with listMu held.
+func orphanItems() (result []api.Item) {
+	for _, orphan := range orphans {
+		result = append(result, api.Item{
+			ID:          orphanedPrefix + strings.ToLower(orphan.TorrentHash),
+			Name:        orphan.Name,
+			Type:        api.ItemTypeFolder,
+			Generated:   orphan.Generated,
+			TorrentHash: orphan.TorrentHash,
+		})
+	}
+	return result
+}
+
+// reacquireResult is the output of the reacquire command for one
+// torrent
+type reacquireResult struct {
+	Name      string `json:"name"`
+	Hash      string `json:"hash"`
+	TorrentID string `json:"torrent_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// reacquire adds the orphans named in arg, by name or info hash, back
+// to the account by their info hash, or all of them if arg is empty
+func (f *Fs) reacquire(ctx context.Context, arg []string) (interface{}, error) {
+	if err := checkOnline(); err != nil {
+		return nil, err
+	}
+	want := map[string]bool{}
+	for _, a := range arg {
+		want[strings.ToLower(a)] = true
+	}
+	var todo []api.Item
+	listMu.RLock()
+	for _, orphan := range orphans {
+		if len(want) == 0 || want[strings.ToLower(orphan.TorrentHash)] || want[strings.ToLower(orphan.Name)] {
+			todo = append(todo, orphan)
+		}
+	}
+	listMu.RUnlock()
+	out := []reacquireResult{}
+	for _, orphan := range todo {
+		result := reacquireResult{Name: orphan.Name, Hash: orphan.TorrentHash}
+		if operations.SkipDestructive(ctx, orphan.Name, "reacquire torrent") {
+			out = append(out, result)
+			continue
+		}
+		torrent, err := f.addMagnet(ctx, "magnet:?xt=urn:btih:"+orphan.TorrentHash, "")
+		if err != nil {
+			result.Error = err.Error()
+			out = append(out, result)
+			continue
+		}
+		result.TorrentID = torrent.ID
+		fs.Infof(f, "Reacquired %q as torrent %s", orphan.Name, torrent.ID)
+		listMu.Lock()
+		if i := orphanIndex(orphan.TorrentHash); i >= 0 {
+			orphans = append(orphans[:i], orphans[i+1:]...)
+		}
+		listMu.Unlock()
+		out = append(out, result)
+	}
+	if len(todo) > 0 {
+		forceRefresh()
+		f.saveState()
+	}
+	return out, nil
+}
@@ -0,0 +1,148 @@
+package realdebrid
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// staleEntry is an entry of the state which refers to a torrent or
+// link no longer in the account
+type staleEntry struct {
+	Store string `json:"store"`
+	Key   string `json:"key"`
+}
+
+// orphanScanResult is the output of the orphan-scan command
+type orphanScanResult struct {
+	Stale  []staleEntry `json:"stale"`
+	Pruned int          `json:"pruned"`
+}
+
+// fileKeyLive returns whether the modTimeKey key refers to a file of
+// a torrent with a hash in hashes or a link in links
+func fileKeyLive(key string, hashes, links map[string]bool) bool {
+	if links[key] {
+		return true
+	}
+	if i := strings.IndexByte(key, '/'); i > 0 {
+		return hashes[strings.ToLower(key[:i])]
+	}
+	return false
+}
+
+// staleKeys returns the sorted keys of times which aren't live
+func staleKeys(store string, times map[string]int64, live func(string) bool) (out []staleEntry) {
+	for key := range times {
+		if !live(key) {
+			out = append(out, staleEntry{Store: store, Key: key})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// staleEntries finds the entries of the state which refer to torrents
+// or links no longer in the account
+//
+// Call with listMu held.
+func staleEntries() (out []staleEntry) {
+	ids := map[string]bool{}
+	hashes := map[string]bool{}
+	links := map[string]bool{}
+	for _, torrent := range torrents {
+		ids[torrent.ID] = true
+		hashes[strings.ToLower(torrent.TorrentHash)] = true
+		for _, link := range torrent.Links {
+			links[link] = true
+		}
+	}
+	for link := range cachedLinks {
+		links[link] = true
+	}
+	fileLive := func(key string) bool { return fileKeyLive(key, hashes, links) }
+
+	modTimesMu.Lock()
+	out = append(out, staleKeys("mod_times", modTimes, fileLive)...)
+	modTimesMu.Unlock()
+	openedMu.Lock()
+	out = append(out, staleKeys("opened", opened, func(id string) bool { return ids[id] })...)
+	out = append(out, staleKeys("accessed", accessed, fileLive)...)
+	openedMu.Unlock()
+	tagsMu.Lock()
+	for hash := range tags {
+		if !hashes[hash] {
+			out = append(out, staleEntry{Store: "tags", Key: hash})
+		}
+	}
+	tagsMu.Unlock()
+	for _, id := range brokenIDs() {
+		if !ids[id] {
+			out = append(out, staleEntry{Store: "broken", Key: id})
+		}
+	}
+	return out
+}
+
+// pruneEntry removes the stale entry e from the state
+func pruneEntry(e staleEntry) {
+	switch e.Store {
+	case "mod_times":
+		modTimesMu.Lock()
+		delete(modTimes, e.Key)
+		modTimesMu.Unlock()
+	case "opened":
+		openedMu.Lock()
+		delete(opened, e.Key)
+		openedMu.Unlock()
+	case "accessed":
+		openedMu.Lock()
+		delete(accessed, e.Key)
+		openedMu.Unlock()
+	case "tags":
+		tagsMu.Lock()
+		delete(tags, e.Key)
+		tagsMu.Unlock()
+	case "broken":
+		unmarkBroken(e.Key)
+	}
+}
+
+// orphanScan lists the entries of the state which refer to torrents or
+// links no longer in the account, removing them if prune is set
+func (f *Fs) orphanScan(ctx context.Context, prune bool) (*orphanScanResult, error) {
+	listMu.RLock()
+	empty := len(torrents) == 0
+	listMu.RUnlock()
+	if empty {
+		_, err := f.refreshLibrary(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	listMu.Lock()
+	if err := checkOnline(); err != nil {
+		listMu.Unlock()
+		return nil, err
+	}
+	result := &orphanScanResult{Stale: staleEntries()}
+	listMu.Unlock()
+	if !prune {
+		return result, nil
+	}
+	for _, e := range result.Stale {
+		if operations.SkipDestructive(ctx, e.Store+" "+e.Key, "prune state entry") {
+			continue
+		}
+		pruneEntry(e)
+		result.Pruned++
+	}
+	if result.Pruned > 0 {
+		fs.Infof(f, "orphan-scan: pruned %d stale entries", result.Pruned)
+		f.saveState()
+	}
+	return result, nil
+}
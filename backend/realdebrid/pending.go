@@ -0,0 +1,106 @@
+package realdebrid
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+)
+
+// What to do with files which have no usable direct link yet
+const (
+	unreadyShow    = "show"
+	unreadyHide    = "hide"
+	unreadyPending = "pending"
+)
+
+// pendingDirID is the ID of the directory in the root which lists the
+// files hidden by unready_files = "pending"
+const pendingDirID = ".pending"
+
+// pendingExpiry is how long a file stays pending before it is given up
+// on and forgotten
+const pendingExpiry = 24 * time.Hour
+
+// pendingFile is a file which couldn't be unrestricted to a usable
+// link and since when
+type pendingFile struct {
+	item  api.Item
+	since time.Time
+}
+
+// pending holds the files which couldn't be unrestricted to a usable
+// link by their hoster link
+var pending = map[string]pendingFile{}
+var pendingMu sync.Mutex
+
+// isUnready returns whether item can't be opened yet
+func isUnready(item *api.Item) bool {
+	return item.Link == "" || item.Size <= 0
+}
+
+// setPending records item, the file for link of torrent, as unready
+//
+// The name is made up from the torrent if the unrestrict didn't return
+// one.
+func setPending(link string, index int, torrent *api.Item, item api.Item) {
+	if item.Name == "" {
+		item.Name = fmt.Sprintf("%s - file %d", torrent.Name, index+1)
+	}
+	item.ID = link
+	item.Link = ""
+	item.OriginalLink = link
+	item.Type = api.ItemTypeFile
+	pendingMu.Lock()
+	since := time.Now()
+	if old, ok := pending[link]; ok {
+		since = old.since
+	}
+	pending[link] = pendingFile{item: item, since: since}
+	pendingMu.Unlock()
+}
+
+// clearPending forgets that the file for link was unready
+func clearPending(link string) {
+	pendingMu.Lock()
+	delete(pending, link)
+	pendingMu.Unlock()
+}
+
+// prunePending forgets the pending files which no longer belong to
+// any of current, the torrents in the library, e.g. because the add
+// failed or the torrent was deleted, and those pending for longer
+// than pendingExpiry
+func prunePending(current []api.Item, now time.Time) {
+	links := map[string]bool{}
+	for _, torrent := range current {
+		for _, link := range torrent.Links {
+			links[link] = true
+		}
+	}
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	for link, p := range pending {
+		if !links[link] || now.Sub(p.since) > pendingExpiry {
+			delete(pending, link)
+		}
+	}
+}
+
+// pendingItems returns the unready files sorted by name
+func pendingItems() (items []api.Item) {
+	now := time.Now()
+	pendingMu.Lock()
+	for _, p := range pending {
+		if now.Sub(p.since) <= pendingExpiry {
+			items = append(items, p.item)
+		}
+	}
+	pendingMu.Unlock()
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+	return items
+}
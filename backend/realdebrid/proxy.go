@@ -0,0 +1,46 @@
+package realdebrid
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/rclone/rclone/fs/fshttp"
+)
+
+// proxyDirect is the proxy setting for connecting directly, ignoring
+// any proxy set in the environment
+const proxyDirect = "direct"
+
+// newProxyClient returns an http.Client which sends its requests
+// through proxy, e.g. "socks5://host:1080", or directly if proxy is
+// "direct". An empty proxy gives the usual client which uses the proxy
+// from the environment if any.
+func newProxyClient(ctx context.Context, proxy string) (*http.Client, error) {
+	if proxy == "" {
+		return fshttp.NewClient(ctx), nil
+	}
+	var proxyURL *url.URL
+	if proxy != proxyDirect {
+		var err error
+		proxyURL, err = url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy %q: %w", proxy, err)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("bad proxy %q - want a http, https or socks5 URL or %q", proxy, proxyDirect)
+		}
+	}
+	return &http.Client{
+		Transport: fshttp.NewTransportCustom(ctx, func(t *http.Transport) {
+			if proxyURL == nil {
+				t.Proxy = nil
+			} else {
+				t.Proxy = http.ProxyURL(proxyURL)
+			}
+		}),
+	}, nil
+}
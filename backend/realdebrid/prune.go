@@ -0,0 +1,150 @@
+package realdebrid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// pruneResult is the output of the prune-downloads command
+type pruneResult struct {
+	Deleted []string `json:"deleted"`
+	Failed  []string `json:"failed,omitempty"`
+	Kept    int      `json:"kept"`
+}
+
+// generatedAt returns when the link in item was generated
+func generatedAt(item *api.Item) time.Time {
+	t, err := time.Parse(generatedLayout, item.Generated)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// pruneCandidates returns the entries of cached which were generated
+// before cutoff, if it isn't zero, or if orphaned is set which don't
+// belong to any torrent
+//
+// Downloads imported into /legacy are always kept.
+//
+// Call with listMu held.
+func pruneCandidates(cutoff time.Time, orphaned bool) (out []api.Item) {
+	var links map[string]bool
+	if orphaned {
+		links = map[string]bool{}
+		for _, torrent := range torrents {
+			for _, link := range torrent.Links {
+				links[link] = true
+			}
+		}
+	}
+	for _, item := range cached {
+		if isLegacy(item.OriginalLink) {
+			continue
+		}
+		old := !cutoff.IsZero() && generatedAt(&item).Before(cutoff)
+		gone := orphaned && !links[item.OriginalLink]
+		if old || gone {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// deleteDownload deletes the entry with ID id from the /downloads list
+func (f *Fs) deleteDownload(ctx context.Context, id string) error {
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	opts := rest.Opts{
+		Method:     "DELETE",
+		Path:       "/downloads/delete/" + id,
+		Parameters: f.baseParams(),
+		NoResponse: true,
+	}
+	var resp *http.Response
+	err := f.pacer.Call(func() (bool, error) {
+		var err error
+		resp, err = f.srv.Call(ctx, &opts)
+		return shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't delete download %q: %w", id, err)
+	}
+	return nil
+}
+
+// pruneDownloads deletes the entries of the /downloads list generated
+// before cutoff or not belonging to a torrent if orphaned is set
+func (f *Fs) pruneDownloads(ctx context.Context, cutoff time.Time, orphaned bool) (*pruneResult, error) {
+	listMu.RLock()
+	empty := len(cached) == 0
+	listMu.RUnlock()
+	if empty {
+		_, err := f.refreshLibrary(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	listMu.Lock()
+	candidates := pruneCandidates(cutoff, orphaned)
+	total := len(cached)
+	listMu.Unlock()
+
+	result := &pruneResult{Deleted: []string{}}
+	deleted := map[string]bool{}
+	for _, item := range candidates {
+		if operations.SkipDestructive(ctx, item.Name, "delete download") {
+			continue
+		}
+		err := f.deleteDownload(ctx, item.ID)
+		if err != nil {
+			fs.Errorf(f, "prune-downloads: %v", err)
+			result.Failed = append(result.Failed, item.Name)
+			continue
+		}
+		deleted[item.ID] = true
+		result.Deleted = append(result.Deleted, item.Name)
+	}
+	result.Kept = total - len(deleted)
+	if len(deleted) == 0 {
+		return result, nil
+	}
+
+	listMu.Lock()
+	kept := cached[:0:0]
+	for _, item := range cached {
+		if !deleted[item.ID] {
+			kept = append(kept, item)
+		}
+	}
+	cached = kept
+	indexCached()
+	listMu.Unlock()
+	return result, nil
+}
+
+// pruneCommand runs the prune-downloads backend command
+func (f *Fs) pruneCommand(ctx context.Context, opt map[string]string) (interface{}, error) {
+	var cutoff time.Time
+	if s, ok := opt["older"]; ok {
+		d, err := fs.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad older: %w", err)
+		}
+		cutoff = time.Now().Add(-d)
+	}
+	_, orphaned := opt["orphaned"]
+	if cutoff.IsZero() && !orphaned {
+		return nil, errors.New("need -o older=DURATION and/or -o orphaned")
+	}
+	return f.pruneDownloads(ctx, cutoff, orphaned)
+}
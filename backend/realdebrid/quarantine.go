@@ -0,0 +1,52 @@
+package realdebrid
+
+import (
+	"github.com/rclone/rclone/backend/realdebrid/api"
+)
+
+// IDs of the /.quarantine view which lists the torrents RealDebrid has
+// flagged, and of the torrent folders in it
+const (
+	quarantineDirID  = ".quarantine"
+	quarantinePrefix = quarantineDirID + "/"
+)
+
+// isQuarantined returns whether a torrent with status has been flagged
+// by RealDebrid as content nobody should play
+//
+// RealDebrid reports blocked torrents with the virus status, infringing
+// files are taken down one link at a time instead.
+func isQuarantined(status string) bool {
+	return status == "virus"
+}
+
+// quarantineItems returns a folder for each quarantined torrent
+//
+// Call with listMu held.
+func quarantineItems() (result []api.Item) {
+	for _, torrent := range torrents {
+		if !isQuarantined(torrent.Status) {
+			continue
+		}
+		result = append(result, api.Item{
+			ID:          quarantinePrefix + torrent.ID,
+			Name:        torrent.Name,
+			Type:        api.ItemTypeFolder,
+			Generated:   torrent.Generated,
+			TorrentHash: torrent.TorrentHash,
+		})
+	}
+	return result
+}
+
+// hasQuarantined returns whether any torrent is quarantined
+//
+// Call with listMu held.
+func hasQuarantined() bool {
+	for _, torrent := range torrents {
+		if isQuarantined(torrent.Status) {
+			return true
+		}
+	}
+	return false
+}
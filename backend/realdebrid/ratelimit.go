@@ -0,0 +1,87 @@
+package realdebrid
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// Headers RealDebrid uses to say how fast it may be called
+const (
+	retryAfterHeader     = "Retry-After"
+	rateLimitHeader      = "X-RateLimit-Limit"
+	rateRemainingHeader  = "X-RateLimit-Remaining"
+	defaultRetryAfter    = 2 * time.Second
+	maxRetryAfterSeconds = 3600
+)
+
+// apiQuota is the last reported rate limit of the API
+type apiQuota struct {
+	Limit     int64     `json:"limit"`
+	Remaining int64     `json:"remaining"`
+	Updated   time.Time `json:"updated"`
+}
+
+// quota holds the last quota reported, the zero value if never
+var (
+	quotaMu sync.Mutex
+	quota   apiQuota
+)
+
+// recordQuota remembers the rate limit headers in resp if it has any
+func recordQuota(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	remaining, err := strconv.ParseInt(resp.Header.Get(rateRemainingHeader), 10, 64)
+	if err != nil {
+		return
+	}
+	limit, _ := strconv.ParseInt(resp.Header.Get(rateLimitHeader), 10, 64)
+	quotaMu.Lock()
+	quota = apiQuota{
+		Limit:     limit,
+		Remaining: remaining,
+		Updated:   time.Now(),
+	}
+	quotaMu.Unlock()
+}
+
+// currentQuota returns the last quota reported or nil if none has been
+func currentQuota() *apiQuota {
+	quotaMu.Lock()
+	defer quotaMu.Unlock()
+	if quota.Updated.IsZero() {
+		return nil
+	}
+	q := quota
+	return &q
+}
+
+// retryAfter returns how long resp asks us to wait before trying
+// again, either as a number of seconds or an HTTP date, or
+// defaultRetryAfter if it doesn't say.
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get(retryAfterHeader)
+	if value == "" {
+		return defaultRetryAfter
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 || seconds > maxRetryAfterSeconds {
+			fs.Errorf(nil, "realdebrid: ignoring out of range %s header %q", retryAfterHeader, value)
+			return defaultRetryAfter
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	fs.Errorf(nil, "realdebrid: malformed %s header %q", retryAfterHeader, value)
+	return defaultRetryAfter
+}
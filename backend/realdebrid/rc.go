@@ -0,0 +1,309 @@
+package realdebrid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// rulesMu serialises edits of the sorting rules
+var rulesMu sync.Mutex
+
+// placements holds the folders torrents were moved into by the
+// backend/realdebrid/move rc call by torrent name, overriding the
+// sorting rules. It is protected by placementsMu.
+var placements = map[string]string{}
+var placementsMu sync.Mutex
+
+// placedCategory returns the folder the torrent called name was moved
+// into, or false if it wasn't
+func placedCategory(name string) (string, bool) {
+	placementsMu.Lock()
+	defer placementsMu.Unlock()
+	category, ok := placements[name]
+	return category, ok
+}
+
+// place moves the torrent called name into the folder dir, or back
+// under the sorting rules if dir is ""
+func place(name, dir string) {
+	placementsMu.Lock()
+	if dir == "" {
+		delete(placements, name)
+	} else {
+		placements[name] = dir
+	}
+	placementsMu.Unlock()
+}
+
+// copyPlacements returns a copy of placements
+func copyPlacements() map[string]string {
+	placementsMu.Lock()
+	defer placementsMu.Unlock()
+	out := make(map[string]string, len(placements))
+	for name, category := range placements {
+		out[name] = category
+	}
+	return out
+}
+
+// rulePath returns the folder of a regex_folders entry
+func rulePath(entry string) string {
+	i := strings.IndexByte(entry, '=')
+	if i < 0 {
+		return ""
+	}
+	return cleanDir(strings.TrimSpace(entry[:i]))
+}
+
+// setRuleFolders validates entries and makes them the regex_folders,
+// saving them in the config
+func (f *Fs) setRuleFolders(entries fs.CommaSepList) error {
+	folders, err := parseRuleFolders(entries)
+	if err != nil {
+		return err
+	}
+	sorter, err := newRegexSorter(folders, f.opt.RegexShows, f.opt.RegexMovies)
+	if err != nil {
+		return err
+	}
+	listMu.Lock()
+	f.opt.RegexFolders = entries
+	f.setSortRules(folders, sorter, f.sortRules().parser)
+	listMu.Unlock()
+	if f.m != nil {
+		f.m.Set("regex_folders", entries.String())
+	}
+	// sort everything again
+	forceRefresh()
+	return nil
+}
+
+// addRule adds a regex_folders rule sending torrents matching regex
+// into dir, after the existing ones
+func (f *Fs) addRule(dir, regex string) error {
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	entry := cleanDir(dir) + "=" + regex
+	for _, existing := range f.opt.RegexFolders {
+		if existing == entry {
+			return errors.New("rule already exists")
+		}
+	}
+	entries := append(append(fs.CommaSepList(nil), f.opt.RegexFolders...), entry)
+	return f.setRuleFolders(entries)
+}
+
+// deleteRule deletes the regex_folders rules for dir, returning how
+// many there were
+func (f *Fs) deleteRule(dir string) (int, error) {
+	if err := f.checkWritable(); err != nil {
+		return 0, err
+	}
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	dir = cleanDir(dir)
+	var entries fs.CommaSepList
+	for _, entry := range f.opt.RegexFolders {
+		if rulePath(entry) != dir {
+			entries = append(entries, entry)
+		}
+	}
+	deleted := len(f.opt.RegexFolders) - len(entries)
+	if deleted == 0 {
+		return 0, fmt.Errorf("no rule for %q", dir)
+	}
+	return deleted, f.setRuleFolders(entries)
+}
+
+// isSortingFolder returns whether torrents can be sorted into dir
+func (f *Fs) isSortingFolder(dir string) bool {
+	if isCategoryFolder(dir) {
+		return true
+	}
+	for _, rule := range f.ruleFolders() {
+		if isInDir(rule.path, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// moveTorrent moves the torrent at remote into the sorting folder dir
+// whatever the sorting rules say, or back under the rules if dir is ""
+func (f *Fs) moveTorrent(ctx context.Context, remote, dir string) (from, to string, err error) {
+	if err := f.checkWritable(); err != nil {
+		return "", "", err
+	}
+	if err := f.checkLeader(); err != nil {
+		return "", "", err
+	}
+	dir = cleanDir(dir)
+	if dir != "" && !f.isSortingFolder(dir) {
+		return "", "", fmt.Errorf("%q isn't a sorting folder", dir)
+	}
+	torrent, err := f.torrentForPath(ctx, remote)
+	if err != nil {
+		return "", "", err
+	}
+	from = f.category(torrent.Name)
+	if _, locked := lockedFolder(from); locked {
+		return "", "", errFolderLocked
+	}
+	if _, locked := lockedFolder(dir); locked && dir != "" {
+		return "", "", errFolderLocked
+	}
+	place(torrent.Name, dir)
+	to = f.category(torrent.Name)
+	fs.Infof(f, "Moved %q from %q to %q", torrent.Name, from, to)
+	recordEvent(libraryEvent{
+		hookEvent: hookEvent{
+			Event:     eventMove,
+			Path:      f.torrentPath(torrent),
+			Name:      torrent.Name,
+			TorrentID: torrent.ID,
+			Hash:      torrent.TorrentHash,
+			Time:      time.Now(),
+		},
+		From: from,
+	})
+	f.saveState()
+	forceRefresh()
+	return from, to, nil
+}
+
+// rcFs returns the realdebrid remote named by the fs parameter of in
+func rcFs(ctx context.Context, in rc.Params) (*Fs, error) {
+	f, err := rc.GetFs(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	rf, ok := f.(*Fs)
+	if !ok {
+		return nil, fmt.Errorf("%v isn't a realdebrid remote", f)
+	}
+	if rf.opt.SharedFolder != "folders" {
+		return nil, errors.New("sorting is only used in \"folders\" folder_mode")
+	}
+	return rf, nil
+}
+
+// rcRuleAdd is the backend/realdebrid/rule-add rc call
+func rcRuleAdd(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	f, err := rcFs(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := in.GetString("path")
+	if err != nil {
+		return nil, err
+	}
+	regex, err := in.GetString("regex")
+	if err != nil {
+		return nil, err
+	}
+	err = f.addRule(dir, regex)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{"regex_folders": []string(f.opt.RegexFolders)}, nil
+}
+
+// rcRuleDelete is the backend/realdebrid/rule-delete rc call
+func rcRuleDelete(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	f, err := rcFs(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := in.GetString("path")
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := f.deleteRule(dir)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{"deleted": deleted, "regex_folders": []string(f.opt.RegexFolders)}, nil
+}
+
+// rcMove is the backend/realdebrid/move rc call
+func rcMove(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	f, err := rcFs(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := in.GetString("remote")
+	if err != nil {
+		return nil, err
+	}
+	dir, err := in.GetString("folder")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	from, to, err := f.moveTorrent(ctx, parsePath(remote), dir)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{"from": from, "to": to}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "backend/realdebrid/rule-add",
+		AuthRequired: true,
+		Fn:           rcRuleAdd,
+		Title:        "Add a regex_folders sorting rule to a realdebrid remote",
+		Help: `This adds a rule sending the torrents whose names match regex into
+the folder path, after the existing regex_folders rules, and saves it
+in the config. The rule is checked before anything is changed.
+
+- fs - the realdebrid remote, e.g. "realdebrid:"
+- path - the folder, e.g. "shows/anime"
+- regex - the regular expression matched against torrent names
+
+It returns the regex_folders after the change.
+
+    rclone rc backend/realdebrid/rule-add fs=realdebrid: path=shows/anime 'regex=(?i)\[SubsPlease\]'
+`,
+	})
+	rc.Add(rc.Call{
+		Path:         "backend/realdebrid/rule-delete",
+		AuthRequired: true,
+		Fn:           rcRuleDelete,
+		Title:        "Delete the regex_folders sorting rules for a folder",
+		Help: `This deletes all the regex_folders rules sending torrents into the
+folder path and saves the change in the config.
+
+- fs - the realdebrid remote, e.g. "realdebrid:"
+- path - the folder, e.g. "shows/anime"
+
+It returns how many rules were deleted and the regex_folders after the
+change.
+`,
+	})
+	rc.Add(rc.Call{
+		Path:         "backend/realdebrid/move",
+		AuthRequired: true,
+		Fn:           rcMove,
+		Title:        "Move a torrent into a sorting folder",
+		Help: `This moves a torrent into a sorting folder whatever the sorting rules
+say. The move is saved in the state_file if set. Torrents in locked
+folders can't be moved in or out.
+
+- fs - the realdebrid remote, e.g. "realdebrid:"
+- remote - the path of the torrent folder or a file in it
+- folder - the sorting folder, leave out to sort the torrent by the rules again
+
+It returns the folder the torrent was in and is in now.
+`,
+	})
+}
@@ -0,0 +1,193 @@
+package realdebrid
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// retryReader reads a download and, if the read fails part way
+// through, unrestricts the link again to get a new download node and
+// resumes from where it got to.
+//
+// With download_chunk_size set it reads the download in chunks of
+// that size with a request for each, so a link which expires during a
+// long stream is noticed at the start of the next chunk, e.g. by a 403
+// from the download host, and unrestricted again there.
+type retryReader struct {
+	ctx       context.Context
+	o         *Object
+	in        io.ReadCloser
+	options   []fs.OpenOption // open options without the range
+	offset    int64           // offset of the next byte to read
+	end       int64           // last byte to read or -1 for the end
+	retries   int             // retries done so far
+	url       string          // the direct link being read
+	chunkSize int64           // size of the chunks to request or 0 for one request
+	chunkEnd  int64           // last byte of the open chunk or -1 if it runs to the end
+}
+
+// newRetryReader wraps in which was opened with options
+func newRetryReader(ctx context.Context, o *Object, in io.ReadCloser, options []fs.OpenOption) *retryReader {
+	r := &retryReader{
+		ctx: ctx,
+		o:   o,
+		in:  in,
+		end: -1,
+	}
+	for _, option := range options {
+		if x, ok := option.(*fs.RangeOption); ok {
+			r.offset, r.end = x.Start, x.End
+		} else {
+			r.options = append(r.options, option)
+		}
+	}
+	return r
+}
+
+// newChunkedReader opens o in chunks of chunkSize with options,
+// starting with the direct link last known to work
+func newChunkedReader(ctx context.Context, o *Object, options []fs.OpenOption, chunkSize int64) (*retryReader, error) {
+	r := newRetryReader(ctx, o, nil, options)
+	r.chunkSize = chunkSize
+	r.url = o.url
+	if url, ok := o.fs.validURL(o.originalLink, time.Now()); ok {
+		r.url = url
+	} else if o.linkExpired(time.Now()) && !isTakenDown(o.originalLink) {
+		if err := r.relink(); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.openChunk(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// openChunk opens the chunk starting at the current offset
+//
+// If the direct link fails it is unrestricted again once, as it has
+// most likely expired.
+func (r *retryReader) openChunk() error {
+	last := r.offset + r.chunkSize - 1
+	if r.end >= 0 && last >= r.end {
+		last = -1
+	} else if r.o.size > 0 && last >= r.o.size-1 {
+		last = -1
+	}
+	end := last
+	if end < 0 {
+		end = r.end
+	}
+	options := append(append([]fs.OpenOption{}, r.options...), &fs.RangeOption{Start: r.offset, End: end})
+	in, err := r.o.download(r.ctx, r.url, options)
+	if err != nil {
+		if r.o.originalLink == "" || r.ctx.Err() != nil || isTakenDown(r.o.originalLink) {
+			return err
+		}
+		fs.Debugf(r.o, "Download link failed at offset %d, unrestricting it again: %v", r.offset, err)
+		if err := r.relink(); err != nil {
+			return err
+		}
+		in, err = r.o.download(r.ctx, r.url, options)
+		if err != nil {
+			return err
+		}
+	}
+	r.o.fs.rememberURL(r.o.originalLink, r.url, time.Now())
+	r.in = in
+	r.chunkEnd = last
+	return nil
+}
+
+// Read bytes retrying on failure
+func (r *retryReader) Read(p []byte) (n int, err error) {
+	n, err = r.in.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.chunkSize > 0 && r.chunkEnd >= 0 && r.offset > r.chunkEnd && r.ctx.Err() == nil {
+		_ = r.in.Close()
+		if err := r.openChunk(); err != nil {
+			fs.Errorf(r.o, "Failed to open the next chunk at offset %d: %v", r.offset, err)
+			r.in = nopReadCloser{}
+			return n, err
+		}
+		return n, nil
+	}
+	if err == nil || err == io.EOF || r.ctx.Err() != nil {
+		return n, err
+	}
+	if r.retries >= r.o.fs.opt.StreamRetries {
+		fs.Errorf(r.o, "Giving up reading after %d retries: %v", r.retries, err)
+		return n, err
+	}
+	r.retries++
+	fs.Logf(r.o, "Read failed at offset %d, resuming from a new download link (retry %d/%d): %v", r.offset, r.retries, r.o.fs.opt.StreamRetries, err)
+	if reopenErr := r.reopen(); reopenErr != nil {
+		fs.Errorf(r.o, "Failed to resume download: %v", reopenErr)
+		if errors.Is(reopenErr, errSizeChanged) {
+			return n, reopenErr
+		}
+		return n, err
+	}
+	return n, nil
+}
+
+// relink unrestricts the link again to get a new direct link
+func (r *retryReader) relink() error {
+	item, err := r.o.fs.unrestrict(r.ctx, r.o.originalLink)
+	if err != nil {
+		return err
+	}
+	if item.Link == "" {
+		return errors.New("no download link returned")
+	}
+	if err := r.o.checkSize(item); err != nil {
+		return err
+	}
+	r.url = item.Link
+	return nil
+}
+
+// reopen unrestricts the link again and opens it at the current offset
+func (r *retryReader) reopen() error {
+	_ = r.in.Close()
+	r.in = nopReadCloser{}
+	if err := r.relink(); err != nil {
+		return err
+	}
+	if r.chunkSize > 0 {
+		return r.openChunk()
+	}
+	options := append([]fs.OpenOption{}, r.options...)
+	if r.offset > 0 || r.end >= 0 {
+		options = append(options, &fs.RangeOption{Start: r.offset, End: r.end})
+	}
+	in, err := r.o.download(r.ctx, r.url, options)
+	if err != nil {
+		return err
+	}
+	r.o.fs.rememberURL(r.o.originalLink, r.url, time.Now())
+	r.in = in
+	return nil
+}
+
+// nopReadCloser stands in for a download which couldn't be opened
+type nopReadCloser struct{}
+
+// Read returns io.ErrUnexpectedEOF as the download is incomplete
+func (nopReadCloser) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+// Close does nothing
+func (nopReadCloser) Close() error {
+	return nil
+}
+
+// Close the reader
+func (r *retryReader) Close() error {
+	return r.in.Close()
+}
@@ -0,0 +1,46 @@
+package realdebrid
+
+import (
+	"errors"
+)
+
+// errReadOnly is returned by anything which would change the account
+// or the saved library state of a read_only remote
+var errReadOnly = errors.New("not allowed as read_only is set")
+
+// writeCommands are the backend commands which change the account or
+// the library state
+var writeCommands = map[string]bool{
+	"sort-import":      true,
+	"lock":             true,
+	"unlock":           true,
+	"prune-downloads":  true,
+	"select":           true,
+	"replace":          true,
+	"import-downloads": true,
+	"reacquire":        true,
+}
+
+// commandWrites returns whether the backend command name with opt
+// changes the account or the library state
+func commandWrites(name string, opt map[string]string) bool {
+	if writeCommands[name] {
+		return true
+	}
+	switch name {
+	case "tag":
+		return opt["add"] != "" || opt["remove"] != ""
+	case "orphan-scan":
+		_, prune := opt["prune"]
+		return prune
+	}
+	return false
+}
+
+// checkWritable returns errReadOnly if the remote is read_only
+func (f *Fs) checkWritable() error {
+	if f.opt.ReadOnly {
+		return errReadOnly
+	}
+	return nil
+}
@@ -25,25 +25,32 @@ import (
 	"net/url"
 	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/rclone/rclone/backend/realdebrid/api"
 	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
 	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/config/configmap"
 	"github.com/rclone/rclone/fs/config/configstruct"
 	"github.com/rclone/rclone/fs/config/obscure"
 	"github.com/rclone/rclone/fs/fserrors"
-	"github.com/rclone/rclone/fs/fshttp"
 	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
 	"github.com/rclone/rclone/lib/dircache"
 	"github.com/rclone/rclone/lib/encoder"
 	"github.com/rclone/rclone/lib/oauthutil"
 	"github.com/rclone/rclone/lib/pacer"
 	"github.com/rclone/rclone/lib/rest"
 	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -75,10 +82,28 @@ var (
 //Realdebrid content is provided in pages with 100 items per page.
 //To limit api calls all pages are stored here and are only updated on changes in the total length
 var cached []api.Item
+var cachedLinks map[string]int
 var torrents []api.Item
-var broken_torrents []string
+var brokenTorrents = map[string]brokenTorrent{}
 var lastcheck int64 = time.Now().Unix()
 var interval int64 = 15 * 60
+var unrestricts int64
+
+// cachedLinks indexes cached by OriginalLink. A file's direct link is
+// identified by the hoster link it was unrestricted from, never by its
+// name, so renaming can't make it look expired.
+//
+// listMu protects cached, cachedLinks and torrents. These are copied
+// on write: the refresh of the root reads the lists, repairs and
+// evictions without holding it and only holds it exclusively to swap
+// in the new ones, so listings aren't held up by the API calls.
+// refreshMu makes sure only one refresh runs at a time.
+// brokenTorrents is protected by brokenMu. lastcheck and unrestricts,
+// the number of links unrestricted since lastcheck, are only accessed
+// atomically.
+var listMu sync.RWMutex
+var refreshMu sync.Mutex
+var brokenMu sync.Mutex
 
 // Register with Fs
 func init() {
@@ -86,10 +111,16 @@ func init() {
 		Name:        "realdebrid",
 		Description: "real-debrid.com",
 		NewFs:       NewFs,
+		CommandHelp: commandHelp,
 		Options: []fs.Option{{
 			Name:    "api_key",
 			Help:    `please provide your RealDebrid API key.`,
 			Default: "",
+		}, {
+			Name:     "api_key_failover",
+			Help:     `comma separated list of API keys of further RealDebrid accounts. When the account in use is rate limited, locked or out of traffic the next account is used for a while before going back to the first. Calls on a torrent are always sent to the account it was listed or added with. The torrent lists of the accounts are not merged, a listing that fails over part way is started again from the new account, so these should be accounts holding the same torrents, e.g. backup accounts. Only used with api_key. Default: ""`,
+			Advanced: true,
+			Default:  fs.CommaSepList{},
 		}, {
 			Name:     "download_mode",
 			Help:     `please choose which RealDebrid directory to serve: For the /downloads page, type "downloads". For the /torrents page, type "torrents". Default: "torrents"`,
@@ -110,6 +141,321 @@ func init() {
 			Help:     `please define the regex definition that will determine if a torrent should be classified as a movie. Default: "(?i)(19|20)([0-9]{2} ?\.?)"`,
 			Advanced: true,
 			Default:  `(?i)(19|20)([0-9]{2} ?\.?)`,
+		}, {
+			Name:     "regex_folders",
+			Help:     `comma separated list of extra folders to sort torrents into, as path=regex, e.g. "shows/anime=(?i)\[SubsPlease\],kids/movies=(?i)pixar". A torrent goes into the first folder whose regex matches its name, before regex_shows and regex_movies are tried. The path may be nested and any folders on the way are made up. Quote entries containing commas like a CSV field. Rules can also be added and deleted while mounted with the backend/realdebrid/rule-add and rule-delete rc calls. Default: ""`,
+			Advanced: true,
+			Default:  fs.CommaSepList{},
+		}, {
+			Name:     "name_parser",
+			Help:     `how to pick torrent names apart into title, year, season, episode and quality, which then sorts torrents regex_shows and regex_movies don't match into shows or movies. Use "builtin" for the built in parser, an http or https URL of a service to ask with ?name= or the path of a program to run with the name as its argument. Leave empty to sort by the regexes alone, sort-test still shows what the built in parser makes of a name. Either should answer with a JSON object with title, year, season, episode and quality. Default: ""`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "sort_downloads",
+			Help:     `sort the downloads imported into /legacy by import-downloads, e.g. files unrestricted from hosters, into the same folders as torrents. Their file names are matched against regex_folders, regex_shows and regex_movies and those which match none of them stay in /legacy. Default: false`,
+			Advanced: true,
+			Default:  false,
+		}, {
+			Name:     "root_layout",
+			Help:     `please choose how the root shows the downloads which don't belong to a torrent, e.g. files unrestricted from hosters, next to the torrents. Only used in "folders" folder_mode. Default: ""`,
+			Advanced: true,
+			Default:  "",
+			Examples: []fs.OptionExample{{
+				Value: "",
+				Help:  "Only show torrents, hoster downloads can be imported into /legacy with import-downloads",
+			}, {
+				Value: layoutMerged,
+				Help:  "Sort hoster downloads into the same folders as torrents, going into default if they match no regex",
+			}, {
+				Value: layoutSplit,
+				Help:  "Show torrents under /torrents and hoster downloads under /downloads",
+			}},
+		}, {
+			Name:     "root_include",
+			Help:     `regular expression of the paths to show, e.g. "^shows/" to only show the shows folder. It is matched against the full path of each file and folder from the root of the remote, with a "/" at the end of folders. Leave empty to show everything. Default: ""`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "root_exclude",
+			Help:     `regular expression of the paths to hide, matched like root_include and applied after it, e.g. "^default/". Leave empty to hide nothing. Default: ""`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "on_duplicate",
+			Help:     `please choose what to do when a torrent being added, e.g. by uploading a .magnet file, has the same info hash as one already in the library. Default: "map-existing"`,
+			Advanced: true,
+			Default:  duplicateMapExisting,
+			Examples: []fs.OptionExample{{
+				Value: duplicateMapExisting,
+				Help:  "Don't add it, move the torrent already there into the sorting folder it was uploaded to",
+			}, {
+				Value: duplicateSkip,
+				Help:  "Don't add it and fail the upload",
+			}, {
+				Value: duplicateAddAnyway,
+				Help:  "Add it again anyway",
+			}},
+		}, {
+			Name:     "select_exclude",
+			Help:     `regular expression of the files not to select when adding a torrent, matched against the path of the file inside the torrent, e.g. "(?i)(\.(exe|iso|nfo|txt)$|/screens/)". The files are never downloaded so they don't use up the account. If it would exclude every file then all are selected. Leave empty to select everything. Default: ""`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "flatten_single",
+			Help:     `set to true to show torrents that only contain a single file as that file instead of a folder containing it. Only used in "folders" folder_mode. Default: false`,
+			Advanced: true,
+			Default:  false,
+		}, {
+			Name:     "show_statuses",
+			Help:     `comma separated list of the RealDebrid statuses of the torrents to list, e.g. "downloaded,uploading,compressing". Torrents with any other status are left out of the sorting folders, /.recent and /.by-hash, e.g. to hide torrents until they are downloaded. The statuses are magnet_error, magnet_conversion, waiting_files_selection, queued, downloading, downloaded, error, virus, compressing, uploading and dead. Torrents with the virus status are only ever listed in /.quarantine. Leave empty to list torrents of every status. Default: ""`,
+			Advanced: true,
+			Default:  fs.CommaSepList{},
+		}, {
+			Name:     "unready_files",
+			Help:     `please choose what to do with files which couldn't be unrestricted to a working link and would be listed with a size of 0. Default: "show"`,
+			Advanced: true,
+			Default:  unreadyShow,
+			Examples: []fs.OptionExample{{
+				Value: unreadyShow,
+				Help:  "List them anyway, opening them will fail",
+			}, {
+				Value: unreadyHide,
+				Help:  "Leave them out of listings until they have a working link",
+			}, {
+				Value: unreadyPending,
+				Help:  "Leave them out of listings and list them in a .pending folder in the root instead. Only used in \"folders\" folder_mode",
+			}},
+		}, {
+			Name:     "samples",
+			Help:     `please choose what to do with sample files, which are videos much smaller than the biggest file of their torrent or than sample_size, or named as samples. Default: "show"`,
+			Advanced: true,
+			Default:  samplesShow,
+			Examples: []fs.OptionExample{{
+				Value: samplesShow,
+				Help:  "List them next to the other files",
+			}, {
+				Value: samplesHide,
+				Help:  "Leave them out of listings",
+			}, {
+				Value: samplesQuarantine,
+				Help:  "Leave them out of listings and list them in a .samples folder in the root instead. Only used in \"folders\" folder_mode",
+			}},
+		}, {
+			Name:     "sample_size",
+			Help:     `videos smaller than this in a torrent with other files are samples, as well as those smaller than 2% of the biggest file. Default: 0`,
+			Advanced: true,
+			Default:  fs.SizeSuffix(0),
+		}, {
+			Name:     "mtime_from_name",
+			Help:     `set to true to use the date in the names of files and folders, e.g. "Show.2024.05.01.1080p", as their modification time instead of when they were added, so daily shows sort by air date. This takes priority over times set with SetModTime. Default: false`,
+			Advanced: true,
+			Default:  false,
+		}, {
+			Name:     "case_insensitive",
+			Help:     `set to false to treat names which only differ in case as different files. When true, the default, looking up a name ignores case and files whose names only differ in case are handled by conflict_policy. Default: true`,
+			Advanced: true,
+			Default:  true,
+		}, {
+			Name:     "conflict_policy",
+			Help:     `please choose what to do when two files end up with the same name in the same directory. Use "rclone backend conflicts" to see the conflicts found. Default: "keep-both"`,
+			Advanced: true,
+			Default:  conflictKeepBoth,
+			Examples: []fs.OptionExample{{
+				Value: conflictKeepBoth,
+				Help:  "Keep all the files, adding a short torrent hash to the names of the newer ones",
+			}, {
+				Value: conflictKeepNewest,
+				Help:  "Only show the most recently added file",
+			}, {
+				Value: conflictKeepLargest,
+				Help:  "Only show the largest file",
+			}, {
+				Value: conflictError,
+				Help:  "Fail the listing of the directory",
+			}},
+		}, {
+			Name:     "recent_files",
+			Help:     `the number of files to list in the .recent folder in the root, which shows the files of the most recently added torrents newest first. Only used in "folders" folder_mode. Set to 0 to leave the folder out. Default: 50`,
+			Advanced: true,
+			Default:  50,
+		}, {
+			Name:     "recent_window",
+			Help:     `how long ago a torrent may have been added to be listed in the .recent folder. Default: 7d`,
+			Advanced: true,
+			Default:  fs.Duration(7 * 24 * time.Hour),
+		}, {
+			Name:     "show_unselected",
+			Help:     `set to true to show the files of torrents which weren't selected when they were added in a .unselected folder, so more of them can be chosen with "rclone backend select". The refreshes of the library read the files of every downloaded torrent not read before. Only used with folder_mode "folders". Default: false`,
+			Advanced: true,
+			Default:  false,
+		}, {
+			Name:     "scoped_refresh",
+			Help:     `set to true to stop listings from waiting for a crawl of the whole account when the library is due a refresh. Listing a torrent folder reads just that torrent again and the rest of the library is refreshed in the background, the root being served from cache until it is done. Default: false`,
+			Advanced: true,
+			Default:  false,
+		}, {
+			Name:     "refresh_jitter",
+			Help:     `the most to add at random to the 15 minute refresh interval each time, so that several instances sharing an account, or started together, don't all crawl it at the same moment. Default: 1m`,
+			Advanced: true,
+			Default:  fs.Duration(time.Minute),
+		}, {
+			Name:     "max_repairs_per_refresh",
+			Help:     `the maximum number of dead or broken torrents to re-download in one refresh, the rest being left for the following ones, so that a lot of dead torrents don't make a burst of API calls. Set to 0 for no limit. Default: 0`,
+			Advanced: true,
+			Default:  0,
+		}, {
+			Name:     "max_unrestricts_per_cycle",
+			Help:     `the maximum number of links unrestricted while listing between two refreshes of the library, to keep large library scans from running into the RealDebrid API limits. Files whose links are over budget are left out of listings until the next refresh. Opening a torrent folder may use the whole budget, other listings only half of it. Set to 0 for no limit. Default: 0`,
+			Advanced: true,
+			Default:  0,
+		}, {
+			Name:     "unrestrict_concurrency",
+			Help:     `the number of links of a torrent to unrestrict at the same time. The calls are still paced so this mostly saves waiting for each round trip when a torrent with many files is listed. Default: 4`,
+			Advanced: true,
+			Default:  4,
+		}, {
+			Name:     "max_torrents",
+			Help:     `the maximum number of torrents to keep on the account. When a refresh finds more, torrents are deleted following eviction_policy until there are only this many left. Set this below the torrent limit of RealDebrid to keep automated additions from failing. Set to 0 to never delete torrents. Default: 0`,
+			Advanced: true,
+			Default:  0,
+		}, {
+			Name:     "eviction_policy",
+			Help:     `please choose which torrents are deleted first when there are more than max_torrents. Default: "oldest-unwatched-first"`,
+			Advanced: true,
+			Default:  evictOldestUnwatched,
+			Examples: []fs.OptionExample{{
+				Value: evictOldestUnwatched,
+				Help:  "Torrents which have never been opened, oldest first, then the least recently opened. Opens are remembered between runs if state_file is set",
+			}, {
+				Value: evictLargest,
+				Help:  "The largest torrents",
+			}},
+		}, {
+			Name:     "scan_storm_listings",
+			Help:     `the number of listings within 10 seconds, without any file being opened, which are taken as a scan by a media server like Plex or Jellyfin. During a scan the library is served from what is already known: refreshes are put off and new links aren't unrestricted until 30 seconds after the scan stops or a file is opened. Set to 0 to disable. Default: 0`,
+			Advanced: true,
+			Default:  0,
+		}, {
+			Name:     "maintenance_window",
+			Help:     `only run expensive operations like the periodic refresh of all links and the repair of dead torrents in this daily time window, given in local time as "HH:MM-HH:MM", e.g. "03:00-05:00". Leave empty to run them whenever they are needed. Default: ""`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "coordination_file",
+			Help:     `path of a file on storage shared by all the machines mounting the same account, e.g. a network share. The instance holding a lease in it does the refreshes, repairs, evictions and saves of the state_file, the others only read. The lease moves to another instance if it isn't renewed for 30 minutes. Leave empty if only one instance uses the account. Default: ""`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "read_only",
+			Help:     `set to true to only list and stream, e.g. for a second remote using another device token of the same account or a token shared by someone else. Deleting torrents and links, adding torrents, repairs, evictions and any change to the sorting data or the state_file are refused. Default: false`,
+			Advanced: true,
+			Default:  false,
+		}, {
+			Name:     "on_add",
+			Help:     `path of a program to run when a torrent is added, e.g. to start a partial scan of a media server. It is passed the event name as its argument and a JSON object with the event, the path of the torrent folder, its name, torrent_id and hash on its standard input. Default: ""`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "on_remove",
+			Help:     `path of a program to run when a torrent is removed, called like on_add. Default: ""`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "on_repair",
+			Help:     `path of a program to run when a dead torrent has been repaired, called like on_add. Default: ""`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "stream_retries",
+			Help:     `how many times a download which fails part way through is resumed from a freshly unrestricted link, which usually points at a different download node. Set to 0 to disable. Default: 3`,
+			Advanced: true,
+			Default:  3,
+		}, {
+			Name:     "download_chunk_size",
+			Help:     `read downloads in chunks of this size with a request for each instead of in one request. A direct link can expire during a long stream, and the download host then refuses the next request, e.g. with a 403. With chunks this is found at the start of the next chunk and the link is unrestricted again there, so the stream carries on at the same offset instead of failing. Set to 0 to read each download in one request. Default: 0`,
+			Advanced: true,
+			Default:  fs.SizeSuffix(0),
+		}, {
+			Name:     "background_bwlimit",
+			Help:     `the combined bandwidth limit of background transfers, e.g. 10M, so that backups of the library don't starve playback on the same account. Transfers are background when run with --header-download "` + backgroundHeader + `: 1", e.g. by "rclone sync". Default: off`,
+			Advanced: true,
+			Default:  fs.SizeSuffix(0),
+		}, {
+			Name:     "disk_cache_dir",
+			Help:     `directory to keep a local cache of the files read in. Files are cached in blocks of 4 MiB so repeatedly watched episodes and the small reads of players probing files don't download them again. This works alongside the VFS cache and is shared by remotes using the same directory. Leave empty to not use it. Default: ""`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "disk_cache_size",
+			Help:     `the maximum size of the disk_cache_dir. The least recently used blocks are removed when it gets bigger. Default: 10G`,
+			Advanced: true,
+			Default:  fs.SizeSuffix(10 * 1024 * 1024 * 1024),
+		}, {
+			Name:     "free_space",
+			Help:     `the free space to report to "rclone about" and to a mount when it is asked, e.g. 10T. Real-Debrid has no quota to report, and some programs (e.g. sonarr/radarr) refuse to import onto a drive which shows no free space. Files are always remote, so they can't be hard linked and have a link count of 1 - set those programs to copy instead. Default: off`,
+			Advanced: true,
+			Default:  fs.SizeSuffix(-1),
+		}, {
+			Name:     "state_file",
+			Help:     `path of a local file to keep the library state in between runs. It is loaded on start up and saved after every refresh. This keeps the modification times of files at the time they were first seen, so they can be compared by sync and set with SetModTime. The file has the format of "rclone backend sort-export". Entries of it which can't be loaded, e.g. after a crash, are moved to a file named like it with ".rejected" and the time added. A file saved by an older version is upgraded in place, keeping the old one with its version added to the name, e.g. ".v1". Default: ""`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "manifest_file",
+			Help:     `path of a local JSON file to keep an up to date list of every file in the library in, by its path in the remote, with its size, the info hash and ID of its torrent and when its download link is expected to expire. It is rewritten whenever the library changes, so dashboards and import scripts can read it instead of calling rclone. Default: ""`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "async_startup",
+			Help:     `set to true to return from start up straight away instead of waiting for the first listing of the library, which can take minutes on big accounts. Until the library has been read in the background the listings are made from the state_file, or are empty if there isn't one. Default: false`,
+			Advanced: true,
+			Default:  false,
+		}, {
+			Name:     "negative_cache_time",
+			Help:     `how long to remember that a path doesn't exist, so that sync, which looks up many paths which don't exist yet, doesn't list the directory again for each one. The paths are forgotten when the library is refreshed. Set to 0 to always look paths up. Default: 30s`,
+			Advanced: true,
+			Default:  fs.Duration(30 * time.Second),
+		}, {
+			Name:     "staging_remote",
+			Help:     `a local path or remote, e.g. "/srv/rd-staging" or "gdrive:rd-staging", to store files written to the library in. Media managers write posters and .nfo files next to the media, which would fail as RealDebrid can't store them. They are shown in the library as if they were on RealDebrid, alongside the torrents, and can be deleted again. Files called .magnet are still added as torrents. Default: "" which means writing other files fails`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "open_cache_time",
+			Help:     `how long to keep using a download link which opened successfully without checking it again. Players open the same file many times in a row to probe it, and an expired link would otherwise be found to fail and unrestricted again on every open. Set to 0 to always start from the link in the listing. Default: 1m`,
+			Advanced: true,
+			Default:  fs.Duration(time.Minute),
+		}, {
+			Name:     "download_proxy",
+			Help:     `the proxy to download files through, e.g. "socks5://127.0.0.1:1080" or "http://proxy:3128", while the API calls are made as usual. Set to "direct" to download without the proxy set in the environment. Default: "" which means the same as the API calls`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "api_proxy",
+			Help:     `the proxy to make the API calls through, in the same format as download_proxy. Set to "direct" to make them without the proxy set in the environment, e.g. so only downloads go through it. Default: "" which means the proxy from the environment if any`,
+			Advanced: true,
+			Default:  "",
+		}, {
+			Name:     "link_validity",
+			Help:     `how long a download link is expected to keep working after it was generated. RealDebrid doesn't say when its links expire so this is an estimate which is used to tell which links are about to go stale, e.g. by the link-expiry command, and to unrestrict a link again before opening it rather than after it failed. Set to 0 to never treat links as expired. Default: 24h`,
+			Advanced: true,
+			Default:  fs.Duration(24 * time.Hour),
+		}, {
+			Name:     "status_sweep",
+			Help:     `how often to read the status of every torrent even if the number of torrents hasn't changed. Torrents which have gone dead, failed or been flagged as a virus on RealDebrid's side are then found and queued for repair before anyone tries to play them, rather than at the next full refresh or when a link fails. Each sweep lists all the torrents so don't set it too low on large libraries. Set to 0 to only read them at the full refresh. Default: 0`,
+			Advanced: true,
+			Default:  fs.Duration(0),
+		}, {
+			Name:     "max_downloads",
+			Help:     `the most files to download from RealDebrid at once. RealDebrid limits how many downloads an account can have going at the same time and rejects more with errors which don't say why, so with this set new opens wait for a download to finish instead. Files read through the disk_cache_dir take a slot while each block is downloaded. Set to 0 for no limit. Default: 0`,
+			Advanced: true,
+			Default:  0,
+		}, {
+			Name:     "download_queue_timeout",
+			Help:     `how long an open waits for a download slot when max_downloads are already going before it fails. Set to 0 to wait as long as it takes. Default: 30s`,
+			Advanced: true,
+			Default:  fs.Duration(30 * time.Second),
 		}, {
 			Name:     config.ConfigEncoding,
 			Help:     config.ConfigEncodingHelp,
@@ -125,38 +471,113 @@ func init() {
 
 // Options defines the configuration for this backend
 type Options struct {
-	RegexShows   string               `config:"regex_shows"`
-	RegexMovies  string               `config:"regex_movies"`
-	SharedFolder string               `config:"folder_mode"`
-	RootFolderID string               `config:"download_mode"`
-	APIKey       string               `config:"api_key"`
-	Enc          encoder.MultiEncoder `config:"encoding"`
+	RegexShows      string               `config:"regex_shows"`
+	RegexMovies     string               `config:"regex_movies"`
+	RegexFolders    fs.CommaSepList      `config:"regex_folders"`
+	NameParser      string               `config:"name_parser"`
+	RootInclude     string               `config:"root_include"`
+	RootExclude     string               `config:"root_exclude"`
+	SelectExclude   string               `config:"select_exclude"`
+	OnDuplicate     string               `config:"on_duplicate"`
+	FlattenSingle   bool                 `config:"flatten_single"`
+	ShowStatuses    fs.CommaSepList      `config:"show_statuses"`
+	UnreadyFiles    string               `config:"unready_files"`
+	Samples         string               `config:"samples"`
+	SampleSize      fs.SizeSuffix        `config:"sample_size"`
+	MtimeFromName   bool                 `config:"mtime_from_name"`
+	CaseInsensitive bool                 `config:"case_insensitive"`
+	ConflictPolicy  string               `config:"conflict_policy"`
+	RecentFiles     int                  `config:"recent_files"`
+	RecentWindow    fs.Duration          `config:"recent_window"`
+	MaxUnrestricts  int                  `config:"max_unrestricts_per_cycle"`
+	UnrestrictConc  int                  `config:"unrestrict_concurrency"`
+	MaxTorrents     int                  `config:"max_torrents"`
+	EvictionPolicy  string               `config:"eviction_policy"`
+	ScanStorm       int                  `config:"scan_storm_listings"`
+	Maintenance     string               `config:"maintenance_window"`
+	Coordination    string               `config:"coordination_file"`
+	OnAdd           string               `config:"on_add"`
+	OnRemove        string               `config:"on_remove"`
+	OnRepair        string               `config:"on_repair"`
+	StreamRetries   int                  `config:"stream_retries"`
+	ChunkSize       fs.SizeSuffix        `config:"download_chunk_size"`
+	SharedFolder    string               `config:"folder_mode"`
+	RootFolderID    string               `config:"download_mode"`
+	APIKey          string               `config:"api_key"`
+	APIKeys         fs.CommaSepList      `config:"api_key_failover"`
+	BackgroundLimit fs.SizeSuffix        `config:"background_bwlimit"`
+	DiskCacheDir    string               `config:"disk_cache_dir"`
+	DiskCacheSize   fs.SizeSuffix        `config:"disk_cache_size"`
+	FreeSpace       fs.SizeSuffix        `config:"free_space"`
+	StateFile       string               `config:"state_file"`
+	AsyncStartup    bool                 `config:"async_startup"`
+	NegativeCache   fs.Duration          `config:"negative_cache_time"`
+	StagingRemote   string               `config:"staging_remote"`
+	OpenCacheTime   fs.Duration          `config:"open_cache_time"`
+	ShowUnselected  bool                 `config:"show_unselected"`
+	ScopedRefresh   bool                 `config:"scoped_refresh"`
+	RefreshJitter   fs.Duration          `config:"refresh_jitter"`
+	MaxRepairs      int                  `config:"max_repairs_per_refresh"`
+	DownloadProxy   string               `config:"download_proxy"`
+	APIProxy        string               `config:"api_proxy"`
+	LinkValidity    fs.Duration          `config:"link_validity"`
+	StatusSweep     fs.Duration          `config:"status_sweep"`
+	SortDownloads   bool                 `config:"sort_downloads"`
+	RootLayout      string               `config:"root_layout"`
+	ManifestFile    string               `config:"manifest_file"`
+	MaxDownloads    int                  `config:"max_downloads"`
+	SlotTimeout     fs.Duration          `config:"download_queue_timeout"`
+	ReadOnly        bool                 `config:"read_only"`
+	Enc             encoder.MultiEncoder `config:"encoding"`
 }
 
 // Fs represents a remote cloud storage system
 type Fs struct {
-	name         string             // name of this remote
-	root         string             // the path we are working on
-	opt          Options            // parsed options
-	features     *fs.Features       // optional features
-	srv          *rest.Client       // the connection to the server
-	dirCache     *dircache.DirCache // Map of directory path to directory id
-	pacer        *fs.Pacer          // pacer for API calls
-	tokenRenewer *oauthutil.Renew   // renew the token on expiry
+	name          string                // name of this remote
+	root          string                // the path we are working on
+	opt           Options               // parsed options
+	m             configmap.Mapper      // config map for saving changes
+	features      *fs.Features          // optional features
+	srv           *rest.Client          // the connection to the server
+	dirCache      *dircache.DirCache    // Map of directory path to directory id
+	pacer         *fs.Pacer             // pacer for API calls
+	tokenRenewer  *oauthutil.Renew      // renew the token on expiry
+	conflictsMu   *sync.Mutex           // protects conflicts
+	conflicts     map[string][]conflict // name conflicts found by directory ID
+	window        *maintenanceWindow    // when expensive operations may run, nil for always
+	accounts      *accounts             // API keys to route calls to
+	cache         *blockCache           // disk cache of blocks of files, nil if not in use
+	scan          *scanGuard            // detects scan storms, nil if not in use
+	coord         *coordinator          // shares maintenance with other instances, nil if not in use
+	rootInclude   *regexp.Regexp        // paths to show, nil for all
+	rootExclude   *regexp.Regexp        // paths to hide, nil for none
+	selectExclude *regexp.Regexp        // files not to select in new torrents, nil for none
+	rules         atomic.Value          // *sortRules, the regex_folders and the sorter using them
+	warm          chan struct{}         // closed when the async_startup crawl is done, nil if not in use
+	background    *rate.Limiter         // limits background transfers, nil if not in use
+	misses        *missCache            // paths recently not found, nil if not in use
+	index         *pathIndex            // items of the directories listed since the last refresh
+	lookups       *singleflight.Group   // shares concurrent lookups of the same path
+	staging       fs.Fs                 // where files written to the library are stored, nil if not in use
+	dl            *rest.Client          // the connection for downloads, which may be f.srv
+	slots         *downloadSlots        // counts and limits the downloads streaming at once
 }
 
 // Object describes a file
 type Object struct {
-	fs          *Fs       // what this object is part of
-	remote      string    // The remote path
-	hasMetaData bool      // metadata is present and correct
-	size        int64     // size of the object
-	modTime     time.Time // modification time of the object
-	id          string    // ID of the object
-	ParentID    string    // ID of parent directory
-	mimeType    string    // Mime type of object
-	url         string    // URL to download file
-	TorrentHash string    // Torrent Hash
+	fs           *Fs       // what this object is part of
+	remote       string    // The remote path
+	hasMetaData  bool      // metadata is present and correct
+	size         int64     // size of the object
+	modTime      time.Time // modification time of the object
+	id           string    // ID of the object
+	ParentID     string    // ID of parent directory
+	mimeType     string    // Mime type of object
+	url          string    // URL to download file
+	originalLink string    // hoster link the URL was unrestricted from
+	generated    time.Time // when the URL was generated if known
+	TorrentHash  string    // Torrent Hash
+	fileID       int64     // RealDebrid ID of the file in its torrent, 0 if not known
 }
 
 // ------------------------------------------------------------
@@ -187,6 +608,23 @@ func parsePath(path string) (root string) {
 	return
 }
 
+// standardName converts a file or folder name as returned by
+// RealDebrid into the name shown in the virtual tree.
+//
+// Torrent names are chosen by whoever made the torrent so as well as
+// applying the configured encoding, any invalid UTF-8 left over is
+// quoted and an empty name is replaced with fallback.
+func (f *Fs) standardName(name, fallback string) string {
+	name = f.opt.Enc.ToStandardName(name)
+	if !utf8.ValidString(name) {
+		name = encoder.EncodeInvalidUtf8.Encode(name)
+	}
+	if name == "" {
+		name = f.opt.Enc.ToStandardName(fallback)
+	}
+	return name
+}
+
 // retryErrorCodes is a slice of error codes that we will retry
 var retryErrorCodes = []int{
 	429, // Too Many Requests.
@@ -202,10 +640,18 @@ func shouldRetry(ctx context.Context, resp *http.Response, err error) (bool, err
 	if fserrors.ContextError(ctx, &err) {
 		return false, err
 	}
+	recordQuota(resp)
+	// For 429 errors wait as long as the Retry-After: header asks
+	if resp != nil && resp.StatusCode == 429 {
+		return true, pacer.RetryAfterError(err, retryAfter(resp))
+	}
 	return fserrors.ShouldRetry(err) || fserrors.ShouldRetryHTTP(resp, retryErrorCodes), err
 }
 
 // readMetaDataForPath reads the metadata from the path
+//
+// Paths in directories listed since the last refresh are found in the
+// index without listing them again.
 func (f *Fs) readMetaDataForPath(ctx context.Context, path string, directoriesOnly bool, filesOnly bool) (info *api.Item, err error) {
 	// defer fs.Trace(f, "path=%q", path)("info=%+v, err=%v", &info, &err)
 	leaf, directoryID, err := f.dirCache.FindPath(ctx, path, false)
@@ -216,9 +662,15 @@ func (f *Fs) readMetaDataForPath(ctx context.Context, path string, directoriesOn
 		return nil, err
 	}
 
-	lcLeaf := strings.ToLower(leaf)
+	leafKey := f.nameKey(leaf)
+	if item, listed := f.index.get(directoryID, leafKey); listed {
+		if item == nil || (directoriesOnly && item.Type != api.ItemTypeFolder) || (filesOnly && item.Type != api.ItemTypeFile) {
+			return nil, fs.ErrorObjectNotFound
+		}
+		return item, nil
+	}
 	_, found, err := f.listAll(ctx, directoryID, directoriesOnly, filesOnly, func(item *api.Item) bool {
-		if strings.ToLower(item.Name) == lcLeaf {
+		if f.nameKey(item.Name) == leafKey {
 			info = item
 			return true
 		}
@@ -239,23 +691,35 @@ func errorHandler(resp *http.Response) error {
 	if err != nil {
 		body = nil
 	}
-	var e = api.Response{
-		Message: string(body),
-		Status:  fmt.Sprintf("%s (%d)", resp.Status, resp.StatusCode),
+	var e = api.Error{
+		StatusCode: resp.StatusCode,
 	}
 	if body != nil {
 		_ = json.Unmarshal(body, &e)
 	}
+	if e.Code == "" {
+		// not an API error, e.g. from a download host
+		e.Details = strings.TrimSpace(string(body))
+		if e.Details == "" {
+			e.Details = resp.Status
+		}
+	}
+	e.Sentinel = errorSentinels[e.ErrorCode]
 	return &e
 }
 
-// Return a url.Values with the api key in
+// errorSentinels are the fs errors API errors are also reported as by
+// error code
+var errorSentinels = map[int]error{
+	api.CodeResourceNotFound: fs.ErrorObjectNotFound,
+	api.CodePermissionDenied: fs.ErrorPermissionDenied,
+}
+
+// Return a url.Values for the parameters of an API call
+//
+// The API key is added by accounts.sign when the call is made.
 func (f *Fs) baseParams() url.Values {
-	params := url.Values{}
-	if f.opt.APIKey != "" {
-		params.Add("auth_token", f.opt.APIKey)
-	}
-	return params
+	return url.Values{}
 }
 
 // NewFs constructs an Fs from the path, container:path
@@ -267,32 +731,161 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 		return nil, err
 	}
 
+	switch opt.ConflictPolicy {
+	case conflictKeepBoth, conflictKeepNewest, conflictKeepLargest, conflictError:
+	default:
+		return nil, fmt.Errorf("unknown conflict_policy %q", opt.ConflictPolicy)
+	}
+	if err := checkStatuses(opt.ShowStatuses); err != nil {
+		return nil, fmt.Errorf("bad show_statuses: %w", err)
+	}
+	switch opt.UnreadyFiles {
+	case unreadyShow, unreadyHide, unreadyPending:
+	default:
+		return nil, fmt.Errorf("unknown unready_files %q", opt.UnreadyFiles)
+	}
+	switch opt.Samples {
+	case samplesShow, samplesHide, samplesQuarantine:
+	default:
+		return nil, fmt.Errorf("unknown samples %q", opt.Samples)
+	}
+	switch opt.OnDuplicate {
+	case duplicateMapExisting, duplicateSkip, duplicateAddAnyway:
+	default:
+		return nil, fmt.Errorf("unknown on_duplicate %q", opt.OnDuplicate)
+	}
+	switch opt.EvictionPolicy {
+	case evictOldestUnwatched, evictLargest:
+	default:
+		return nil, fmt.Errorf("unknown eviction_policy %q", opt.EvictionPolicy)
+	}
+	switch opt.RootLayout {
+	case "", layoutMerged, layoutSplit:
+	default:
+		return nil, fmt.Errorf("unknown root_layout %q", opt.RootLayout)
+	}
+
+	window, err := parseMaintenanceWindow(opt.Maintenance)
+	if err != nil {
+		return nil, err
+	}
+	var rootInclude, rootExclude *regexp.Regexp
+	if opt.RootInclude != "" {
+		rootInclude, err = regexp.Compile(opt.RootInclude)
+		if err != nil {
+			return nil, fmt.Errorf("bad root_include: %w", err)
+		}
+	}
+	if opt.RootExclude != "" {
+		rootExclude, err = regexp.Compile(opt.RootExclude)
+		if err != nil {
+			return nil, fmt.Errorf("bad root_exclude: %w", err)
+		}
+	}
+	ruleFolders, err := parseRuleFolders(opt.RegexFolders)
+	if err != nil {
+		return nil, err
+	}
+	sorter, err := newRegexSorter(ruleFolders, opt.RegexShows, opt.RegexMovies)
+	if err != nil {
+		return nil, err
+	}
+	parser, err := newNameParser(ctx, opt.NameParser)
+	if err != nil {
+		return nil, fmt.Errorf("bad name_parser: %w", err)
+	}
+	var selectExclude *regexp.Regexp
+	if opt.SelectExclude != "" {
+		selectExclude, err = regexp.Compile(opt.SelectExclude)
+		if err != nil {
+			return nil, fmt.Errorf("bad select_exclude: %w", err)
+		}
+	}
+
 	root = parsePath(root)
 
-	var client *http.Client
+	client, err := newProxyClient(ctx, opt.APIProxy)
+	if err != nil {
+		return nil, fmt.Errorf("bad api_proxy: %w", err)
+	}
 	var ts *oauthutil.TokenSource
 	if opt.APIKey == "" {
-		client, ts, err = oauthutil.NewClient(ctx, name, m, oauthConfig)
+		client, ts, err = oauthutil.NewClientWithBaseClient(ctx, name, m, oauthConfig, client)
 		if err != nil {
 			return nil, fmt.Errorf("failed to configure realdebrid: %w", err)
 		}
-	} else {
-		client = fshttp.NewClient(ctx)
 	}
 
 	f := &Fs{
-		name:  name,
-		root:  root,
-		opt:   *opt,
-		srv:   rest.NewClient(client).SetRoot(rootURL),
-		pacer: fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(minSleep), pacer.MaxSleep(maxSleep), pacer.DecayConstant(decayConstant))),
+		name:          name,
+		root:          root,
+		opt:           *opt,
+		m:             m,
+		srv:           rest.NewClient(client).SetRoot(rootURL),
+		pacer:         fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(minSleep), pacer.MaxSleep(maxSleep), pacer.DecayConstant(decayConstant))),
+		conflictsMu:   new(sync.Mutex),
+		conflicts:     make(map[string][]conflict),
+		window:        window,
+		accounts:      newAccounts(opt.APIKey, opt.APIKeys),
+		scan:          newScanGuard(opt.ScanStorm),
+		coord:         getCoordinator(opt.Coordination),
+		rootInclude:   rootInclude,
+		rootExclude:   rootExclude,
+		selectExclude: selectExclude,
+		background:    newBackgroundLimiter(opt.BackgroundLimit),
+		misses:        newMissCache(time.Duration(opt.NegativeCache)),
+		index:         newPathIndex(),
+		lookups:       new(singleflight.Group),
+		slots:         newDownloadSlots(opt.MaxDownloads, time.Duration(opt.SlotTimeout)),
 	}
 	f.features = (&fs.Features{
-		CaseInsensitive:         true,
+		CaseInsensitive:         opt.CaseInsensitive,
 		CanHaveEmptyDirectories: true,
 		ReadMimeType:            true,
 	}).Fill(ctx, f)
-	f.srv.SetErrorHandler(errorHandler)
+	f.setSortRules(ruleFolders, sorter, parser)
+	f.srv.SetSigner(f.accounts.sign)
+	f.srv.SetErrorHandler(func(resp *http.Response) error {
+		err := errorHandler(resp)
+		f.accounts.checkResponse(resp, err)
+		return err
+	})
+	f.dl = f.srv
+	if opt.DownloadProxy != "" {
+		dlClient, err := newProxyClient(ctx, opt.DownloadProxy)
+		if err != nil {
+			return nil, fmt.Errorf("bad download_proxy: %w", err)
+		}
+		f.dl = rest.NewClient(dlClient).SetErrorHandler(errorHandler)
+	}
+
+	if f.opt.DiskCacheDir != "" {
+		f.cache, err = getBlockCache(f.opt.DiskCacheDir, int64(f.opt.DiskCacheSize))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if f.opt.StagingRemote != "" {
+		f.staging, err = cache.Get(ctx, f.opt.StagingRemote)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make staging_remote %q: %w", f.opt.StagingRemote, err)
+		}
+	}
+
+	if f.opt.StateFile != "" {
+		stateLoaded.Do(func() {
+			err = f.loadState()
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load state_file: %w", err)
+		}
+	}
+
+	if f.opt.AsyncStartup && f.opt.RootFolderID == "torrents" {
+		f.warm = make(chan struct{})
+		go f.warmUp(context.Background())
+	}
 
 	// Renew the token in the background
 	if ts != nil {
@@ -362,8 +955,14 @@ func (f *Fs) newObjectWithInfo(ctx context.Context, remote string, info *api.Ite
 
 // NewObject finds the Object at remote.  If it can't be found
 // it returns the error fs.ErrorObjectNotFound.
+//
+// Files not on RealDebrid are looked for in the staging_remote if set.
 func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
-	return f.newObjectWithInfo(ctx, remote, nil)
+	o, err := f.newObjectWithInfo(ctx, remote, nil)
+	if err == fs.ErrorObjectNotFound && f.staging != nil {
+		return f.newStagedObject(ctx, remote)
+	}
+	return o, err
 }
 
 // FindLeaf finds a directory of name leaf in the folder with ID pathID
@@ -371,7 +970,7 @@ func (f *Fs) FindLeaf(ctx context.Context, pathID, leaf string) (pathIDOut strin
 	// Find the leaf in pathID
 	var newDirID string
 	newDirID, found, err = f.listAll(ctx, pathID, true, false, func(item *api.Item) bool {
-		if strings.EqualFold(item.Name, leaf) {
+		if f.nameKey(item.Name) == f.nameKey(leaf) {
 			pathIDOut = item.ID
 			return true
 		}
@@ -389,9 +988,153 @@ func (f *Fs) CreateDir(ctx context.Context, pathID, leaf string) (newID string,
 	return "", nil //return info.ID, nil
 }
 
+// nameKey returns the key names are compared by, which ignores case
+// if case_insensitive is set
+func (f *Fs) nameKey(name string) string {
+	if f.opt.CaseInsensitive {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// hidden returns whether item in the directory dirID is hidden by
+// root_include or root_exclude
+func (f *Fs) hidden(dirID string, item *api.Item) bool {
+	if f.rootInclude == nil && f.rootExclude == nil {
+		return false
+	}
+	dir, ok := f.dirCache.GetInv(dirID)
+	if !ok {
+		return false
+	}
+	// until the root is found the cache is relative to the real root
+	remote := path.Join(dir, item.Name)
+	if f.dirCache.FoundRoot() {
+		remote = path.Join(f.root, remote)
+	}
+	if item.Type == api.ItemTypeFolder {
+		remote += "/"
+	}
+	if f.rootInclude != nil && !f.rootInclude.MatchString(remote) {
+		return true
+	}
+	return f.rootExclude != nil && f.rootExclude.MatchString(remote)
+}
+
+// canRunMaintenance returns whether expensive operations may be run
+// now: inside the maintenance window and, if other instances share the
+// account, by the one holding the lease
+func (f *Fs) canRunMaintenance() bool {
+	now := time.Now()
+	return f.window.contains(now) && f.coord.isLeaderAt(now)
+}
+
+// Backoff between attempts to repair the same torrent, doubling after
+// each failure
+const (
+	minRepairBackoff = 15 * time.Minute
+	maxRepairBackoff = 24 * time.Hour
+)
+
+// brokenTorrent records a torrent waiting to be repaired
+type brokenTorrent struct {
+	Since    time.Time `json:"since"`
+	Failures int       `json:"failures,omitempty"`
+	Next     time.Time `json:"next,omitempty"` // not retried before this
+}
+
+// markBroken remembers that torrentID needs repairing
+//
+// It returns false if the torrent was already known to be broken.
+func markBroken(torrentID string) bool {
+	brokenMu.Lock()
+	defer brokenMu.Unlock()
+	if _, found := brokenTorrents[torrentID]; found {
+		return false
+	}
+	brokenTorrents[torrentID] = brokenTorrent{Since: time.Now()}
+	return true
+}
+
+// isBroken returns whether torrentID is waiting to be repaired
+func isBroken(torrentID string) bool {
+	brokenMu.Lock()
+	defer brokenMu.Unlock()
+	_, found := brokenTorrents[torrentID]
+	return found
+}
+
+// unmarkBroken forgets that torrentID needs repairing
+func unmarkBroken(torrentID string) {
+	brokenMu.Lock()
+	defer brokenMu.Unlock()
+	delete(brokenTorrents, torrentID)
+}
+
+// repairDue returns whether the broken torrentID may be repaired at
+// now, which it may until a repair has failed
+func repairDue(torrentID string, now time.Time) bool {
+	brokenMu.Lock()
+	defer brokenMu.Unlock()
+	return !now.Before(brokenTorrents[torrentID].Next)
+}
+
+// repairFailed records that repairing torrentID failed at now, backing
+// off before the next attempt
+func repairFailed(torrentID string, now time.Time) time.Duration {
+	brokenMu.Lock()
+	defer brokenMu.Unlock()
+	b, found := brokenTorrents[torrentID]
+	if !found {
+		b.Since = now
+	}
+	backoff := minRepairBackoff
+	for i := 0; i < b.Failures && backoff < maxRepairBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRepairBackoff {
+		backoff = maxRepairBackoff
+	}
+	b.Failures++
+	b.Next = now.Add(backoff)
+	brokenTorrents[torrentID] = b
+	return backoff
+}
+
+// brokenIDs returns the IDs of the broken torrents sorted
+func brokenIDs() []string {
+	brokenMu.Lock()
+	defer brokenMu.Unlock()
+	ids := make([]string, 0, len(brokenTorrents))
+	for id := range brokenTorrents {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// indexCached rebuilds cachedLinks from cached
+//
+// Call with listMu held exclusively.
+func indexCached() {
+	cachedLinks = make(map[string]int, len(cached))
+	for i, item := range cached {
+		if _, found := cachedLinks[item.OriginalLink]; !found {
+			cachedLinks[item.OriginalLink] = i
+		}
+	}
+}
+
+// forceRefresh makes the next listing of the root refresh everything
+func forceRefresh() {
+	atomic.StoreInt64(&refreshJitter, 0)
+	atomic.StoreInt64(&lastcheck, time.Now().Unix()-interval-1)
+}
+
 // Redownload a dead torrent
 func (f *Fs) redownloadTorrent(ctx context.Context, torrent api.Item) (redownloaded_torrent api.Item) {
 	fmt.Println("Redownloading dead torrent: " + torrent.Name)
+	original := torrent
 	//Get dead torrent file and hash info
 	var method = "GET"
 	var path = "/torrents/info/" + torrent.ID
@@ -410,34 +1153,8 @@ func (f *Fs) redownloadTorrent(ctx context.Context, torrent api.Item) (redownloa
 	}
 	var selected_files_str = strings.Trim(strings.Join(strings.Fields(fmt.Sprint(selected_files)), ","), "[]")
 	//Delete old download links
-	for _, link := range torrent.Links {
-		for i, cachedfile := range cached {
-			if cachedfile.OriginalLink == link {
-				path = "/downloads/delete/" + cachedfile.ID
-				opts = rest.Opts{
-					Method:     "DELETE",
-					Path:       path,
-					Parameters: f.baseParams(),
-				}
-				var resp *http.Response
-				var result api.Response
-				var retries = 0
-				var err_code = 0
-				resp, _ = f.srv.CallJSON(ctx, &opts, nil, &result)
-				if resp != nil {
-					err_code = resp.StatusCode
-				}
-				for err_code == 429 && retries <= 5 {
-					time.Sleep(time.Duration(2) * time.Second)
-					resp, _ = f.srv.CallJSON(ctx, &opts, nil, &result)
-					if resp != nil {
-						err_code = resp.StatusCode
-					}
-					retries += 1
-				}
-				cached[i].OriginalLink = "this-is-not-a-link"
-			}
-		}
+	for _, id := range dropLinks(torrent.Links) {
+		_ = f.deleteDownload(ctx, id)
 	}
 	//Add torrent again
 	path = "/torrents/addMagnet"
@@ -450,7 +1167,14 @@ func (f *Fs) redownloadTorrent(ctx context.Context, torrent api.Item) (redownloa
 		},
 		Parameters: f.baseParams(),
 	}
-	_, _ = f.srv.CallJSON(ctx, &opts, nil, &torrent)
+	resp, err := f.srv.CallJSON(ctx, &opts, nil, &torrent)
+	if err != nil {
+		backoff := repairFailed(dead_torrent_id, time.Now())
+		fs.Errorf(f, "Failed to re-add torrent %q, trying again in %v: %v", original.Name, backoff, err)
+		return original
+	}
+	torrent.Account = f.accounts.used(resp)
+	f.accounts.setOwner(torrent.ID, torrent.Account)
 	method = "GET"
 	path = "/torrents/info/" + torrent.ID
 	opts = rest.Opts{
@@ -476,25 +1200,486 @@ func (f *Fs) redownloadTorrent(ctx context.Context, torrent api.Item) (redownloa
 		},
 		Parameters: f.baseParams(),
 	}
-	_, _ = f.srv.CallJSON(ctx, &opts, nil, &torrent)
-	//Delete the old torrent
-	path = "/torrents/delete/" + dead_torrent_id
-	method = "DELETE"
+	_, _ = f.srv.CallJSON(ctx, &opts, nil, &torrent)
+	//Delete the old torrent
+	path = "/torrents/delete/" + dead_torrent_id
+	method = "DELETE"
+	opts = rest.Opts{
+		Method:     method,
+		Path:       path,
+		Parameters: f.baseParams(),
+	}
+	_, _ = f.srv.CallJSON(ctx, &opts, nil, &torrent)
+	torrent.Status = "downloaded"
+	forceRefresh()
+	unmarkBroken(dead_torrent_id)
+	f.runHook(eventRepair, &torrent)
+	return torrent
+}
+
+// dropLinks unlinks the downloads of the hoster links given from
+// cached and returns their IDs so they can be deleted
+//
+// Call without listMu held.
+func dropLinks(links []string) (ids []string) {
+	drop := make(map[string]bool, len(links))
+	for _, link := range links {
+		drop[link] = true
+	}
+	listMu.Lock()
+	defer listMu.Unlock()
+	newcached := append([]api.Item(nil), cached...)
+	for i := range newcached {
+		if drop[newcached[i].OriginalLink] {
+			ids = append(ids, newcached[i].ID)
+			newcached[i].OriginalLink = "this-is-not-a-link"
+		}
+	}
+	cached = newcached
+	indexCached()
+	return ids
+}
+
+// unrestrict gets a fresh direct download link for link
+func (f *Fs) unrestrict(ctx context.Context, link string) (item *api.Item, err error) {
+	opts := rest.Opts{
+		Method: "POST",
+		Path:   "/unrestrict/link",
+		MultipartParams: url.Values{
+			"link": {link},
+		},
+		Parameters: f.baseParams(),
+	}
+	var resp *http.Response
+	err = f.pacer.Call(func() (bool, error) {
+		item = new(api.Item)
+		resp, err = f.srv.CallJSON(ctx, &opts, nil, item)
+		return shouldRetry(ctx, resp, err)
+	})
+	if isInfringing(err) {
+		markTakenDown(takedown{Link: link})
+		return nil, takenDownError(link)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't unrestrict link: %w", err)
+	}
+	return item, nil
+}
+
+// takeUnrestrict reserves one unrestrict call from the budget set by
+// max_unrestricts_per_cycle, returning false if it is used up.
+//
+// Calls which aren't priority may only use half of the budget so that
+// directories being opened can still be listed after a large scan.
+func (f *Fs) takeUnrestrict(priority bool) bool {
+	limit := int64(f.opt.MaxUnrestricts)
+	if limit <= 0 {
+		return true
+	}
+	if !priority {
+		limit = (limit + 1) / 2
+	}
+	if atomic.AddInt64(&unrestricts, 1) > limit {
+		atomic.AddInt64(&unrestricts, -1)
+		return false
+	}
+	return true
+}
+
+// flattened returns whether torrent is shown in a category folder as
+// its file rather than as a folder, which flatten_single does for
+// torrents with a single file
+func (f *Fs) flattened(torrent *api.Item) bool {
+	return f.opt.FlattenSingle && len(torrent.Links) == 1
+}
+
+// flatFiles returns the files the flattened torrents in flat show in
+// their category folder
+//
+// Call without listMu held.
+func (f *Fs) flatFiles(ctx context.Context, flat []api.Item) (result []api.Item) {
+	for _, torrent := range flat {
+		for _, item := range f.torrentFiles(ctx, torrent, false) {
+			item.Type = api.ItemTypeFile
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Match the links of torrent to their unrestricted direct links
+//
+// Links which haven't been unrestricted yet are unrestricted here. If
+// a link turns out to be broken the torrent is marked for repair,
+// unless it was taken down as infringing which is permanent so the file
+// is left out instead. Files which can't be unrestricted within the
+// max_unrestricts_per_cycle budget or during a scan storm are left out,
+// priority is passed to takeUnrestrict. Files without a usable link are
+// left out too unless unready_files is "show".
+//
+// torrent is a copy taken from torrents. Call without listMu held as
+// unrestricting the links calls RealDebrid.
+func (f *Fs) torrentFiles(ctx context.Context, torrent api.Item, priority bool) (result []api.Item) {
+	var broken = false
+	var skipped = 0
+	// Work out which links need unrestricting first so they can be
+	// done together
+	items := make([]api.Item, len(torrent.Links))
+	skip := make([]bool, len(torrent.Links))
+	var batch []int
+	listMu.RLock()
+	for index, link := range torrent.Links {
+		if j, ok := cachedLinks[link]; ok {
+			items[index] = cached[j]
+		}
+	}
+	listMu.RUnlock()
+	for index, link := range torrent.Links {
+		if items[index].Link == "" {
+			if isTakenDown(link) {
+				skip[index] = true
+				continue
+			}
+			if f.scan.storming() || !f.takeUnrestrict(priority) {
+				skipped++
+				skip[index] = true
+				continue
+			}
+			batch = append(batch, index)
+		}
+	}
+	codes, errs := f.unrestrictBatch(ctx, torrent.Links, batch, items)
+	ids := f.torrentFileIDs(ctx, &torrent)
+	for index, link := range torrent.Links {
+		if skip[index] {
+			continue
+		}
+		if isInfringing(errs[index]) {
+			f.noteTakedown(&torrent, link, index)
+			continue
+		}
+		if codes[index] == 503 {
+			broken = true
+			break
+		}
+		ItemFile := items[index]
+		ItemFile.ParentID = torrent.ID
+		ItemFile.TorrentHash = torrent.TorrentHash
+		if ids != nil {
+			ItemFile.FileID = ids[index]
+		}
+		ItemFile.LinkGenerated = linkGenerated(&ItemFile, time.Now())
+		ItemFile.Generated = torrent.Generated
+		if f.opt.UnreadyFiles != unreadyShow {
+			if isUnready(&ItemFile) {
+				setPending(link, index, &torrent, ItemFile)
+				continue
+			}
+			clearPending(link)
+		}
+		result = append(result, ItemFile)
+	}
+	if skipped > 0 {
+		fs.Debugf(f, "Torrent %q: left out %d files which can't be unrestricted yet", torrent.Name, skipped)
+	}
+	if broken {
+		// The repair needs exclusive access to the torrents so is
+		// left to the next refresh of the root.
+		if markBroken(torrent.ID) {
+			fs.Logf(f, "Torrent %q has a broken link and will be re-downloaded on next refresh", torrent.Name)
+		}
+		forceRefresh()
+	}
+	if f.opt.Samples != samplesShow {
+		result = f.filterSamples(result)
+	}
+	return result
+}
+
+// refreshLibrary fetches the /downloads and /torrents lists if they
+// have changed or are due a refresh, then repairs dead torrents and
+// evicts torrents over max_torrents.
+//
+// It returns whether everything was refreshed so the state should be
+// saved.
+//
+// Call without listMu held.
+func (f *Fs) refreshLibrary(ctx context.Context) (saved bool, err error) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	// work on copies as the lists can still be changed in place by
+	// others holding listMu
+	listMu.RLock()
+	oldcached := append([]api.Item(nil), cached...)
+	oldtorrents := append([]api.Item(nil), torrents...)
+	listMu.RUnlock()
+	path := "/downloads"
+	method := "GET"
+	var partialresult []api.Item
+	var resp *http.Response
+	//update global cached list
+	opts := rest.Opts{
+		Method:     method,
+		Path:       path,
+		Parameters: f.baseParams(),
+	}
+	opts.Parameters.Set("includebreadcrumbs", "false")
+	opts.Parameters.Set("limit", "1")
+	var newcached []api.Item
+	var totalcount int
+	var printed = false
+	if !offlineRetryDue(time.Now()) {
+		return false, nil
+	}
+	refreshDue := refreshIsDue() && f.canRunMaintenance()
+	sweepDue := f.sweepIsDue(time.Now())
+	totalcount = 2
+	for len(newcached) < totalcount {
+		err = f.pacer.Call(func() (bool, error) {
+			partialresult = nil
+			resp, err = f.srv.CallJSON(ctx, &opts, nil, &partialresult)
+			return shouldRetry(ctx, resp, err)
+		})
+		if err == nil {
+			account := f.accounts.tag(partialresult, resp)
+			if len(newcached) > 0 && newcached[0].Account != account {
+				fs.Debugf(f, "Account changed while listing the downloads, starting again")
+				newcached = nil
+				opts.Parameters.Del("offset")
+				totalcount = 2
+				continue
+			}
+			totalcount, err = strconv.Atoi(resp.Header["X-Total-Count"][0])
+			if err == nil {
+				if totalcount != len(oldcached) || refreshDue || (len(oldcached) > 0 && oldcached[0].Account != account) {
+					if refreshDue && !printed {
+						fmt.Println("Last update more than 15min ago. Updating links and torrents.")
+						printed = true
+					}
+					newcached = append(newcached, partialresult...)
+					opts.Parameters.Set("offset", strconv.Itoa(len(newcached)))
+					opts.Parameters.Set("limit", "2500")
+				} else {
+					newcached = oldcached
+				}
+			} else {
+				break
+			}
+		} else {
+			break
+		}
+	}
+	if err != nil {
+		return f.refreshFailed(err)
+	}
+	//fmt.Printf("Done.\n")
+	//fmt.Printf("Updating RealDebrid Torrents ... ")
+	//get torrents
+	path = "/torrents"
 	opts = rest.Opts{
 		Method:     method,
 		Path:       path,
 		Parameters: f.baseParams(),
 	}
-	_, _ = f.srv.CallJSON(ctx, &opts, nil, &torrent)
-	torrent.Status = "downloaded"
-	lastcheck = time.Now().Unix() - interval
-	for i, TorrentID := range broken_torrents {
-		if dead_torrent_id == TorrentID {
-			broken_torrents[i] = broken_torrents[len(broken_torrents)-1]
-			broken_torrents = broken_torrents[:len(broken_torrents)-1]
+	opts.Parameters.Set("limit", "1")
+	var newtorrents []api.Item
+	var swept = false
+	totalcount = 2
+	for len(newtorrents) < totalcount {
+		err = f.pacer.Call(func() (bool, error) {
+			partialresult = nil
+			resp, err = f.srv.CallJSON(ctx, &opts, nil, &partialresult)
+			return shouldRetry(ctx, resp, err)
+		})
+		if err == nil {
+			account := f.accounts.tag(partialresult, resp)
+			if len(newtorrents) > 0 && newtorrents[0].Account != account {
+				fs.Debugf(f, "Account changed while listing the torrents, starting again")
+				newtorrents = nil
+				opts.Parameters.Del("offset")
+				totalcount = 2
+				continue
+			}
+			totalcount, err = strconv.Atoi(resp.Header["X-Total-Count"][0])
+			if err == nil {
+				if totalcount != len(oldtorrents) || refreshDue || sweepDue || (len(oldtorrents) > 0 && oldtorrents[0].Account != account) {
+					swept = true
+					newtorrents = append(newtorrents, partialresult...)
+					opts.Parameters.Set("offset", strconv.Itoa(len(newtorrents)))
+					opts.Parameters.Set("limit", "2500")
+				} else {
+					newtorrents = oldtorrents
+				}
+			} else {
+				break
+			}
+		} else {
+			break
 		}
 	}
-	return torrent
+	if err != nil {
+		// keep the links and torrents from the last refresh together
+		return f.refreshFailed(err)
+	}
+	// only swap in the new links now the torrents they go with are in
+	f.swapLibrary(ctx, newcached, newtorrents, swept)
+	//fmt.Printf("Done.\n")
+	return true, nil
+}
+
+// swapLibrary swaps in the links and torrents read by refreshLibrary
+// or a crawl, noting what changed, then repairs dead torrents and
+// evicts torrents over max_torrents. swept is whether the whole of
+// /torrents was read.
+//
+// Call with refreshMu held and without listMu held.
+func (f *Fs) swapLibrary(ctx context.Context, newcached, newtorrents []api.Item, swept bool) {
+	listMu.Lock()
+	cached = newcached
+	indexCached()
+	f.goOnline()
+	atomic.StoreInt64(&lastcheck, time.Now().Unix())
+	f.scheduleRefresh()
+	atomic.StoreInt64(&unrestricts, 0)
+	if swept {
+		markSwept(time.Now())
+	}
+	f.torrentChanges(torrents, newtorrents)
+	f.flagStatusChanges(torrents, newtorrents)
+	f.findOrphans(torrents, newtorrents)
+	applyNames(newtorrents)
+	torrents = newtorrents
+	f.accounts.own(cached, torrents)
+	listMu.Unlock()
+	prunePending(newtorrents, time.Now())
+	pruneFileIDs(newtorrents)
+	f.fetchTorrentInfos(ctx, newtorrents)
+	f.repairTorrents(ctx, newtorrents)
+	if f.canRunMaintenance() {
+		f.evictTorrents(ctx)
+	}
+}
+
+// repairTorrents repairs the dead torrents of list, the torrents just
+// swapped in, as many as the repair budget allows, and swaps in a copy
+// of the torrents with the repaired ones replaced
+//
+// Call without listMu held.
+func (f *Fs) repairTorrents(ctx context.Context, list []api.Item) {
+	repaired := map[string]api.Item{}
+	budget := f.repairBudget()
+	for _, torrent := range list {
+		if isQuarantined(torrent.Status) {
+			// re-adding it would only be flagged again
+			continue
+		}
+		if torrent.Status == "dead" {
+			markBroken(torrent.ID)
+		}
+		if isBroken(torrent.ID) && f.canRunMaintenance() && !f.opt.ReadOnly {
+			if !repairDue(torrent.ID, time.Now()) {
+				continue
+			}
+			if budget == 0 {
+				fs.Debugf(f, "Leaving the other repairs for the next refresh")
+				break
+			}
+			if f.torrentLocked(torrent.Name) {
+				fs.Debugf(f, "Not repairing %q as its folder is locked", torrent.Name)
+				continue
+			}
+			if operations.SkipDestructive(ctx, torrent.Name, "repair torrent") {
+				continue
+			}
+			repaired[torrent.ID] = f.redownloadTorrent(ctx, torrent)
+			budget--
+		}
+	}
+	if len(repaired) == 0 {
+		return
+	}
+	listMu.Lock()
+	newtorrents := append([]api.Item(nil), torrents...)
+	for i, torrent := range newtorrents {
+		if r, ok := repaired[torrent.ID]; ok {
+			newtorrents[i] = r
+		}
+	}
+	torrents = newtorrents
+	listMu.Unlock()
+}
+
+// rootItems returns the folders in the root of the library in
+// "folders" folder_mode
+//
+// Call with listMu held.
+func (f *Fs) rootItems() (result []api.Item) {
+	var ShowsFolder api.Item
+	var MoviesFolder api.Item
+	var DefaultFolder api.Item
+	ShowsFolder.ID = "shows"
+	ShowsFolder.Name = "shows"
+	MoviesFolder.ID = "movies"
+	MoviesFolder.Name = "movies"
+	DefaultFolder.ID = "default"
+	DefaultFolder.Name = "default"
+	result = append(result, ShowsFolder)
+	result = append(result, MoviesFolder)
+	result = append(result, DefaultFolder)
+	if f.opt.Samples == samplesQuarantine {
+		var SamplesFolder api.Item
+		SamplesFolder.ID = samplesDirID
+		SamplesFolder.Name = samplesDirID
+		result = append(result, SamplesFolder)
+	}
+	if f.opt.UnreadyFiles == unreadyPending {
+		var PendingFolder api.Item
+		PendingFolder.ID = pendingDirID
+		PendingFolder.Name = pendingDirID
+		result = append(result, PendingFolder)
+	}
+	legacyMu.Lock()
+	if len(legacy) > 0 && f.opt.RootLayout == "" {
+		var LegacyFolder api.Item
+		LegacyFolder.ID = legacyDirID
+		LegacyFolder.Name = legacyDirID
+		result = append(result, LegacyFolder)
+	}
+	legacyMu.Unlock()
+	if f.opt.RecentFiles > 0 {
+		var RecentFolder api.Item
+		RecentFolder.ID = recentDirID
+		RecentFolder.Name = recentDirID
+		result = append(result, RecentFolder)
+	}
+	if f.opt.ShowUnselected {
+		var UnselectedFolder api.Item
+		UnselectedFolder.ID = unselectedDirID
+		UnselectedFolder.Name = unselectedDirID
+		result = append(result, UnselectedFolder)
+	}
+	var ByHashFolder api.Item
+	ByHashFolder.ID = byHashDirID
+	ByHashFolder.Name = byHashDirID
+	result = append(result, ByHashFolder)
+	if hasQuarantined() {
+		var QuarantineFolder api.Item
+		QuarantineFolder.ID = quarantineDirID
+		QuarantineFolder.Name = quarantineDirID
+		result = append(result, QuarantineFolder)
+	}
+	if len(orphans) > 0 {
+		var OrphanedFolder api.Item
+		OrphanedFolder.ID = orphanedDirID
+		OrphanedFolder.Name = orphanedDirID
+		result = append(result, OrphanedFolder)
+	}
+	result = append(result, f.subFolders("")...)
+	for i := range result {
+		item := &result[i]
+		item.Generated = "2006-01-02T15:04:05.000Z"
+	}
+	return result
 }
 
 // list the objects into the function supplied
@@ -516,269 +1701,93 @@ func (f *Fs) listAll(ctx context.Context, dirID string, directoriesOnly bool, fi
 	var partialresult []api.Item
 	var result []api.Item
 	var resp *http.Response
+	var saveState = false
+	f.scan.listed(time.Now())
 	if f.opt.RootFolderID == "torrents" {
-		if dirID == rootID {
-			//update global cached list
-			opts := rest.Opts{
-				Method:     method,
-				Path:       path,
-				Parameters: f.baseParams(),
-			}
-			opts.Parameters.Set("includebreadcrumbs", "false")
-			opts.Parameters.Set("limit", "1")
-			var newcached []api.Item
-			var totalcount int
-			var printed = false
-			totalcount = 2
-			for len(newcached) < totalcount {
-				partialresult = nil
-				var err_code = 0
-				resp, err = f.srv.CallJSON(ctx, &opts, nil, &partialresult)
-				if resp != nil {
-					err_code = resp.StatusCode
-				}
-				var retries = 0
-				for err_code == 429 && retries <= 5 {
-					partialresult = nil
-					time.Sleep(time.Duration(2) * time.Second)
-					resp, err = f.srv.CallJSON(ctx, &opts, nil, &partialresult)
-					if resp != nil {
-						err_code = resp.StatusCode
-					}
-					retries += 1
-				}
-				if err == nil {
-					totalcount, err = strconv.Atoi(resp.Header["X-Total-Count"][0])
-					if err == nil {
-						if totalcount != len(cached) || time.Now().Unix()-lastcheck > interval {
-							if time.Now().Unix()-lastcheck > interval && !printed {
-								fmt.Println("Last update more than 15min ago. Updating links and torrents.")
-								printed = true
-							}
-							newcached = append(newcached, partialresult...)
-							opts.Parameters.Set("offset", strconv.Itoa(len(newcached)))
-							opts.Parameters.Set("limit", "2500")
-						} else {
-							newcached = cached
-						}
-					} else {
-						break
-					}
-				} else {
-					break
-				}
-			}
-			//fmt.Printf("Done.\n")
-			//fmt.Printf("Updating RealDebrid Torrents ... ")
-			cached = newcached
-			//get torrents
-			path = "/torrents"
-			opts = rest.Opts{
-				Method:     method,
-				Path:       path,
-				Parameters: f.baseParams(),
+		libraryRoot := dirID == rootID || dirID == splitTorrentsDirID
+		if f.opt.ScopedRefresh && !libraryRoot {
+			f.scopedRefresh(ctx, dirID)
+		}
+		if libraryRoot {
+			if f.scan.storming() {
+				fs.Debugf(f, "Serving the root from cache during a scan storm")
+			} else if f.warming() {
+				fs.Debugf(f, "Serving the root from cache until the library has been read")
+			} else if f.opt.ScopedRefresh && refreshIsDue() && f.canRunMaintenance() {
+				f.crawlInBackground()
+			} else {
+				saveState, err = f.refreshLibrary(ctx)
 			}
-			opts.Parameters.Set("limit", "1")
-			var newtorrents []api.Item
-			totalcount = 2
-			for len(newtorrents) < totalcount {
-				partialresult = nil
-				var err_code = 0
-				resp, err = f.srv.CallJSON(ctx, &opts, nil, &partialresult)
-				if resp != nil {
-					err_code = resp.StatusCode
-				}
-				var retries = 0
-				for err_code == 429 && retries <= 5 {
-					partialresult = nil
-					time.Sleep(time.Duration(2) * time.Second)
-					resp, err = f.srv.CallJSON(ctx, &opts, nil, &partialresult)
-					if resp != nil {
-						err_code = resp.StatusCode
-					}
-					retries += 1
-				}
-				if err == nil {
-					totalcount, err = strconv.Atoi(resp.Header["X-Total-Count"][0])
-					if err == nil {
-						if totalcount != len(torrents) || time.Now().Unix()-lastcheck > interval {
-							newtorrents = append(newtorrents, partialresult...)
-							opts.Parameters.Set("offset", strconv.Itoa(len(newtorrents)))
-							opts.Parameters.Set("limit", "2500")
-						} else {
-							newtorrents = torrents
-						}
-					} else {
-						break
-					}
+		}
+		// the files of these torrents are listed once listMu is
+		// released as unrestricting their links calls RealDebrid
+		var opened, flat, recent []api.Item
+		listMu.RLock()
+		if libraryRoot {
+			if f.opt.SharedFolder == "folders" {
+				if dirID == rootID && f.opt.RootLayout == layoutSplit {
+					result = splitItems()
 				} else {
-					break
-				}
-			}
-			lastcheck = time.Now().Unix()
-			//fmt.Printf("Done.\n")
-			torrents = newtorrents
-			//Handle dead torrents
-			var broken = false
-			for i, torrent := range torrents {
-				broken = false
-				for _, TorrentID := range broken_torrents {
-					if torrent.ID == TorrentID {
-						broken = true
-					}
-				}
-				if torrent.Status == "dead" || broken {
-					torrents[i] = f.redownloadTorrent(ctx, torrent)
+					result = f.rootItems()
 				}
 			}
-			if f.opt.SharedFolder == "folders" {
-				var ShowsFolder api.Item
-				var MoviesFolder api.Item
-				var DefaultFolder api.Item
-				ShowsFolder.ID = "shows"
-				ShowsFolder.Name = "shows"
-				MoviesFolder.ID = "movies"
-				MoviesFolder.Name = "movies"
-				DefaultFolder.ID = "default"
-				DefaultFolder.Name = "default"
-				result = append(result, ShowsFolder)
-				result = append(result, MoviesFolder)
-				result = append(result, DefaultFolder)
-				for i := range result {
-					item := &result[i]
-					item.Generated = "2006-01-02T15:04:05.000Z"
-				}
+		} else if f.opt.SharedFolder == "folders" && dirID == splitDownloadsDirID {
+			result = hosterDownloads()
+		} else if f.opt.SharedFolder == "folders" && dirID == pendingDirID {
+			result = pendingItems()
+		} else if f.opt.SharedFolder == "folders" && dirID == samplesDirID {
+			result = sampleItems()
+		} else if f.opt.SharedFolder == "folders" && dirID == legacyDirID {
+			result = f.legacyItems()
+		} else if f.opt.SharedFolder == "folders" && dirID == recentDirID {
+			recent = f.recentTorrents()
+		} else if f.opt.SharedFolder == "folders" && dirID == unselectedDirID {
+			result = f.unselectedItems()
+		} else if f.opt.SharedFolder == "folders" && strings.HasPrefix(dirID, unselectedPrefix) {
+			result = f.unselectedFiles(strings.TrimPrefix(dirID, unselectedPrefix))
+		} else if f.opt.SharedFolder == "folders" && dirID == byHashDirID {
+			result = f.byHashItems()
+		} else if f.opt.SharedFolder == "folders" && dirID == quarantineDirID {
+			result = quarantineItems()
+		} else if f.opt.SharedFolder == "folders" && strings.HasPrefix(dirID, quarantinePrefix) {
+			if i := torrentIndex(strings.TrimPrefix(dirID, quarantinePrefix)); i >= 0 {
+				opened = append(opened, torrents[i])
 			}
-		} else if f.opt.SharedFolder == "folders" && (dirID == "shows" || dirID == "movies" || dirID == "default") {
-			var artificialType []api.Item
-			if dirID == "shows" {
-				r, _ := regexp.Compile(f.opt.RegexShows) //(?i)(S[0-9]{2}|SEASON|COMPLETE)
-				for _, torrent := range torrents {
-					match := r.MatchString(torrent.Name)
-					if match {
-						artificialType = append(artificialType, torrent)
-					}
-				}
-				result = artificialType
-			} else if dirID == "movies" {
-				r, _ := regexp.Compile(f.opt.RegexMovies) //`(?i)([0-9]{4} ?\.?)`
-				nr, _ := regexp.Compile(f.opt.RegexShows)
-				for _, torrent := range torrents {
-					match := r.MatchString(torrent.Name)
-					exclude := nr.MatchString(torrent.Name)
-					if match && !exclude {
-						artificialType = append(artificialType, torrent)
-					}
-				}
-				result = artificialType
-			} else {
-				r, _ := regexp.Compile(f.opt.RegexMovies)
-				nr, _ := regexp.Compile(f.opt.RegexShows)
-				for _, torrent := range torrents {
-					match := r.MatchString(torrent.Name)
-					exclude := nr.MatchString(torrent.Name)
-					if !match && !exclude {
-						artificialType = append(artificialType, torrent)
-					}
-				}
-				result = artificialType
+		} else if f.opt.SharedFolder == "folders" && dirID == orphanedDirID {
+			result = orphanItems()
+		} else if f.opt.SharedFolder == "folders" && strings.HasPrefix(dirID, orphanedPrefix) {
+			// orphans have no files
+		} else if f.opt.SharedFolder == "folders" && strings.HasPrefix(dirID, byHashPrefix) {
+			if i := torrentIndex(strings.TrimPrefix(dirID, byHashPrefix)); i >= 0 {
+				opened = append(opened, torrents[i])
 			}
-
+		} else if dir, ok := isFolderID(dirID); ok && f.opt.SharedFolder == "folders" {
+			result, flat = f.folderItems(dir)
 		} else if f.opt.SharedFolder != "folders" || dirID != rootID {
 			//fmt.Printf("Matching Torrents to Direct Links ... ")
-			for i, torrent := range torrents {
-				var broken = false
+			for _, torrent := range torrents {
 				if f.opt.SharedFolder == "folders" {
 					if dirID != torrent.ID {
 						continue
 					}
+				} else if !f.statusShown(torrent.Status) {
+					continue
 				}
-				for _, link := range torrent.Links {
-					var ItemFile api.Item
-					for _, cachedfile := range cached {
-						if cachedfile.OriginalLink == link {
-							ItemFile = cachedfile
-							break
-						}
-					}
-					if ItemFile.Link == "" {
-						//fmt.Printf("Creating new unrestricted direct link for: '%s'\n", torrent.Name)
-						path = "/unrestrict/link"
-						method = "POST"
-						opts := rest.Opts{
-							Method: method,
-							Path:   path,
-							MultipartParams: url.Values{
-								"link": {link},
-							},
-							Parameters: f.baseParams(),
-						}
-						var err_code = 0
-						resp, _ = f.srv.CallJSON(ctx, &opts, nil, &ItemFile)
-						if resp != nil {
-							err_code = resp.StatusCode
-						}
-						if err_code == 503 {
-							broken = true
-							break
-						}
-						var retries = 0
-						for err_code == 429 && retries <= 5 {
-							time.Sleep(time.Duration(2) * time.Second)
-							resp, _ = f.srv.CallJSON(ctx, &opts, nil, &ItemFile)
-							if resp != nil {
-								err_code = resp.StatusCode
-							}
-							retries += 1
-						}
-					}
-					ItemFile.ParentID = torrent.ID
-					ItemFile.TorrentHash = torrent.TorrentHash
-					ItemFile.Generated = torrent.Generated
-					result = append(result, ItemFile)
-				}
-				if broken {
-					torrents[i] = f.redownloadTorrent(ctx, torrent)
-					torrent = torrents[i]
-					for _, link := range torrent.Links {
-						var ItemFile api.Item
-						//fmt.Printf("Creating new unrestricted direct link for: '%s'\n", torrent.Name)
-						path = "/unrestrict/link"
-						method = "POST"
-						opts := rest.Opts{
-							Method: method,
-							Path:   path,
-							MultipartParams: url.Values{
-								"link": {link},
-							},
-							Parameters: f.baseParams(),
-						}
-						var err_code = 0
-						resp, _ = f.srv.CallJSON(ctx, &opts, nil, &ItemFile)
-						if resp != nil {
-							err_code = resp.StatusCode
-						}
-						var retries = 0
-						for err_code == 429 && retries <= 5 {
-							time.Sleep(time.Duration(2) * time.Second)
-							resp, _ = f.srv.CallJSON(ctx, &opts, nil, &ItemFile)
-							if resp != nil {
-								err_code = resp.StatusCode
-							}
-							retries += 1
-						}
-						ItemFile.ParentID = torrent.ID
-						ItemFile.TorrentHash = torrent.TorrentHash
-						ItemFile.Generated = torrent.Generated
-						result = append(result, ItemFile)
-					}
-				}
+				opened = append(opened, torrent)
 				if f.opt.SharedFolder == "folders" {
 					break
 				}
 			}
 			//fmt.Printf("Done.\n")
 		}
+		listMu.RUnlock()
+		for _, torrent := range opened {
+			result = append(result, f.torrentFiles(ctx, torrent, f.opt.SharedFolder == "folders")...)
+		}
+		result = append(result, f.flatFiles(ctx, flat)...)
+		if recent != nil {
+			result = f.recentItems(ctx, recent)
+		}
 	} else {
 		opts := rest.Opts{
 			Method:     method,
@@ -791,6 +1800,14 @@ func (f *Fs) listAll(ctx context.Context, dirID string, directoriesOnly bool, fi
 			for len(result) < totalcount {
 				resp, err = f.srv.CallJSON(ctx, &opts, nil, &partialresult)
 				if err == nil {
+					account := f.accounts.tag(partialresult, resp)
+					if len(result) > 0 && result[0].Account != account {
+						// the account changed part way so start again
+						result = nil
+						opts.Parameters.Del("offset")
+						totalcount = 1
+						continue
+					}
 					totalcount, err = strconv.Atoi(resp.Header["X-Total-Count"][0])
 					if err == nil {
 						result = append(result, partialresult...)
@@ -818,11 +1835,55 @@ func (f *Fs) listAll(ctx context.Context, dirID string, directoriesOnly bool, fi
 			t, _ := time.Parse(layout, item.Ended)
 			item.CreatedAt = t.Unix()
 		}
-		if f.opt.SharedFolder == "folders" && (dirID == rootID || dirID == "shows" || dirID == "movies" || dirID == "default") {
+		if item.Type != "" {
+			// type was already decided when the item was built
+		} else if f.opt.SharedFolder == "folders" && (dirID == rootID || dirID == splitTorrentsDirID || dirID == "shows" || dirID == "movies" || dirID == "default") {
 			item.Type = "folder"
 		} else {
 			item.Type = "file"
 		}
+		item.Name = f.standardName(item.Name, item.ID)
+	}
+	result, err = f.resolveConflicts(dirID, result)
+	if err != nil {
+		return newDirID, found, err
+	}
+	var pinned bool
+	for i := range result {
+		item := &result[i]
+		key := modTimeKey(item.TorrentHash, item.FileID, item.OriginalLink)
+		if item.Type == api.ItemTypeFile && key != "" {
+			legacy := []string{item.OriginalLink}
+			if item.TorrentHash != "" {
+				legacy = append(legacy, item.TorrentHash+"/"+item.Name)
+			}
+			var added bool
+			item.CreatedAt, added = pinModTime(key, item.CreatedAt, legacy...)
+			pinned = pinned || added
+		}
+		if f.opt.MtimeFromName {
+			if t, ok := nameDate(item.Name); ok {
+				item.CreatedAt = t.Unix()
+			}
+		}
+	}
+	if saveState {
+		f.saveState()
+	} else if pinned {
+		f.saveStateSoon()
+	}
+	if f.opt.RootFolderID == "torrents" {
+		indexed := make(map[string]api.Item, len(result))
+		for i := range result {
+			item := &result[i]
+			if (item.Type == api.ItemTypeFolder || item.Type == api.ItemTypeFile) && !f.hidden(dirID, item) {
+				indexed[f.nameKey(item.Name)] = *item
+			}
+		}
+		f.index.put(dirID, indexed)
+	}
+	for i := range result {
+		item := &result[i]
 		if item.Type == api.ItemTypeFolder {
 			if filesOnly {
 				continue
@@ -835,7 +1896,9 @@ func (f *Fs) listAll(ctx context.Context, dirID string, directoriesOnly bool, fi
 			fs.Debugf(f, "Ignoring %q - unknown type %q", item.Name, item.Type)
 			continue
 		}
-		item.Name = f.opt.Enc.ToStandardName(item.Name)
+		if f.hidden(dirID, item) {
+			continue
+		}
 		if fn(item) {
 			found = true
 			break
@@ -856,6 +1919,14 @@ func (f *Fs) listAll(ctx context.Context, dirID string, directoriesOnly bool, fi
 func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
 	//fmt.Println("Listing Items ... ")
 	directoryID, err := f.dirCache.FindDir(ctx, dir, false)
+	if err == fs.ErrorDirNotFound && f.staging != nil {
+		// the directory may only exist in the staging_remote
+		entries, err = f.listStaged(ctx, dir)
+		if err == nil && entries == nil {
+			err = fs.ErrorDirNotFound
+		}
+		return entries, err
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -866,6 +1937,9 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 			// cache the directory ID for later lookups
 			f.dirCache.Put(remote, info.ID)
 			d := fs.NewDir(remote, time.Unix(info.CreatedAt, 0)).SetID(info.ID)
+			if files, size, ok := f.dirTotals(info); ok {
+				d.SetItems(files).SetSize(size)
+			}
 			entries = append(entries, d)
 		} else if info.Type == api.ItemTypeFile {
 			o, err := f.newObjectWithInfo(ctx, remote, info)
@@ -883,6 +1957,9 @@ func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err e
 	if iErr != nil {
 		return nil, iErr
 	}
+	if f.staging != nil {
+		return f.mergeStaged(ctx, dir, entries)
+	}
 	//fmt.Println("Done Listing Items.")
 	return entries, nil
 }
@@ -913,7 +1990,7 @@ func (f *Fs) createObject(ctx context.Context, remote string, modTime time.Time,
 //
 // The new object may have been created if an error is returned
 func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
-	existingObj, err := f.newObjectWithInfo(ctx, src.Remote(), nil)
+	existingObj, err := f.NewObject(ctx, src.Remote())
 	switch err {
 	case nil:
 		return existingObj, existingObj.Update(ctx, in, src, options...)
@@ -937,6 +2014,19 @@ func (f *Fs) PutUnchecked(ctx context.Context, in io.Reader, src fs.ObjectInfo,
 	size := src.Size()
 	modTime := src.ModTime(ctx)
 
+	if err := checkOnline(); err != nil {
+		return nil, err
+	}
+	if err := f.checkWritable(); err != nil {
+		return nil, err
+	}
+	if isMagnet(remote) {
+		return f.putMagnet(ctx, in, src)
+	}
+	if f.staging != nil {
+		return f.putStaged(ctx, in, src, options...)
+	}
+
 	o, _, _, err := f.createObject(ctx, remote, modTime, size)
 	if err != nil {
 		return nil, err
@@ -954,6 +2044,12 @@ func (f *Fs) Mkdir(ctx context.Context, dir string) error {
 // refuses to do so if it has anything in
 func (f *Fs) purgeCheck(ctx context.Context, dir string, check bool) error {
 	//fmt.Printf("Purging torrent: '%s'\n", rootID)
+	if err := checkOnline(); err != nil {
+		return err
+	}
+	if err := f.checkWritable(); err != nil {
+		return err
+	}
 	root := path.Join(f.root, dir)
 	if root == "" {
 		return errors.New("can't purge root directory")
@@ -963,19 +2059,27 @@ func (f *Fs) purgeCheck(ctx context.Context, dir string, check bool) error {
 	if err != nil {
 		return err
 	}
-	path := "/torrents/delete/" + rootID
-	opts := rest.Opts{
-		Method:     "DELETE",
-		Path:       path,
-		Parameters: f.baseParams(),
+	if f.dirLocked(rootID) {
+		return errFolderLocked
+	}
+	// only the folders of torrents can be deleted, not the folders
+	// made up from them such as the categories
+	id := strings.TrimPrefix(rootID, byHashPrefix)
+	listMu.RLock()
+	isTorrent := torrentIndex(id) >= 0
+	listMu.RUnlock()
+	if !isTorrent {
+		return fmt.Errorf("can't delete %q as it isn't the folder of a torrent", root)
+	}
+	if operations.SkipDestructive(ctx, root, "delete torrent") {
+		return nil
+	}
+	err = f.deleteTorrent(ctx, id)
+	if err != nil {
+		return err
 	}
-	var resp *http.Response
-	var result api.Response
-	err = f.pacer.Call(func() (bool, error) {
-		resp, err = f.srv.CallJSON(ctx, &opts, nil, &result)
-		return shouldRetry(ctx, resp, err)
-	})
 	f.dirCache.FlushDir(dir)
+	forceRefresh()
 	return nil
 }
 
@@ -988,8 +2092,14 @@ func (f *Fs) Rmdir(ctx context.Context, dir string) error {
 }
 
 // Precision return the precision of this Fs
+//
+// Modification times are only stable between runs if they are kept in
+// the state_file.
 func (f *Fs) Precision() time.Duration {
-	return fs.ModTimeNotSupported
+	if f.opt.StateFile == "" {
+		return fs.ModTimeNotSupported
+	}
+	return time.Second
 }
 
 // Purge deletes all the files in the directory
@@ -1032,6 +2142,9 @@ func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object,
 	if err != nil {
 		return nil, err
 	}
+	if f.dirLocked(srcObj.ParentID) || f.dirLocked(directoryID) {
+		return nil, errFolderLocked
+	}
 
 	// Do the move
 	err = f.move(ctx, true, srcObj.id, path.Base(srcObj.remote), leaf, srcObj.ParentID, directoryID)
@@ -1065,6 +2178,9 @@ func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string
 	if err != nil {
 		return err
 	}
+	if f.dirLocked(srcID) || f.dirLocked(dstDirectoryID) {
+		return errFolderLocked
+	}
 
 	// Do the move
 	err = f.move(ctx, false, srcID, srcLeaf, dstLeaf, srcDirectoryID, dstDirectoryID)
@@ -1090,6 +2206,21 @@ func (f *Fs) PublicLink(ctx context.Context, remote string, expire fs.Duration,
 
 // About gets quota information
 func (f *Fs) About(ctx context.Context) (usage *fs.Usage, err error) {
+	if f.opt.FreeSpace < 0 {
+		return usage, nil
+	}
+	var used int64
+	listMu.RLock()
+	for i := range torrents {
+		used += torrents[i].Bytes
+	}
+	listMu.RUnlock()
+	free := int64(f.opt.FreeSpace)
+	usage = &fs.Usage{
+		Total: fs.NewUsageValue(used + free),
+		Used:  fs.NewUsageValue(used),
+		Free:  fs.NewUsageValue(free),
+	}
 	return usage, nil
 }
 
@@ -1149,12 +2280,23 @@ func (o *Object) setMetaData(info *api.Item) (err error) {
 	o.modTime = time.Unix(info.CreatedAt, 0)
 	o.id = info.ID
 	o.mimeType = info.MimeType
+	if o.mimeType == "" {
+		o.mimeType = mimeTypeFromName(o.remote)
+	}
 	o.url = info.Link
+	o.originalLink = info.OriginalLink
+	o.generated = parseGenerated(info.LinkGenerated)
 	o.ParentID = info.ParentID
 	o.TorrentHash = info.TorrentHash
+	o.fileID = info.FileID
 	return nil
 }
 
+// fileKey returns the modTimeKey of the object
+func (o *Object) fileKey() string {
+	return modTimeKey(o.TorrentHash, o.fileID, o.originalLink)
+}
+
 // readMetaData gets the metadata if it hasn't already been fetched
 //
 // it also sets the info
@@ -1162,7 +2304,7 @@ func (o *Object) readMetaData(ctx context.Context) (err error) {
 	if o.hasMetaData {
 		return nil
 	}
-	info, err := o.fs.readMetaDataForPath(ctx, o.remote, false, true)
+	info, err := o.fs.lookup(ctx, o.remote, false, true)
 	if err != nil {
 		return err
 	}
@@ -1184,8 +2326,28 @@ func (o *Object) ModTime(ctx context.Context) time.Time {
 }
 
 // SetModTime sets the modification time of the local fs object
+//
+// The modification time is only kept in the backend so it is lost on
+// restart unless state_file is set.
 func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
-	return fs.ErrorCantSetModTime
+	if err := o.fs.checkWritable(); err != nil {
+		return err
+	}
+	if err := o.fs.checkLeader(); err != nil {
+		return err
+	}
+	err := o.readMetaData(ctx)
+	if err != nil {
+		return err
+	}
+	key := o.fileKey()
+	if key == "" {
+		return fs.ErrorCantSetModTime
+	}
+	setModTime(key, modTime.Unix())
+	o.modTime = time.Unix(modTime.Unix(), 0)
+	o.fs.saveState()
+	return nil
 }
 
 // Storable returns a boolean showing whether this object storable
@@ -1193,40 +2355,134 @@ func (o *Object) Storable() bool {
 	return true
 }
 
+// blockedHeaders are headers which are never passed on to the download
+// host from the open options
+var blockedHeaders = map[string]bool{
+	"Authorization":  true,
+	"Cookie":         true,
+	"Host":           true,
+	backgroundHeader: true,
+}
+
+// openOptions turns the options passed to Open into the options for
+// the download request.
+//
+// All the SeekOption~s and RangeOption~s are combined into a single
+// RangeOption so exactly one coherent Range header is sent, and any
+// other headers, e.g. from HTTPOption~s, are passed through unless
+// they are in blockedHeaders.
+func openOptions(options []fs.OpenOption, size int64) (out []fs.OpenOption) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset, limit = x.Offset, -1
+		case *fs.RangeOption:
+			offset, limit = x.Decode(size)
+		default:
+			key, _ := option.Header()
+			if blockedHeaders[http.CanonicalHeaderKey(key)] {
+				fs.Debugf(nil, "realdebrid: not passing %q header to download host", key)
+				continue
+			}
+			out = append(out, option)
+		}
+	}
+	if offset == 0 && limit < 0 {
+		return out
+	}
+	end := int64(-1)
+	if limit >= 0 {
+		end = offset + limit - 1
+	}
+	if size >= 0 && (end < 0 || end >= size) {
+		end = size - 1
+	}
+	return append(out, &fs.RangeOption{Start: offset, End: end})
+}
+
 // Open an object for read
 func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (in io.ReadCloser, err error) {
+	if err := checkOnline(); err != nil {
+		return nil, err
+	}
 	if o.url == "" {
 		return nil, errors.New("can't download - no URL")
 	}
-	fs.FixRangeOption(options, o.size)
+	if isTakenDown(o.originalLink) {
+		return nil, takenDownError(o.originalLink)
+	}
+	background := isBackground(options)
+	options = openOptions(options, o.size)
+	o.fs.scan.read(time.Now())
+	offset, limit := int64(0), int64(-1)
+	var headers []fs.OpenOption
+	for _, option := range options {
+		if x, ok := option.(*fs.RangeOption); ok {
+			offset, limit = x.Decode(o.size)
+		} else {
+			headers = append(headers, option)
+		}
+	}
+	fileKey := o.fileKey()
+	if o.fs.cache != nil && o.size > 0 && fileKey != "" {
+		markOpened(o.ParentID, fileKey)
+		countPlay(fileKey, offset)
+		in = newCacheReader(ctx, o, o.fs.cache, headers, offset, limit)
+		in = &finishCounter{ReadCloser: in, fileKey: fileKey, offset: offset, size: o.size}
+		return o.fs.throttle(ctx, in, background), nil
+	}
+	if err := o.fs.slots.acquire(ctx); err != nil {
+		return nil, err
+	}
+	if o.fs.opt.ChunkSize > 0 && o.originalLink != "" {
+		in, err = newChunkedReader(ctx, o, options, int64(o.fs.opt.ChunkSize))
+	} else {
+		in, err = o.openDownload(ctx, options)
+		if err == nil && o.fs.opt.StreamRetries > 0 && o.originalLink != "" {
+			in = newRetryReader(ctx, o, in, options)
+		}
+	}
+	if err != nil {
+		o.fs.slots.release()
+		return nil, err
+	}
+	in = &slotReader{ReadCloser: in, slots: o.fs.slots}
+	markOpened(o.ParentID, fileKey)
+	countPlay(fileKey, offset)
+	in = &finishCounter{ReadCloser: in, fileKey: fileKey, offset: offset, size: o.size}
+	return o.fs.throttle(ctx, in, background), nil
+}
+
+// download opens downloadURL with the options given
+func (o *Object) download(ctx context.Context, downloadURL string, options []fs.OpenOption) (in io.ReadCloser, err error) {
 	var resp *http.Response
 	var err_code = 0
 	opts := rest.Opts{
 		Path:    "",
-		RootURL: o.url,
+		RootURL: downloadURL,
 		Method:  "GET",
 		Options: options,
 	}
 	err = o.fs.pacer.Call(func() (bool, error) {
-		resp, err = o.fs.srv.Call(ctx, &opts)
+		resp, err = o.fs.dl.Call(ctx, &opts)
 		if resp != nil {
 			err_code = resp.StatusCode
 		}
 		return shouldRetry(ctx, resp, err)
 	})
+	if isInfringing(err) {
+		markTakenDown(takedown{Link: o.originalLink, TorrentID: o.ParentID, Name: o.remote})
+		return nil, takenDownError(o.originalLink)
+	}
 	if err != nil {
-		if err_code == 503 {
-			for _, TorrentID := range broken_torrents {
-				if o.ParentID == TorrentID {
-					return nil, err
-				}
-			}
-			fmt.Println("Error opening file: '" + o.url + "'.")
+		if err_code == 503 && markBroken(o.ParentID) {
+			fmt.Println("Error opening file: '" + downloadURL + "'.")
 			fmt.Println("This link seems to be broken. Torrent will be re-downloaded on next refresh.")
-			broken_torrents = append(broken_torrents, o.ParentID)
 		}
 		return nil, err
 	}
+	rememberValidator(downloadURL, resp)
 	return resp.Body, err
 }
 
@@ -1245,58 +2501,40 @@ func (f *Fs) remove(ctx context.Context, id ...string) (err error) {
 	//if f.opt.RootFolderID == "torrents" {
 	//	fmt.Printf("Removing torrent id: '%s'\n", id[1])
 	//}
-	path := "/downloads/delete/" + id[0]
-	opts := rest.Opts{
-		Method:     "DELETE",
-		Path:       path,
-		Parameters: f.baseParams(),
-	}
-	var resp *http.Response
-	var result api.Response
-	var retries = 0
-	var err_code = 0
-	resp, _ = f.srv.CallJSON(ctx, &opts, nil, &result)
-	if resp != nil {
-		err_code = resp.StatusCode
-	}
-	for err_code == 429 && retries <= 5 {
-		time.Sleep(time.Duration(2) * time.Second)
-		resp, _ = f.srv.CallJSON(ctx, &opts, nil, &result)
-		if resp != nil {
-			err_code = resp.StatusCode
-		}
-		retries += 1
+	err = f.deleteDownload(ctx, id[0])
+	if err != nil {
+		// the link is only a cache of the torrent so carry on
+		fs.Debugf(f, "Remove: %v", err)
 	}
-	if f.opt.RootFolderID == "torrents" {
-		path = "/torrents/delete/" + id[1]
-		opts := rest.Opts{
-			Method:     "DELETE",
-			Path:       path,
-			Parameters: f.baseParams(),
-		}
-		var resp *http.Response
-		var result api.Response
-		var err_code = 0
-		resp, _ = f.srv.CallJSON(ctx, &opts, nil, &result)
-		if resp != nil {
-			err_code = resp.StatusCode
-		}
-		if err_code == 429 {
-			time.Sleep(time.Duration(2) * time.Second)
-			_, _ = f.srv.CallJSON(ctx, &opts, nil, &result)
+	if f.opt.RootFolderID == "torrents" && len(id) > 1 {
+		err = f.deleteTorrent(ctx, id[1])
+		if err != nil {
+			return err
 		}
 	}
-	lastcheck = time.Now().Unix() - interval
+	forceRefresh()
 	return nil
 }
 
 // Remove an object
 func (o *Object) Remove(ctx context.Context) error {
 	//fmt.Printf("Removing: '%s'\n", o.remote)
+	if err := checkOnline(); err != nil {
+		return err
+	}
+	if err := o.fs.checkWritable(); err != nil {
+		return err
+	}
 	err := o.readMetaData(ctx)
 	if err != nil {
 		return fmt.Errorf("Remove: Failed to read metadata: %w", err)
 	}
+	if o.fs.dirLocked(o.ParentID) {
+		return errFolderLocked
+	}
+	if operations.SkipDestructive(ctx, o, "remove") {
+		return nil
+	}
 	if o.ParentID != "" {
 		return o.fs.remove(ctx, o.id, o.ParentID)
 	} else {
@@ -1310,10 +2548,469 @@ func (o *Object) MimeType(ctx context.Context) string {
 }
 
 // ID returns the ID of the Object if known, or "" if not
+//
+// The ID of a download changes every time its link is unrestricted
+// again, so files are identified by their modTimeKey instead: the
+// lower case info hash of their torrent and their RealDebrid file ID,
+// which survive repairs and selecting more files of the torrent, or
+// their hoster link. This lets tools tracking files by ID follow them
+// across renames, conflict resolution and re-sorting of the library.
 func (o *Object) ID() string {
+	if key := o.fileKey(); key != "" {
+		return key
+	}
 	return o.id
 }
 
+var commandHelp = []fs.CommandHelp{{
+	Name:  "conflicts",
+	Short: "Show files which ended up with the same name",
+	Long: `This walks the directory tree and shows the files which mapped to the
+same name in a directory, what conflict_policy was applied and which
+files are still shown.
+
+Torrents with the same name, e.g. re-grabs of the same release, are
+always kept and shown as well. The newer ones have the first 8
+characters of their hash added to their folder name, eg
+"Name [0123abcd]".
+
+    rclone backend conflicts realdebrid:
+    rclone backend conflicts realdebrid: shows
+`,
+}, {
+	Name:  "sort-export",
+	Short: "Export the complete library state as JSON",
+	Long: `This makes a single portable JSON snapshot of the library: the sorting
+rules (regex_shows, regex_movies, regex_folders and name_parser), the
+torrents, the unrestricted links and the torrents waiting to
+be repaired. It is printed unless a file name is given.
+
+    rclone backend sort-export realdebrid:
+    rclone backend sort-export realdebrid: library.json
+`,
+}, {
+	Name:  "sort-import",
+	Short: "Import a library state exported with sort-export",
+	Long: `This replaces the library state with a snapshot made by sort-export,
+e.g. to restore a backup or move to a new machine. The sorting rules
+in the snapshot are also saved to the config file.
+
+    rclone backend sort-import realdebrid: library.json
+`,
+}, {
+	Name:  "verify",
+	Short: "Check that every file has a working link",
+	Long: `This walks the directory tree and checks the download link of every
+file with a HEAD request. It reports files without a link, files whose
+link is dead or returns a different size, and files of torrents which
+have been deleted.
+
+    rclone backend verify realdebrid:
+    rclone backend verify realdebrid: shows -o delay=1s
+`,
+	Opts: map[string]string{
+		"delay": "time to wait between checking links (default 250ms)",
+		"all":   "also list the files which are OK",
+	},
+}, {
+	Name:  "link-expiry",
+	Short: "Show when the download links of files expire",
+	Long: `This walks the directory tree and shows for every file when its
+download link was generated and when it is expected to expire, which
+is the time it was generated plus link_validity. The files whose links
+expire first are listed first and files where the time the link was
+generated isn't known are listed last.
+
+    rclone backend link-expiry realdebrid:
+    rclone backend link-expiry realdebrid: shows -o within=6h
+`,
+	Opts: map[string]string{
+		"within": "only list files whose links expire within this long, including expired ones",
+	},
+}, {
+	Name:  "health",
+	Short: "Show the state of each file in the library",
+	Long: `This walks the directory tree and shows the state of every file, so
+tools built on top of the remote can show the health of the library at
+a glance. The states are
+
+- ok - the file has a working link
+- link-expired - the link is past link_validity and will be unrestricted again when opened
+- pending-download - the torrent hasn't finished downloading or the file has no link yet
+- broken - the torrent is dead or waiting to be repaired
+- taken-down - RealDebrid has taken the file down as infringing
+- quarantined - RealDebrid flagged the torrent, listed in /.quarantine with the status as reason
+
+Only the files which aren't ok are listed unless -o all is given or the
+states to list are given as a comma separated list with -o state.
+
+    rclone backend health realdebrid:
+    rclone backend health realdebrid: shows -o state=broken,taken-down
+`,
+	Opts: map[string]string{
+		"all":   "list every file including those which are ok",
+		"state": "comma separated states of the files to list",
+	},
+}, {
+	Name:  "watch",
+	Short: "Stream the changes of the library as JSON lines",
+	Long: `This prints a JSON object on a line of its own for every change of the
+library until interrupted, for piping into other tools. The events are
+"add" and "remove" of torrents, "repair" of a dead torrent and "move"
+of a torrent into another sorting folder, with the path, name,
+torrent_id, hash and time like the hooks get, and "from" for moves.
+
+With -o rc it follows the rclone instance, e.g. a mount, serving the rc
+at the address given using the backend/realdebrid/events rc call.
+Otherwise it refreshes the library itself every interval.
+
+    rclone backend watch realdebrid:
+    rclone backend watch realdebrid: -o rc=http://localhost:5572 -o user=me -o pass=secret
+`,
+	Opts: map[string]string{
+		"rc":       "address of the rc of a running rclone to follow",
+		"user":     "user name for the rc",
+		"pass":     "password for the rc",
+		"interval": "how often to look for changes, default 1m",
+	},
+}, {
+	Name:  "link-refresh",
+	Short: "Unrestrict the links of files again",
+	Long: `This unrestricts the download link of every file under the path given,
+or of the file given, again straight away whether or not the link
+still works, and uses the new links from then on. This is useful just
+before going offline to watch something, or to fix a link which keeps
+failing. It reports the new link or the error for each file.
+
+    rclone backend link-refresh realdebrid: movies/Film
+    rclone backend link-refresh realdebrid: movies/Film/Film.mkv
+`,
+}, {
+	Name:  "stats",
+	Short: "Show statistics about the library",
+	Long: `This shows the number and size of the torrents, how many of them have
+been opened recently and how many are cold, and the files opened most
+recently. The times files were last opened are kept in the state_file
+if set.
+
+It also lists the files RealDebrid has taken down as infringing. These
+are left out of listings and never re-added as re-adding the torrent
+would only get the same links.
+
+It shows how many downloads are streaming now, how many are waiting
+for a slot when max_downloads is set, and the most there have been at
+once.
+
+The files played most are listed with how many times they were opened
+from the start and how many times they were read through to the end.
+Files which are never finished are candidates for cleaning up. These
+counts are kept in the state_file if set.
+
+    rclone backend stats realdebrid:
+    rclone backend stats realdebrid: -o cold=168h
+`,
+	Opts: map[string]string{
+		"cold": "torrents not opened for this long are cold (default 720h)",
+		"tag":  "only count torrents with this tag",
+	},
+}, {
+	Name:  "tag",
+	Short: "Show or change the tags of a torrent",
+	Long: `Torrents can be given tags, e.g. "kids" or "anime", to organise the
+library beyond folders. The tags are kept by info hash so they survive
+repairs, and are saved in the state_file if set.
+
+Given the path of a torrent folder or a file in it, this shows its tags
+or changes them with add and remove, which take comma separated lists.
+Without a path it lists all the tagged torrents.
+
+    rclone backend tag realdebrid: "shows/Some Show S01"
+    rclone backend tag realdebrid: "shows/Some Show S01" -o add=kids,cartoons
+    rclone backend tag realdebrid: "shows/Some Show S01" -o remove=cartoons
+    rclone backend tag realdebrid: -o tag=kids
+`,
+	Opts: map[string]string{
+		"add":    "comma separated tags to add",
+		"remove": "comma separated tags to remove",
+		"tag":    "only list torrents with this tag",
+	},
+}, {
+	Name:  "lock",
+	Short: "Lock a folder against automatic changes",
+	Long: `This locks a sorting folder, e.g. a curated collection, and everything
+inside it. The torrents in it are pinned there even if the sorting
+rules change, and they are never repaired, evicted or hidden by
+conflict_policy. Deleting or moving anything inside it through the
+mount fails until it is unlocked with the unlock command. The locks are
+saved in the state_file if set.
+
+Without a path it lists the locked folders.
+
+    rclone backend lock realdebrid: shows/anime
+    rclone backend lock realdebrid:
+`,
+}, {
+	Name:  "unlock",
+	Short: "Unlock a folder locked by the lock command",
+	Long: `This unlocks a folder locked with the lock command. Its torrents are
+sorted by the sorting rules again.
+
+    rclone backend unlock realdebrid: shows/anime
+`,
+}, {
+	Name:  "prune-downloads",
+	Short: "Delete old entries from the RealDebrid downloads list",
+	Long: `Every link unrestricted adds an entry to the /downloads list on
+RealDebrid, which makes it slower to fetch. This deletes the entries
+generated longer ago than older, and with orphaned the entries which
+don't belong to any torrent. Links which are still needed are simply
+unrestricted again. Use --dry-run to see what would be deleted.
+
+    rclone backend prune-downloads realdebrid: -o older=720h
+    rclone backend prune-downloads realdebrid: -o orphaned
+`,
+	Opts: map[string]string{
+		"older":    "delete entries generated longer ago than this",
+		"orphaned": "delete entries whose torrent is gone",
+	},
+}, {
+	Name:  "sort-test",
+	Short: "Show how a torrent name would be sorted",
+	Long: `This runs the torrent name given through the sorting rules and shows
+which rule matched and what, the folder the torrent would end up in,
+and why the rules before didn't match. It also shows whether the
+folder would be hidden by root_include or root_exclude, and what the
+name_parser makes of the name. Nothing is changed so it is useful for
+trying out new rules with -o.
+
+    rclone backend sort-test realdebrid: "Some.Show.S02E03.2160p.WEB"
+    rclone backend sort-test "realdebrid,regex_shows='(?i)S\d\d':" "Some.Show.S02E03.2160p.WEB"
+`,
+}, {
+	Name:  "replace",
+	Short: "Replace a torrent with another release of it",
+	Long: `This upgrades a torrent to another release, e.g. a better quality one,
+in one go. It adds the release in the magnet link or info hash given,
+waits for it to be downloaded, then shows it under the name of the old
+one so it keeps the same path. The tags of the old release and when it
+was last opened are carried over, and it is deleted and put in
+.orphaned so it can be reacquired if the new one is no good. The
+modification times, access times and play counts of its files are
+carried over to the files of the new release with the same name, or
+else the same season and episode, where exactly one file matches.
+
+If the new release isn't downloaded within wait the old one is kept.
+
+    rclone backend replace realdebrid: "movies/Some Film 1999" "magnet:?xt=urn:btih:..."
+    rclone backend replace realdebrid: "movies/Some Film 1999" 0123456789abcdef0123456789abcdef01234567 -o wait=30m
+`,
+	Opts: map[string]string{
+		"wait": "how long to wait for the new release to download (default 10m)",
+	},
+}, {
+	Name:  "select",
+	Short: "Select more files of partially selected torrents",
+	Long: `With show_unselected set, the files of torrents which weren't selected
+when they were added are shown in the .unselected folder. Given the
+paths of files or torrent folders in it, this selects those files so
+RealDebrid fetches them and they show up in the library.
+
+RealDebrid can't change the selection of a torrent so the torrent is
+added again with the files selected before and the new ones, then the
+old one is deleted. Use --dry-run to see what would be selected.
+
+    rclone backend select realdebrid: ".unselected/Some Show S01/extras.mkv"
+    rclone backend select realdebrid: ".unselected/Some Show S01"
+`,
+}, {
+	Name:  "sort-suggest",
+	Short: "Suggest sorting rules for torrents which end up in default",
+	Long: `This groups the names of the torrents no sorting rule matches, which
+end up in default, by release group tag, e.g. "[SubsPlease]", and by
+their first two words, and proposes a regex_folders rule for each group
+with how many torrents it matches and a few of them as examples. Groups
+which look like episodes are put under shows. The rules can be pasted
+into regex_folders and tried out with sort-test first.
+
+    rclone backend sort-suggest realdebrid:
+    rclone backend sort-suggest realdebrid: -o min=5 -o max=10
+`,
+	Opts: map[string]string{
+		"min": "only suggest rules matching at least this many torrents (default 2)",
+		"max": "the most rules to suggest (default 20)",
+	},
+}, {
+	Name:  "search",
+	Short: "Find torrents and files by name",
+	Long: `This finds the torrents and files whose names contain all the words
+given, ignoring case and punctuation, and returns their paths in the
+remote. Words also match the start of longer words. Torrents which
+match are listed first, then matching files inside torrents which
+don't. It searches the library already in memory so it makes no API
+calls.
+
+    rclone backend search realdebrid: "breath of the wild"
+    rclone backend search realdebrid: s01e05 -o limit=10
+`,
+	Opts: map[string]string{
+		"limit": "the most results to return (default 100)",
+	},
+}, {
+	Name:  "status",
+	Short: "Show the RealDebrid status of torrents",
+	Long: `This shows the status of each torrent as RealDebrid reports it, e.g.
+downloaded, downloading, magnet_error or dead, with its progress in
+percent and number of files, from the library already in memory so it
+makes no API calls. It can drive external dashboards. The number of
+files taken down as infringing is shown too.
+
+Given the path of a torrent folder or a file in it, it shows just that
+torrent. The status option only shows torrents with that status.
+
+    rclone backend status realdebrid:
+    rclone backend status realdebrid: "shows/Some Show S01"
+    rclone backend status realdebrid: -o status=dead
+`,
+	Opts: map[string]string{
+		"status": "only show torrents with this status",
+	},
+}, {
+	Name:  "orphan-scan",
+	Short: "Find state entries for torrents no longer in the account",
+	Long: `The state kept for the library - pinned modification times, when
+files were opened, tags and broken torrents - is keyed by torrent, hash
+or link and isn't removed with the torrent, so it grows stale over time.
+
+This lists the entries which refer to torrents or links no longer in
+the account, and with prune removes them and saves the state_file. Use
+--dry-run with prune to see what would be removed.
+
+    rclone backend orphan-scan realdebrid:
+    rclone backend orphan-scan realdebrid: -o prune
+`,
+	Opts: map[string]string{
+		"prune": "remove the stale entries",
+	},
+}, {
+	Name:  "import-downloads",
+	Short: "Import old downloads which don't belong to a torrent",
+	Long: `Links unrestricted directly on the RealDebrid website are in the
+/downloads list without a torrent, so they aren't shown anywhere. This
+matches each entry of the list to the torrents by its link, and
+imports the rest into a /legacy folder in the root. It only needs to be
+run once as the imported downloads are kept in the state_file, and
+running it again imports any new ones. prune-downloads never deletes
+imported downloads.
+
+    rclone backend import-downloads realdebrid:
+`,
+}, {
+	Name:  "reacquire",
+	Short: "Add torrents removed outside rclone back to the account",
+	Long: `Torrents which disappear from the account without rclone removing
+them, e.g. deleted in the web UI, are shown as empty folders in the
+/.orphaned directory. They are kept in the state_file if set.
+
+This adds the named torrents back by their info hash and selects all
+their files. Pass the names or hashes of the torrents, or nothing to
+reacquire all of them.
+
+    rclone backend reacquire realdebrid:
+    rclone backend reacquire realdebrid: "Some Show S01"
+`,
+}}
+
+// Shutdown the backend, saving the state and giving up the lease on
+// the coordination_file
+func (f *Fs) Shutdown(ctx context.Context) error {
+	f.saveState()
+	f.coord.release()
+	return nil
+}
+
+// Command the backend to run a named command
+//
+// The command run is name
+// args may be used to read arguments from
+// opts may be used to read optional arguments from
+//
+// The result should be capable of being JSON encoded
+// If it is a string or a []string it will be shown to the user
+// otherwise it will be JSON encoded and shown to the user like that
+func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[string]string) (out interface{}, err error) {
+	if commandWrites(name, opt) {
+		if f.opt.ReadOnly {
+			return nil, errReadOnly
+		}
+		if err := f.checkLeader(); err != nil {
+			return nil, err
+		}
+	}
+	switch name {
+	case "conflicts":
+		dir := ""
+		if len(arg) > 0 {
+			dir = parsePath(arg[0])
+		}
+		return f.listConflicts(ctx, dir)
+	case "sort-export":
+		fileName := ""
+		if len(arg) > 0 {
+			fileName = arg[0]
+		}
+		return f.exportLibrary(ctx, fileName)
+	case "sort-import":
+		if len(arg) != 1 {
+			return nil, errors.New("need exactly 1 argument: the file to import")
+		}
+		return f.importLibrary(ctx, arg[0])
+	case "verify":
+		return f.verifyCommand(ctx, arg, opt)
+	case "stats":
+		return f.statsCommand(ctx, opt)
+	case "link-expiry":
+		return f.linkExpiryCommand(ctx, arg, opt)
+	case "health":
+		return f.healthCommand(ctx, arg, opt)
+	case "watch":
+		return f.watchCommand(ctx, opt)
+	case "link-refresh":
+		return f.linkRefreshCommand(ctx, arg)
+	case "tag":
+		return f.tagCommand(ctx, arg, opt)
+	case "lock":
+		return f.lockCommand(ctx, arg)
+	case "unlock":
+		return f.unlockCommand(ctx, arg)
+	case "prune-downloads":
+		return f.pruneCommand(ctx, opt)
+	case "sort-test":
+		if len(arg) != 1 {
+			return nil, errors.New("need exactly one torrent name")
+		}
+		return f.sortTest(arg[0])
+	case "select":
+		return f.selectCommand(ctx, arg)
+	case "replace":
+		return f.replaceCommand(ctx, arg, opt)
+	case "sort-suggest":
+		return f.sortSuggest(ctx, opt)
+	case "search":
+		return f.searchCommand(arg, opt)
+	case "status":
+		return f.statusCommand(ctx, arg, opt)
+	case "orphan-scan":
+		_, prune := opt["prune"]
+		return f.orphanScan(ctx, prune)
+	case "import-downloads":
+		return f.importDownloads(ctx)
+	case "reacquire":
+		return f.reacquire(ctx, arg)
+	default:
+		return nil, fs.ErrorCommandNotFound
+	}
+}
+
 // Check the interfaces are satisfied
 var (
 	_ fs.Fs              = (*Fs)(nil)
@@ -1323,6 +3020,8 @@ var (
 	_ fs.DirCacheFlusher = (*Fs)(nil)
 	_ fs.Abouter         = (*Fs)(nil)
 	_ fs.PublicLinker    = (*Fs)(nil)
+	_ fs.Commander       = (*Fs)(nil)
+	_ fs.Shutdowner      = (*Fs)(nil)
 	_ fs.Object          = (*Object)(nil)
 	_ fs.MimeTyper       = (*Object)(nil)
 	_ fs.IDer            = (*Object)(nil)
@@ -0,0 +1,2326 @@
+package realdebrid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/memory"
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/dircache"
+	"github.com/rclone/rclone/lib/encoder"
+	"github.com/rclone/rclone/lib/pacer"
+	"github.com/rclone/rclone/lib/rest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStandardName(t *testing.T) {
+	f := &Fs{
+		opt: Options{
+			Enc: encoder.Display | encoder.EncodeBackSlash | encoder.EncodeDoubleQuote | encoder.EncodeInvalidUtf8,
+		},
+	}
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"Show.S01E01.mkv", "Show.S01E01.mkv"},
+		{"AC/DC Live", "AC／DC Live"},
+		{"bad\xffname", "bad‛FFname"},
+		{"", "ABC123"},
+		{".", "．"},
+	} {
+		assert.Equal(t, test.want, f.standardName(test.in, "ABC123"), test.in)
+	}
+}
+
+func TestOpenOptions(t *testing.T) {
+	referer := &fs.HTTPOption{Key: "Referer", Value: "http://example.com/"}
+	auth := &fs.HTTPOption{Key: "authorization", Value: "Bearer secret"}
+	for _, test := range []struct {
+		name string
+		in   []fs.OpenOption
+		want []fs.OpenOption
+	}{{
+		name: "none",
+		in:   nil,
+		want: nil,
+	}, {
+		name: "seek",
+		in:   []fs.OpenOption{&fs.SeekOption{Offset: 10}},
+		want: []fs.OpenOption{&fs.RangeOption{Start: 10, End: 99}},
+	}, {
+		name: "seek zero",
+		in:   []fs.OpenOption{&fs.SeekOption{Offset: 0}},
+		want: nil,
+	}, {
+		name: "range",
+		in:   []fs.OpenOption{&fs.RangeOption{Start: 10, End: 19}},
+		want: []fs.OpenOption{&fs.RangeOption{Start: 10, End: 19}},
+	}, {
+		name: "range from end",
+		in:   []fs.OpenOption{&fs.RangeOption{Start: -1, End: 10}},
+		want: []fs.OpenOption{&fs.RangeOption{Start: 90, End: 99}},
+	}, {
+		name: "range past end",
+		in:   []fs.OpenOption{&fs.RangeOption{Start: 50, End: 200}},
+		want: []fs.OpenOption{&fs.RangeOption{Start: 50, End: 99}},
+	}, {
+		name: "seek and range",
+		in:   []fs.OpenOption{&fs.SeekOption{Offset: 5}, &fs.RangeOption{Start: 20, End: 29}},
+		want: []fs.OpenOption{&fs.RangeOption{Start: 20, End: 29}},
+	}, {
+		name: "headers",
+		in:   []fs.OpenOption{referer, auth, &fs.SeekOption{Offset: 5}},
+		want: []fs.OpenOption{referer, &fs.RangeOption{Start: 5, End: 99}},
+	}, {
+		name: "background",
+		in:   []fs.OpenOption{&fs.HTTPOption{Key: "x-rclone-background", Value: "1"}},
+		want: nil,
+	}} {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, openOptions(test.in, 100))
+		})
+	}
+}
+
+func TestTakeUnrestrict(t *testing.T) {
+	atomic.StoreInt64(&unrestricts, 0)
+	defer atomic.StoreInt64(&unrestricts, 0)
+
+	f := &Fs{opt: Options{MaxUnrestricts: 4}}
+	assert.True(t, f.takeUnrestrict(false))
+	assert.True(t, f.takeUnrestrict(false))
+	assert.False(t, f.takeUnrestrict(false))
+	assert.True(t, f.takeUnrestrict(true))
+	assert.True(t, f.takeUnrestrict(true))
+	assert.False(t, f.takeUnrestrict(true))
+
+	f.opt.MaxUnrestricts = 0
+	assert.True(t, f.takeUnrestrict(false))
+}
+
+func TestPinModTime(t *testing.T) {
+	defer func() { modTimes = map[string]int64{} }()
+
+	key := modTimeKey("ABCDEF", 2, "https://hoster/1")
+	assert.Equal(t, "abcdef/2", key)
+	assert.Equal(t, "https://hoster/1", modTimeKey("", 0, "https://hoster/1"))
+	assert.Equal(t, "", modTimeKey("abcdef", 0, ""))
+
+	pinned, added := pinModTime(key, 100)
+	assert.Equal(t, int64(100), pinned)
+	assert.True(t, added)
+	pinned, added = pinModTime(key, 200)
+	assert.Equal(t, int64(100), pinned)
+	assert.False(t, added)
+	setModTime(key, 50)
+	pinned, _ = pinModTime(key, 200)
+	assert.Equal(t, int64(50), pinned)
+
+	// files pinned by name before are moved over with their counts
+	defer func() { plays = map[string]int64{} }()
+	modTimes["abcdef/Film.mkv"] = 30
+	plays["abcdef/Film.mkv"] = 2
+	pinned, added = pinModTime("abcdef/1", 200, "https://hoster/9", "abcdef/Film.mkv")
+	assert.Equal(t, int64(30), pinned)
+	assert.True(t, added)
+	assert.NotContains(t, modTimes, "abcdef/Film.mkv")
+	assert.Equal(t, map[string]int64{"abcdef/1": 2}, plays)
+
+	// pins are saved a little later rather than holding up listings
+	f := &Fs{}
+	f.saveStateSoon()
+	saveTimerMu.Lock()
+	assert.NotNil(t, saveTimer)
+	saveTimerMu.Unlock()
+	f.saveState()
+	saveTimerMu.Lock()
+	assert.Nil(t, saveTimer)
+	saveTimerMu.Unlock()
+}
+
+func TestEvictionOrder(t *testing.T) {
+	defer func() { opened = map[string]int64{} }()
+
+	list := []api.Item{
+		{ID: "new", Ended: "2022-03-01T00:00:00.000Z", Bytes: 10},
+		{ID: "old", Ended: "2022-01-01T00:00:00.000Z", Bytes: 30},
+		{ID: "watched", Ended: "2021-01-01T00:00:00.000Z", Bytes: 20},
+	}
+	markOpened("watched", "")
+	assert.Equal(t, []int{1, 0, 2}, evictionOrder(list, evictOldestUnwatched))
+	assert.Equal(t, []int{1, 2, 0}, evictionOrder(list, evictLargest))
+}
+
+func TestRetryAfter(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	assert.Equal(t, defaultRetryAfter, retryAfter(resp))
+	resp.Header.Set("Retry-After", "7")
+	assert.Equal(t, 7*time.Second, retryAfter(resp))
+	resp.Header.Set("Retry-After", "-1")
+	assert.Equal(t, defaultRetryAfter, retryAfter(resp))
+	resp.Header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	d := retryAfter(resp)
+	assert.True(t, d > 58*time.Second && d <= time.Minute, d)
+	resp.Header.Set("Retry-After", "soon")
+	assert.Equal(t, defaultRetryAfter, retryAfter(resp))
+}
+
+func TestEditTags(t *testing.T) {
+	defer func() { tags = map[string][]string{} }()
+
+	assert.Equal(t, []string{"anime", "kids"}, editTags("ABC", []string{"kids", "anime"}, nil))
+	assert.True(t, hasTag("abc", "kids"))
+	assert.Equal(t, []string{"anime"}, editTags("abc", []string{"anime"}, []string{"kids"}))
+	assert.False(t, hasTag("ABC", "kids"))
+	assert.Empty(t, editTags("abc", nil, []string{"anime"}))
+	assert.Empty(t, tags)
+	assert.Equal(t, []string{"a", "b"}, splitTags(" a,,b ,"))
+}
+
+func TestResolveConflictsFolders(t *testing.T) {
+	f := &Fs{
+		opt:         Options{ConflictPolicy: conflictKeepNewest},
+		conflictsMu: new(sync.Mutex),
+		conflicts:   map[string][]conflict{},
+	}
+	items := []api.Item{
+		{ID: "T2", Name: "Film", Type: api.ItemTypeFolder, TorrentHash: "bbbbbbbbbbbb", CreatedAt: 2},
+		{ID: "T1", Name: "Film", Type: api.ItemTypeFolder, TorrentHash: "aaaaaaaaaaaa", CreatedAt: 1},
+		{ID: "T3", Name: "Other", Type: api.ItemTypeFolder, TorrentHash: "cccccccccccc", CreatedAt: 3},
+	}
+	got, err := f.resolveConflicts("movies", items)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(got))
+	assert.Equal(t, "Film [bbbbbbbb]", got[0].Name)
+	assert.Equal(t, "Film", got[1].Name)
+	assert.Equal(t, "Other", got[2].Name)
+	c := f.conflicts["movies"]
+	assert.Equal(t, 1, len(c))
+	assert.Equal(t, conflictKeepBoth, c[0].Policy)
+	assert.Equal(t, "T1", c[0].Entries[0].TorrentID)
+}
+
+func TestSelectFiles(t *testing.T) {
+	f := &Fs{}
+	files := []api.File{
+		{ID: 1, Path: "/Foo.S01E01.mkv"},
+		{ID: 2, Path: "/Foo.S02E01.mkv"},
+		{ID: 3, Path: "/Foo.S02E02.mkv"},
+		{ID: 4, Path: "/Season 2/Extras.mkv"},
+	}
+	for _, test := range []struct {
+		remote string
+		want   string
+	}{
+		{"movies/Film.magnet", "all"},
+		{"shows/Foo/Season 2/Foo.magnet", "2,3,4"},
+		{"shows/Foo/Foo S02E02.magnet", "3"},
+		{"shows/Foo/Foo s2e2.magnet", "3"},
+		{"shows/Foo/Foo_S02E02.magnet", "3"},
+		{"shows/Foo/Season_2/Foo.magnet", "2,3,4"},
+		{"shows/Foo/Season 3/Foo.magnet", "all"},
+	} {
+		assert.Equal(t, test.want, f.selectFiles(test.remote, files), test.remote)
+	}
+
+	f.selectExclude = regexp.MustCompile(`(?i)extras`)
+	assert.Equal(t, "1,2,3", f.selectFiles("movies/Film.magnet", files))
+	assert.Equal(t, "2,3", f.selectFiles("shows/Foo/Season 2/Foo.magnet", files))
+	f.selectExclude = regexp.MustCompile(`mkv`)
+	assert.Equal(t, "all", f.selectFiles("movies/Film.magnet", files))
+}
+
+func TestFindOrphans(t *testing.T) {
+	defer func() { orphans = nil }()
+	f := &Fs{}
+	a := api.Item{ID: "A", Name: "a", TorrentHash: "AAAA"}
+	b := api.Item{ID: "B", Name: "b", TorrentHash: "BBBB"}
+	c := api.Item{ID: "C", Name: "c", TorrentHash: "CCCC"}
+	repaired := api.Item{ID: "C2", Name: "c", TorrentHash: "cccc"}
+
+	f.findOrphans(nil, []api.Item{a})
+	assert.Empty(t, orphans)
+
+	markRemoved("B")
+	f.findOrphans([]api.Item{a, b, c}, []api.Item{repaired})
+	assert.Equal(t, []api.Item{a}, orphans)
+	assert.Equal(t, 0, orphanIndex("aaaa"))
+
+	f.findOrphans([]api.Item{repaired}, []api.Item{repaired, a})
+	assert.Empty(t, orphans)
+}
+
+func TestSortTest(t *testing.T) {
+	f := &Fs{
+		opt: Options{
+			RegexShows:  `(?i)(S[0-9]{2}|SEASON|COMPLETE)`,
+			RegexMovies: `(?i)([0-9]{4} ?\.?)`,
+			Enc:         encoder.Display,
+		},
+		rootExclude: regexp.MustCompile(`^default/`),
+	}
+	r, err := f.sortTest("Some.Show.S02E03.2160p.WEB")
+	assert.NoError(t, err)
+	assert.Equal(t, "shows", r.Category)
+	assert.Equal(t, "shows/Some.Show.S02E03.2160p.WEB", r.Path)
+	assert.Equal(t, "S02", r.Rules[0].Match)
+	assert.True(t, r.Rules[1].Matched)
+	assert.False(t, r.Hidden)
+
+	r, err = f.sortTest("Film.1999.1080p")
+	assert.NoError(t, err)
+	assert.Equal(t, "movies", r.Category)
+	assert.False(t, r.Rules[0].Matched)
+
+	r, err = f.sortTest("Something")
+	assert.NoError(t, err)
+	assert.Equal(t, "default", r.Category)
+	assert.True(t, r.Hidden)
+
+	f.opt.RegexShows = "("
+	_, err = f.sortTest("Something")
+	assert.Error(t, err)
+}
+
+func TestOffline(t *testing.T) {
+	defer func() { offlineSince, offlineRetryAt = 0, 0 }()
+	f := &Fs{}
+	now := time.Now()
+	assert.True(t, offlineRetryDue(now))
+	assert.NoError(t, checkOnline())
+
+	assert.False(t, unreachable(&api.Error{StatusCode: http.StatusUnauthorized}))
+	saved, err := f.refreshFailed(&api.Error{StatusCode: http.StatusUnauthorized})
+	assert.False(t, saved)
+	assert.Error(t, err)
+	assert.False(t, isOffline())
+
+	_, err = f.refreshFailed(errors.New("dial tcp: connection refused"))
+	assert.NoError(t, err)
+	assert.Equal(t, errOffline, checkOnline())
+	assert.False(t, offlineRetryDue(now))
+	assert.True(t, offlineRetryDue(now.Add(offlineRetry)))
+
+	f.goOnline()
+	assert.False(t, isOffline())
+}
+
+func TestStaleEntries(t *testing.T) {
+	defer func() {
+		torrents, cachedLinks = nil, nil
+		modTimes, opened, accessed, tags = map[string]int64{}, map[string]int64{}, map[string]int64{}, map[string][]string{}
+	}()
+	torrents = []api.Item{{ID: "T1", TorrentHash: "aaaa", Links: []string{"https://example.com/1"}}}
+	cachedLinks = map[string]int{"https://example.com/2": 0}
+	modTimes = map[string]int64{"aaaa/file.mkv": 1, "bbbb/file.mkv": 1, "https://example.com/2": 1, "https://example.com/3": 1}
+	opened = map[string]int64{"T1": 1, "T2": 1}
+	accessed = map[string]int64{"aaaa/file.mkv": 1}
+	tags = map[string][]string{"aaaa": {"kids"}, "cccc": {"kids"}}
+
+	stale := staleEntries()
+	assert.Equal(t, []staleEntry{
+		{Store: "mod_times", Key: "bbbb/file.mkv"},
+		{Store: "mod_times", Key: "https://example.com/3"},
+		{Store: "opened", Key: "T2"},
+		{Store: "tags", Key: "cccc"},
+	}, stale)
+	for _, e := range stale {
+		pruneEntry(e)
+	}
+	assert.Empty(t, staleEntries())
+	assert.Equal(t, 2, len(modTimes))
+}
+
+func TestRuleFolders(t *testing.T) {
+	_, err := parseRuleFolders(fs.CommaSepList{"anime"})
+	assert.Error(t, err)
+	_, err = parseRuleFolders(fs.CommaSepList{"/=x"})
+	assert.Error(t, err)
+	_, err = parseRuleFolders(fs.CommaSepList{"anime=("})
+	assert.Error(t, err)
+
+	rules, err := parseRuleFolders(fs.CommaSepList{"/shows/anime/=(?i)subsplease", "kids/movies=(?i)pixar"})
+	assert.NoError(t, err)
+	f := &Fs{
+		opt: Options{
+			RegexShows:  `(?i)(S[0-9]{2}|SEASON|COMPLETE)`,
+			RegexMovies: `(?i)([0-9]{4} ?\.?)`,
+		},
+	}
+	f.setSortRules(rules, nil, nil)
+	assert.Equal(t, "shows/anime", f.category("[SubsPlease] Something S01"))
+	assert.Equal(t, "kids/movies", f.category("Pixar Film 1999"))
+	assert.Equal(t, "shows", f.category("Show S01"))
+
+	names := func(items []api.Item) (out []string) {
+		for _, item := range items {
+			out = append(out, item.ID)
+		}
+		return out
+	}
+	assert.Equal(t, []string{".folder/kids"}, names(f.subFolders("")))
+	assert.Equal(t, []string{".folder/shows/anime"}, names(f.subFolders("shows")))
+	assert.Equal(t, []string{".folder/kids/movies"}, names(f.subFolders("kids")))
+	assert.Empty(t, f.subFolders("movies"))
+
+	dir, ok := isFolderID(".folder/kids/movies")
+	assert.True(t, ok)
+	assert.Equal(t, "kids/movies", dir)
+	dir, ok = isFolderID("movies")
+	assert.True(t, ok)
+	assert.Equal(t, "movies", dir)
+	_, ok = isFolderID("ABCDEF")
+	assert.False(t, ok)
+}
+
+func TestMagnetHash(t *testing.T) {
+	const hash = "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	assert.Equal(t, hash, magnetHash("magnet:?xt=urn:btih:C12FE1C06BBA254A9DC9F519B335AA7C1367A88A&dn=x"))
+	assert.Equal(t, hash, magnetHash("magnet:?xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK"))
+	assert.Equal(t, "", magnetHash("magnet:?dn=x"))
+	assert.Equal(t, "", magnetHash("magnet:?xt=urn:btih:1234"))
+
+	defer func() { torrents = nil }()
+	torrents = []api.Item{{ID: "T1", TorrentHash: hash}}
+	assert.Equal(t, "T1", findDuplicate("magnet:?xt=urn:btih:"+hash).ID)
+	assert.Nil(t, findDuplicate("magnet:?xt=urn:btih:0000000000000000000000000000000000000000"))
+}
+
+func TestMapExistingPlaces(t *testing.T) {
+	defer func() {
+		torrents = nil
+		placements = map[string]string{}
+	}()
+	const hash = "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	torrents = []api.Item{{ID: "T1", Name: "Film.2020", TorrentHash: hash, Status: "downloaded"}}
+	f := &Fs{opt: Options{SharedFolder: "folders", OnDuplicate: duplicateMapExisting, RegexShows: `S\d\d`, RegexMovies: `(19|20)\d\d`, RegexFolders: fs.CommaSepList{`shows/anime=^\[`}}}
+	rules, err := parseRuleFolders(f.opt.RegexFolders)
+	require.NoError(t, err)
+	f.setSortRules(rules, nil, nil)
+	ctx := context.Background()
+	put := func(remote string) {
+		src := object.NewStaticObjectInfo(remote, time.Now(), -1, true, nil, nil)
+		o, err := f.putMagnet(ctx, bytes.NewBufferString(hash), src)
+		require.NoError(t, err)
+		assert.Equal(t, "T1", o.(*Object).id)
+	}
+
+	// the torrent already there shows up where it was uploaded
+	put("shows/anime/Season 1/Film.magnet")
+	assert.Equal(t, "shows/anime", f.category("Film.2020"))
+	items, _ := f.folderItems("shows/anime")
+	require.Equal(t, 1, len(items))
+	assert.Equal(t, "T1", items[0].ID)
+
+	// but not moved by uploads outside the sorting folders
+	put("Film.magnet")
+	assert.Equal(t, "shows/anime", f.category("Film.2020"))
+}
+
+func TestRecentOrder(t *testing.T) {
+	defer func() { torrents = nil }()
+	now := time.Now()
+	at := func(d time.Duration) string {
+		return now.Add(-d).Format(time.RFC3339)
+	}
+	torrents = []api.Item{
+		{ID: "old", Ended: at(30 * 24 * time.Hour)},
+		{ID: "day", Ended: at(24 * time.Hour)},
+		{ID: "hour", Ended: at(time.Hour)},
+		{ID: "bad", Ended: "not a time"},
+	}
+	f := &Fs{}
+	assert.Equal(t, []int{2, 1}, f.recentOrder(now.Add(-7*24*time.Hour)))
+	assert.Equal(t, []int{2}, f.recentOrder(now.Add(-2*time.Hour)))
+}
+
+func TestFilterSamples(t *testing.T) {
+	defer func() { quarantined = map[string]api.Item{} }()
+	f := &Fs{opt: Options{Samples: samplesQuarantine, SampleSize: 50 * fs.Mebi}}
+	files := []api.Item{
+		{Name: "Film.mkv", Size: 4 << 30, OriginalLink: "1"},
+		{Name: "film-sample.mkv", Size: 100 << 20, OriginalLink: "2"},
+		{Name: "tiny.mkv", Size: 40 << 20, OriginalLink: "3"},
+		{Name: "Extra.mkv", Size: 300 << 20, OriginalLink: "4"},
+		{Name: "Film.nfo", Size: 1, OriginalLink: "5"},
+	}
+	kept := f.filterSamples(files)
+	var names []string
+	for _, item := range kept {
+		names = append(names, item.Name)
+	}
+	assert.Equal(t, []string{"Film.mkv", "Extra.mkv", "Film.nfo"}, names)
+	samples := sampleItems()
+	assert.Equal(t, 2, len(samples))
+	assert.Equal(t, "film-sample.mkv", samples[0].Name)
+
+	single := []api.Item{{Name: "sample.mkv", Size: 1}}
+	assert.Equal(t, single, f.filterSamples(single))
+}
+
+func TestLegacy(t *testing.T) {
+	defer func() {
+		torrents, cached = nil, nil
+		legacy = map[string]bool{}
+	}()
+	f := &Fs{}
+	torrents = []api.Item{{ID: "T1", Links: []string{"a"}}}
+	cached = []api.Item{{ID: "1", OriginalLink: "a"}, {ID: "2", OriginalLink: "b"}, {ID: "3", OriginalLink: "c"}}
+	result, err := f.importDownloads(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, &importResult{Matched: 1, Legacy: 2, Imported: 2}, result)
+	assert.Equal(t, []string{"b", "c"}, legacyLinks())
+	assert.Equal(t, 2, len(f.legacyItems()))
+	assert.Empty(t, pruneCandidates(time.Time{}, true))
+
+	result, err = f.importDownloads(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Imported)
+
+	cached[1].Name = "Show.S01E01.mkv"
+	cached[2].Name = "Holiday.mp4"
+	f.opt.RegexShows = `S\d\dE\d\d`
+	f.opt.RegexMovies = `^$`
+	assert.Equal(t, 2, len(f.legacyItems()), "only sorted with sort_downloads")
+	f.opt.SortDownloads = true
+	legacyItems := f.legacyItems()
+	require.Equal(t, 1, len(legacyItems))
+	assert.Equal(t, "Holiday.mp4", legacyItems[0].Name)
+	shows, _ := f.folderItems("shows")
+	require.Equal(t, 1, len(shows))
+	assert.Equal(t, "Show.S01E01.mkv", shows[0].Name)
+	assert.Equal(t, api.ItemTypeFile, shows[0].Type)
+}
+
+func TestNameDate(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want string
+	}{
+		{"Show.2024.05.01.1080p.WEB.mkv", "2024-05-01"},
+		{"Show 2024-05-01 720p", "2024-05-01"},
+		{"Show_1999_12_31", "1999-12-31"},
+		{"Show.2024.02.31.then.2024.03.01", "2024-03-01"},
+		{"Film.2024.1080p", ""},
+		{"Show.S01E01.12024.05.01", ""},
+	} {
+		got, ok := nameDate(test.name)
+		if test.want == "" {
+			assert.False(t, ok, test.name)
+			continue
+		}
+		assert.True(t, ok, test.name)
+		assert.Equal(t, test.want, got.Format("2006-01-02"), test.name)
+	}
+}
+
+func TestDirTotals(t *testing.T) {
+	defer func() { torrents = nil }()
+	rules, err := parseRuleFolders(fs.CommaSepList{"shows/anime=(?i)subsplease"})
+	assert.NoError(t, err)
+	f := &Fs{
+		opt: Options{
+			RegexShows:  `(?i)(S[0-9]{2}|SEASON|COMPLETE)`,
+			RegexMovies: `(?i)([0-9]{4} ?\.?)`,
+		},
+	}
+	f.setSortRules(rules, nil, nil)
+	torrents = []api.Item{
+		{ID: "1", Name: "Show S01", Bytes: 100, Links: []string{"a", "b"}},
+		{ID: "2", Name: "[SubsPlease] Anime", Bytes: 10, Links: []string{"c"}},
+		{ID: "3", Name: "Film 1999", Bytes: 1000, Links: []string{"d"}},
+	}
+	check := func(item api.Item, wantFiles, wantSize int64) {
+		files, size, ok := f.dirTotals(&item)
+		assert.True(t, ok, item.ID)
+		assert.Equal(t, wantFiles, files, item.ID)
+		assert.Equal(t, wantSize, size, item.ID)
+	}
+	check(api.Item{ID: "shows"}, 3, 110)
+	check(api.Item{ID: ".folder/shows/anime"}, 1, 10)
+	check(api.Item{ID: "movies"}, 1, 1000)
+	check(api.Item{ID: "default"}, 0, 0)
+	check(torrents[0], 2, 100)
+	_, _, ok := f.dirTotals(&api.Item{ID: byHashDirID})
+	assert.False(t, ok)
+}
+
+func TestTakedowns(t *testing.T) {
+	defer func() { takedowns = map[string]takedown{} }()
+	err := fmt.Errorf("couldn't unrestrict link: %w", &api.Error{StatusCode: 503, Code: "infringing_file", ErrorCode: api.CodeInfringingFile})
+	assert.True(t, isInfringing(err))
+	assert.False(t, isInfringing(&api.Error{StatusCode: 503, Code: "file_unavailable", ErrorCode: api.CodeFileUnavailable}))
+	assert.False(t, isInfringing(nil))
+
+	assert.True(t, markTakenDown(takedown{Link: "a"}))
+	assert.False(t, markTakenDown(takedown{Link: "a", TorrentID: "1", Name: "file.mkv"}))
+	assert.True(t, isTakenDown("a"))
+	assert.False(t, isTakenDown("b"))
+	got := takenDown()
+	assert.Len(t, got, 1)
+	assert.Equal(t, "1", got[0].TorrentID)
+	assert.Equal(t, "file.mkv", got[0].Name)
+	assert.Equal(t, 1, torrentTakedowns(&api.Item{Links: []string{"a", "b"}}))
+	assert.True(t, errors.Is(takenDownError("a"), errTakenDown))
+}
+
+func TestMissCache(t *testing.T) {
+	assert.Nil(t, newMissCache(0))
+	var c *missCache
+	c.add("a:x", time.Now())
+	assert.False(t, c.missed("a:x", time.Now()))
+
+	defer atomic.StoreInt64(&lastcheck, atomic.LoadInt64(&lastcheck))
+	now := time.Now()
+	c = newMissCache(time.Minute)
+	key := lookupKey("dir/file", false, true)
+	assert.NotEqual(t, lookupKey("dir/file", true, false), key)
+	assert.False(t, c.missed(key, now))
+	c.add(key, now)
+	assert.True(t, c.missed(key, now.Add(time.Second)))
+	assert.False(t, c.missed(key, now.Add(2*time.Minute)))
+
+	c.add(key, now)
+	atomic.AddInt64(&lastcheck, 1)
+	assert.False(t, c.missed(key, now), "refresh should drop misses")
+}
+
+func TestStaging(t *testing.T) {
+	ctx := context.Background()
+	staging, err := cache.Get(ctx, ":memory:staging")
+	require.NoError(t, err)
+	f := &Fs{root: "shows", staging: staging}
+
+	data := "poster"
+	src := object.NewStaticObjectInfo("Show S01/poster.jpg", time.Now(), int64(len(data)), true, nil, nil)
+	o, err := f.putStaged(ctx, strings.NewReader(data), src)
+	require.NoError(t, err)
+	assert.Equal(t, "Show S01/poster.jpg", o.Remote())
+	assert.Equal(t, f, o.Fs())
+	_, err = staging.NewObject(ctx, "shows/Show S01/poster.jpg")
+	assert.NoError(t, err)
+
+	o, err = f.newStagedObject(ctx, "Show S01/poster.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), o.Size())
+
+	entries, err := f.mergeStaged(ctx, "Show S01", fs.DirEntries{fs.NewDir("Show S01/poster.jpg", time.Now())})
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "staged file with the same name should be left out")
+	entries, err = f.mergeStaged(ctx, "", nil)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "Show S01", entries[0].Remote())
+	entries, err = f.listStaged(ctx, "Missing")
+	assert.NoError(t, err)
+	assert.Nil(t, entries)
+}
+
+func TestOpenURLs(t *testing.T) {
+	defer func() { openURLs = map[string]openURL{} }()
+	now := time.Now()
+	f := &Fs{}
+	f.rememberURL("hoster", "https://node1/file", now)
+	_, ok := f.validURL("hoster", now)
+	assert.False(t, ok, "open_cache_time 0 should disable the cache")
+
+	f.opt.OpenCacheTime = fs.Duration(time.Minute)
+	f.rememberURL("hoster", "https://node1/file", now)
+	url, ok := f.validURL("hoster", now.Add(30*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, "https://node1/file", url)
+	_, ok = f.validURL("hoster", now.Add(2*time.Minute))
+	assert.False(t, ok)
+	_, ok = f.validURL("", now)
+	assert.False(t, ok)
+
+	forgetURL("hoster")
+	_, ok = f.validURL("hoster", now)
+	assert.False(t, ok)
+}
+
+func TestSuggestRules(t *testing.T) {
+	names := []string{
+		"[SubsPlease] Some Anime - 01 (1080p)",
+		"[SubsPlease] Some Anime - 02 (1080p)",
+		"[SubsPlease] Other Anime - 05 (1080p)",
+		"Nature Documentary Part 1",
+		"Nature.Documentary.Part.2",
+		"Nature_Documentary_Part_3",
+		"Lonely Thing",
+	}
+	got := suggestRules(names, 2, 20)
+	require.Len(t, got, 2)
+	assert.Equal(t, "default/Nature Documentary", got[0].Folder)
+	assert.Equal(t, 3, got[0].Matched)
+	assert.Equal(t, "shows/SubsPlease", got[1].Folder)
+	assert.Equal(t, 3, got[1].Matched)
+	for _, s := range got {
+		rules, err := parseRuleFolders(fs.CommaSepList{s.Rule})
+		require.NoError(t, err, s.Rule)
+		for _, example := range s.Examples {
+			assert.True(t, rules[0].re.MatchString(example), example)
+		}
+	}
+	assert.Len(t, suggestRules(names, 2, 1), 1)
+	assert.Empty(t, suggestRules(names, 4, 20))
+}
+
+func TestUnselected(t *testing.T) {
+	defer func() {
+		torrents = nil
+		torrentInfos = map[string][]api.File{}
+	}()
+	f := &Fs{}
+	torrents = []api.Item{
+		{ID: "1", Name: "Some Show S01", Status: "downloaded", TorrentHash: "abc"},
+		{ID: "2", Name: "Film", Status: "downloaded"},
+		{ID: "3", Name: "Still Going", Status: "downloading"},
+	}
+	torrentInfos = map[string][]api.File{
+		"1": {
+			{ID: 1, Path: "/Some Show S01/E01.mkv", Bytes: 100, Selected: 1},
+			{ID: 2, Path: "/Some Show S01/extras.mkv", Bytes: 50},
+		},
+		"2": {{ID: 1, Path: "/Film.mkv", Selected: 1}},
+	}
+	dirs := f.unselectedItems()
+	require.Len(t, dirs, 1)
+	assert.Equal(t, unselectedPrefix+"1", dirs[0].ID)
+	assert.Equal(t, api.ItemTypeFolder, dirs[0].Type)
+
+	files := f.unselectedFiles("1")
+	require.Len(t, files, 1)
+	assert.Equal(t, "extras.mkv", files[0].Name)
+	assert.Equal(t, int64(50), files[0].Size)
+	assert.Equal(t, "", files[0].Link)
+	assert.Nil(t, f.unselectedFiles("missing"))
+
+	// listing only uses the files already read
+	forgetTorrentInfo("1")
+	assert.NotContains(t, torrentInfos, "1")
+	assert.Empty(t, f.unselectedItems())
+	assert.Nil(t, f.unselectedFiles("1"))
+
+	// which the refresh reads for the downloaded torrents missing
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path)
+		_, _ = fmt.Fprint(w, `{"id":"1","files":[{"id":2,"path":"/Some Show S01/extras.mkv","bytes":50,"selected":0}]}`)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	f.srv = rest.NewClient(http.DefaultClient).SetRoot(server.URL).SetErrorHandler(errorHandler)
+	f.pacer = fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(time.Millisecond)))
+	f.accounts = newAccounts("", nil)
+	f.fetchTorrentInfos(ctx, torrents)
+	assert.Empty(t, calls, "only in folders mode")
+	f.opt.SharedFolder = "folders"
+	f.fetchTorrentInfos(ctx, torrents)
+	assert.Empty(t, calls, "only with show_unselected")
+	f.opt.ShowUnselected = true
+	f.fetchTorrentInfos(ctx, torrents)
+	assert.Equal(t, []string{"/torrents/info/1"}, calls)
+	assert.Len(t, f.unselectedItems(), 1)
+
+	// the files of deleted torrents are forgotten
+	f.fetchTorrentInfos(ctx, torrents[1:])
+	assert.NotContains(t, torrentInfos, "1")
+	assert.Contains(t, torrentInfos, "2")
+}
+
+func TestPurgeTorrent(t *testing.T) {
+	defer func() {
+		torrents = nil
+		removed = map[string]bool{}
+	}()
+	var calls []string
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	f := &Fs{
+		srv:      rest.NewClient(http.DefaultClient).SetRoot(server.URL).SetErrorHandler(errorHandler),
+		pacer:    fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(time.Millisecond), pacer.MaxSleep(time.Millisecond))),
+		accounts: newAccounts("", nil),
+	}
+	f.dirCache = dircache.New("", rootID, f)
+	require.NoError(t, f.dirCache.FindRoot(ctx, false))
+	f.dirCache.Put("Film", "T1")
+	f.dirCache.Put("shows", "shows")
+	torrents = []api.Item{{ID: "T1", Name: "Film"}}
+
+	err := f.Rmdir(ctx, "shows")
+	assert.Error(t, err, "not a torrent")
+	assert.Empty(t, calls)
+
+	require.NoError(t, f.Purge(ctx, "Film"))
+	assert.Equal(t, []string{"DELETE /torrents/delete/T1"}, calls)
+	assert.True(t, removed["T1"], "not taken as an orphan")
+
+	status = http.StatusNotFound
+	f.dirCache.Put("Film", "T1")
+	assert.Error(t, f.Purge(ctx, "Film"))
+}
+
+func TestMimeTypeFromName(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want string
+	}{
+		{"Show S01E01.mkv", "video/x-matroska"},
+		{"dir/Film.MP4", "video/mp4"},
+		{"Broadcast.ts", "video/mp2t"},
+		{"Film.en.srt", "application/x-subrip"},
+		{"README", ""},
+		{"file.unknown-extension", ""},
+	} {
+		assert.Equal(t, test.want, mimeTypeFromName(test.name), test.name)
+	}
+	assert.Contains(t, mimeTypeFromName("index.html"), "text/html")
+
+	o := &Object{remote: "shows/Show S01/E01.mkv"}
+	require.NoError(t, o.setMetaData(&api.Item{Type: api.ItemTypeFile}))
+	assert.Equal(t, "video/x-matroska", o.mimeType)
+	require.NoError(t, o.setMetaData(&api.Item{Type: api.ItemTypeFile, MimeType: "video/webm"}))
+	assert.Equal(t, "video/webm", o.mimeType)
+}
+
+func TestObjectID(t *testing.T) {
+	o := &Object{remote: "shows/Show S01/E01.mkv", id: "DL1", TorrentHash: "ABC123", fileID: 1, originalLink: "https://host/1"}
+	assert.Equal(t, "abc123/1", o.ID())
+	moved := *o
+	moved.remote = "shows/anime/Show S01/E01.mkv"
+	moved.id = "DL2"
+	assert.Equal(t, o.ID(), moved.ID(), "ID should survive re-sorting and new links")
+
+	// the name changes when renamed or to resolve a conflict
+	for _, remote := range []string{"shows/Show S01/Episode 1.mkv", "shows/Show S01/E01 (2).mkv"} {
+		renamed := &Object{remote: remote}
+		require.NoError(t, renamed.setMetaData(&api.Item{Type: api.ItemTypeFile, ID: "DL5", TorrentHash: "abc123", FileID: 1, OriginalLink: "https://host/1"}))
+		assert.Equal(t, o.ID(), renamed.ID(), remote)
+	}
+	other := *o
+	other.fileID = 2
+	assert.NotEqual(t, o.ID(), other.ID(), "other files of the torrent")
+
+	o = &Object{remote: "Film.mkv", id: "DL3", originalLink: "https://host/2"}
+	assert.Equal(t, "https://host/2", o.ID())
+	o = &Object{remote: "Film.mkv", id: "DL4"}
+	assert.Equal(t, "DL4", o.ID())
+	o = &Object{remote: "extras.mkv", id: unselectedPrefix + "T1/2", TorrentHash: "abc123"}
+	assert.Equal(t, unselectedPrefix+"T1/2", o.ID(), "files which aren't selected")
+}
+
+func TestTorrentFileIDs(t *testing.T) {
+	defer func() {
+		torrentInfos = map[string][]api.File{}
+		fileIDs = map[string][]int64{}
+	}()
+	ctx := context.Background()
+	f := &Fs{}
+	torrentInfos = map[string][]api.File{
+		"T1": {{ID: 1, Selected: 1}, {ID: 2}, {ID: 3, Selected: 1}},
+		"T2": {{ID: 1, Selected: 1}, {ID: 2, Selected: 1}, {ID: 3, Selected: 1}},
+	}
+	before := api.Item{ID: "T1", TorrentHash: "ABC", Links: []string{"https://host/1", "https://host/3"}}
+	assert.Equal(t, []int64{1, 3}, f.torrentFileIDs(ctx, &before))
+	// selecting file 2 adds the torrent again moving file 3 to the third link
+	after := api.Item{ID: "T2", TorrentHash: "ABC", Links: []string{"https://host/1", "https://host/2", "https://host/3"}}
+	ids := f.torrentFileIDs(ctx, &after)
+	assert.Equal(t, []int64{1, 2, 3}, ids)
+	assert.Equal(t, modTimeKey(before.TorrentHash, 3, ""), modTimeKey(after.TorrentHash, ids[2], ""), "key kept by file 3")
+
+	short := api.Item{ID: "T1", Links: []string{"https://host/1"}}
+	assert.Nil(t, f.torrentFileIDs(ctx, &short), "links don't match the selection")
+	assert.Nil(t, f.torrentFileIDs(ctx, &api.Item{ID: "T3"}), "no links")
+
+	pruneFileIDs([]api.Item{after})
+	assert.Equal(t, map[string][]int64{"T2": {1, 2, 3}}, copyFileIDs())
+}
+
+func TestScopedRefresh(t *testing.T) {
+	old := atomic.LoadInt64(&lastcheck)
+	defer atomic.StoreInt64(&lastcheck, old)
+	atomic.StoreInt64(&lastcheck, time.Now().Unix())
+	assert.False(t, refreshIsDue())
+	forceRefresh()
+	assert.True(t, refreshIsDue())
+
+	torrent := api.Item{ID: "1", Status: "downloading", Progress: 50, Bytes: 100, Links: []string{"a"}}
+	updateTorrent(&torrent, &api.Item{Status: "downloaded", Progress: 100, Links: []string{"a", "b"}})
+	assert.Equal(t, "downloaded", torrent.Status)
+	assert.Equal(t, float64(100), torrent.Progress)
+	assert.Equal(t, int64(100), torrent.Bytes, "missing size should be kept")
+	assert.Equal(t, []string{"a", "b"}, torrent.Links)
+}
+
+func TestRefreshJitter(t *testing.T) {
+	oldCheck, oldJitter := atomic.LoadInt64(&lastcheck), atomic.LoadInt64(&refreshJitter)
+	defer func() {
+		atomic.StoreInt64(&lastcheck, oldCheck)
+		atomic.StoreInt64(&refreshJitter, oldJitter)
+	}()
+	assert.Equal(t, time.Duration(0), jitter(0))
+	for i := 0; i < 100; i++ {
+		d := jitter(time.Minute)
+		assert.True(t, d >= 0 && d < time.Minute, d)
+	}
+
+	f := &Fs{opt: Options{RefreshJitter: fs.Duration(time.Hour)}}
+	f.scheduleRefresh()
+	j := atomic.LoadInt64(&refreshJitter)
+	assert.True(t, j >= 0 && j < 3600, j)
+	atomic.StoreInt64(&refreshJitter, 600)
+	atomic.StoreInt64(&lastcheck, time.Now().Unix()-interval-60)
+	assert.False(t, refreshIsDue(), "jitter should delay the refresh")
+	forceRefresh()
+	assert.True(t, refreshIsDue(), "forced refreshes shouldn't wait for the jitter")
+
+	assert.Equal(t, -1, f.repairBudget())
+	f.opt.MaxRepairs = 3
+	assert.Equal(t, 3, f.repairBudget())
+}
+
+func TestNewProxyClient(t *testing.T) {
+	ctx := context.Background()
+	proxyOf := func(client *http.Client) *url.URL {
+		transport := client.Transport.(*fshttp.Transport)
+		req, _ := http.NewRequest("GET", "https://example.com/", nil)
+		u, err := transport.Transport.Proxy(req)
+		require.NoError(t, err)
+		return u
+	}
+	client, err := newProxyClient(ctx, "socks5://127.0.0.1:1080")
+	require.NoError(t, err)
+	assert.Equal(t, "socks5://127.0.0.1:1080", proxyOf(client).String())
+
+	client, err = newProxyClient(ctx, proxyDirect)
+	require.NoError(t, err)
+	assert.Nil(t, client.Transport.(*fshttp.Transport).Transport.Proxy)
+
+	_, err = newProxyClient(ctx, "ftp://proxy")
+	assert.Error(t, err)
+	client, err = newProxyClient(ctx, "")
+	require.NoError(t, err)
+	assert.NotNil(t, client)
+}
+
+func TestPinnedNames(t *testing.T) {
+	defer func() { names = map[string]string{} }()
+	list := []api.Item{
+		{Name: "Some.Film.1999.2160p", TorrentHash: "ABC"},
+		{Name: "Other.Film.2001", TorrentHash: "def"},
+	}
+	applyNames(list)
+	assert.Equal(t, "Some.Film.1999.2160p", list[0].Name)
+
+	pinName("abc", "Some.Film.1999.1080p")
+	applyNames(list)
+	assert.Equal(t, "Some.Film.1999.1080p", list[0].Name)
+	assert.Equal(t, "Other.Film.2001", list[1].Name)
+	assert.Equal(t, map[string]string{"abc": "Some.Film.1999.1080p"}, copyNames())
+
+	unpinName("ABC")
+	assert.Empty(t, copyNames())
+}
+
+func TestCarryOver(t *testing.T) {
+	defer func() {
+		names = map[string]string{}
+		tags = map[string][]string{}
+		modTimes = map[string]int64{}
+		opened = map[string]int64{}
+		accessed = map[string]int64{}
+		plays = map[string]int64{}
+		finished = map[string]int64{}
+	}()
+	old := &api.Item{ID: "T1", Name: "Some.Show.S01.1080p", TorrentHash: "abc"}
+	torrent := &api.Item{ID: "T2", Name: "Some.Show.S01.2160p", TorrentHash: "def"}
+	oldFiles := []api.File{
+		{ID: 1, Path: "/Some.Show.S01/Sample.mkv", Selected: 1},
+		{ID: 2, Path: "/Some.Show.S01/Some.Show.S01E01.1080p.mkv", Selected: 1},
+		{ID: 3, Path: "/Some.Show.S01/Some.Show.S01E02.1080p.mkv", Selected: 1},
+		{ID: 4, Path: "/Some.Show.S01/Extras.mkv", Selected: 1},
+	}
+	newFiles := []api.File{
+		{ID: 1, Path: "/Some.Show.S01/Some.Show.S01E01.2160p.mkv", Selected: 1},
+		{ID: 2, Path: "/Some.Show.S01/Some.Show.S01E02.2160p.mkv", Selected: 1},
+		{ID: 3, Path: "/Some.Show.S01/sample.MP4", Selected: 1},
+		{ID: 4, Path: "/Some.Show.S01/Other.mkv", Selected: 1},
+		{ID: 5, Path: "/Some.Show.S01/Extras.mkv"},
+	}
+	files := matchFiles(oldFiles, newFiles)
+	assert.Equal(t, map[int64]int64{1: 3, 2: 1, 3: 2}, files, "by name then episode, not position")
+	assert.Empty(t, matchFiles(oldFiles[1:3], []api.File{
+		{ID: 1, Path: "/S01E01.mkv", Selected: 1},
+		{ID: 2, Path: "/S01E01.sample.mkv", Selected: 1},
+	}), "more than one file with the episode")
+
+	editTags("abc", []string{"keep"}, nil)
+	modTimes["abc/2"] = 100
+	modTimes["abc/4"] = 150
+	modTimes["other/2"] = 200
+	opened["T1"] = 300
+	accessed["abc/2"] = 300
+	plays["abc/2"] = 2
+	plays["def/1"] = 1
+	finished["abc/2"] = 1
+
+	carryOver(old, torrent, files)
+	assert.Equal(t, map[string]string{"def": "Some.Show.S01.1080p"}, copyNames())
+	assert.Equal(t, []string{"keep"}, torrentTags("def"))
+	assert.Equal(t, map[string]int64{"def/1": 100, "abc/4": 150, "other/2": 200}, modTimes, "unmatched files left")
+	assert.Equal(t, int64(300), opened["T2"])
+	assert.Equal(t, map[string]int64{"def/1": 300}, accessed)
+	assert.Equal(t, map[string]int64{"def/1": 3}, plays)
+	assert.Equal(t, map[string]int64{"def/1": 1}, finished)
+
+	// without matched files only what is kept about the torrent moves
+	modTimes = map[string]int64{"def/1": 100}
+	carryOver(torrent, &api.Item{ID: "T3", TorrentHash: "ghi"}, nil)
+	assert.Equal(t, map[string]int64{"def/1": 100}, modTimes)
+	assert.Equal(t, []string{"keep"}, torrentTags("ghi"))
+}
+
+func TestLinkExpiry(t *testing.T) {
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "2022-04-30T10:00:00.000Z", linkGenerated(&api.Item{Link: "x", Generated: "2022-04-30T10:00:00.000Z"}, now))
+	assert.Equal(t, "2022-05-01T12:00:00.000Z", linkGenerated(&api.Item{Link: "x"}, now))
+	assert.Equal(t, "", linkGenerated(&api.Item{}, now))
+
+	f := &Fs{opt: Options{LinkValidity: fs.Duration(24 * time.Hour)}}
+	o := &Object{fs: f, generated: parseGenerated("2022-04-30T10:00:00.000Z")}
+	assert.Equal(t, time.Date(2022, 5, 1, 10, 0, 0, 0, time.UTC), o.linkExpiresAt())
+	assert.True(t, o.linkExpired(now))
+	assert.False(t, o.linkExpired(now.Add(-3*time.Hour)))
+
+	f.opt.LinkValidity = 0
+	assert.True(t, o.linkExpiresAt().IsZero())
+	assert.False(t, o.linkExpired(now))
+	o = &Object{fs: &Fs{opt: Options{LinkValidity: fs.Duration(time.Hour)}}, generated: parseGenerated("bad")}
+	assert.False(t, o.linkExpired(now), "unknown generation time never expires")
+}
+
+func TestFolderPaths(t *testing.T) {
+	for _, test := range []struct{ in, clean, parent string }{
+		{"", "", ""},
+		{"/", "", ""},
+		{"shows", "shows", ""},
+		{"/shows/anime/", "shows/anime", "shows"},
+		{"shows//anime/../kids", "shows/kids", "shows"},
+		{"a/b/c", "a/b/c", "a/b"},
+	} {
+		assert.Equal(t, test.clean, cleanDir(test.in), test.in)
+		assert.Equal(t, test.parent, parentDir(test.in), test.in)
+	}
+	assert.True(t, isInDir("shows/anime", "shows"))
+	assert.True(t, isInDir("shows/", "/shows"))
+	assert.True(t, isInDir("movies", ""))
+	assert.False(t, isInDir("showsextra", "shows"))
+	assert.False(t, isInDir("shows", "shows/anime"))
+}
+
+func TestDownloadSlots(t *testing.T) {
+	ctx := context.Background()
+	s := newDownloadSlots(2, 50*time.Millisecond)
+	require.NoError(t, s.acquire(ctx))
+	require.NoError(t, s.acquire(ctx))
+	err := s.acquire(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "all 2 download slots in use")
+
+	done := make(chan error)
+	s.timeout = 0
+	go func() { done <- s.acquire(ctx) }()
+	for s.stats().Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	r := &slotReader{ReadCloser: ioutil.NopCloser(strings.NewReader("")), slots: s}
+	require.NoError(t, r.Close())
+	require.NoError(t, r.Close())
+	require.NoError(t, <-done)
+	assert.Equal(t, &slotStats{Open: 2, Queued: 0, Peak: 2, Limit: 2}, s.stats())
+
+	s = newDownloadSlots(0, 0)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.acquire(ctx))
+	}
+	s.release()
+	assert.Equal(t, &slotStats{Open: 4, Peak: 5}, s.stats())
+}
+
+func TestStatusSweep(t *testing.T) {
+	old := atomic.LoadInt64(&lastSweep)
+	defer atomic.StoreInt64(&lastSweep, old)
+	now := time.Now()
+	f := &Fs{}
+	assert.False(t, f.sweepIsDue(now), "off by default")
+	f.opt.StatusSweep = fs.Duration(5 * time.Minute)
+	markSwept(now.Add(-4 * time.Minute))
+	assert.False(t, f.sweepIsDue(now))
+	markSwept(now.Add(-5 * time.Minute))
+	assert.True(t, f.sweepIsDue(now))
+
+	before := []api.Item{
+		{ID: "1", Name: "A", Status: "downloaded"},
+		{ID: "2", Name: "B", Status: "downloaded"},
+		{ID: "3", Name: "C", Status: "downloading"},
+		{ID: "4", Name: "D", Status: "dead"},
+	}
+	after := []api.Item{
+		{ID: "1", Name: "A", Status: "dead"},
+		{ID: "2", Name: "B", Status: "downloaded"},
+		{ID: "3", Name: "C", Status: "virus"},
+		{ID: "4", Name: "D", Status: "dead"},
+		{ID: "5", Name: "E", Status: "error"},
+	}
+	assert.Nil(t, statusChanges(nil, after), "nothing known before the first listing")
+	assert.Equal(t, []statusChange{
+		{ID: "1", Name: "A", From: "downloaded", To: "dead"},
+		{ID: "3", Name: "C", From: "downloading", To: "virus"},
+	}, statusChanges(before, after))
+}
+
+func TestSearch(t *testing.T) {
+	defer func() {
+		torrents, cached = nil, nil
+		indexCached()
+	}()
+	f := &Fs{opt: Options{SharedFolder: "folders", RegexShows: `S\d\d`, RegexMovies: `^$`}}
+	torrents = []api.Item{
+		{ID: "T1", Name: "The.Legend.of.Zelda.Breath.of.the.Wild.OST", Bytes: 100, Links: []string{"a"}},
+		{ID: "T2", Name: "Nature.Docs.S01", Bytes: 200, Links: []string{"b", "c"}},
+	}
+	cached = []api.Item{
+		{ID: "1", Name: "01 - Main Theme.flac", OriginalLink: "a", Size: 100},
+		{ID: "2", Name: "Nature.Docs.S01E01.Breath.of.the.Wild.mkv", OriginalLink: "b", Size: 120},
+		{ID: "3", Name: "Nature.Docs.S01E02.Oceans.mkv", OriginalLink: "c", Size: 80},
+	}
+	indexCached()
+
+	out, err := f.search("breath of the WILD", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []searchResult{
+		{Path: "default/The.Legend.of.Zelda.Breath.of.the.Wild.OST", Type: api.ItemTypeFolder, TorrentID: "T1", Size: 100},
+		{Path: "shows/Nature.Docs.S01/Nature.Docs.S01E01.Breath.of.the.Wild.mkv", Type: api.ItemTypeFile, TorrentID: "T2", Size: 120},
+	}, out)
+
+	out, err = f.search("ocean", 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(out))
+	assert.Equal(t, "shows/Nature.Docs.S01/Nature.Docs.S01E02.Oceans.mkv", out[0].Path)
+
+	out, err = f.search("wild", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(out))
+
+	_, err = f.search(" ... ", 10)
+	assert.Error(t, err)
+}
+
+func TestDryRun(t *testing.T) {
+	defer func() { torrents = nil }()
+	ctx, ci := fs.AddConfig(context.Background())
+	ci.DryRun = true
+	// no connection so anything which tried to call the API would panic
+	f := &Fs{opt: Options{MaxTorrents: 1}}
+	torrents = []api.Item{{ID: "1", Name: "A"}, {ID: "2", Name: "B"}}
+	f.evictTorrents(ctx)
+	assert.Equal(t, 2, len(torrents))
+
+	orphans = []api.Item{{Name: "C", TorrentHash: "abc"}}
+	defer func() { orphans = nil }()
+	out, err := f.reacquire(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []reacquireResult{{Name: "C", Hash: "abc"}}, out)
+	assert.Equal(t, 1, len(orphans))
+}
+
+func TestChunkedReader(t *testing.T) {
+	defer func() { openURLs = map[string]openURL{} }()
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	var unrestricts, oldRequests int32
+	mux := http.NewServeMux()
+	// the first link expires after its first request
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&oldRequests, 1) > 1 {
+			http.Error(w, "link expired", http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(content))
+	})
+	var server *httptest.Server
+	mux.HandleFunc("/unrestrict/link", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&unrestricts, 1)
+		_, _ = fmt.Fprintf(w, `{"download":%q,"filesize":%d}`, server.URL+"/new", len(content))
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	f := &Fs{
+		srv:      rest.NewClient(http.DefaultClient).SetRoot(server.URL),
+		pacer:    fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(time.Millisecond))),
+		accounts: newAccounts("", nil),
+	}
+	f.dl = f.srv
+	o := &Object{fs: f, remote: "file", size: int64(len(content)), url: server.URL + "/old", originalLink: "hoster"}
+
+	r, err := newChunkedReader(ctx, o, nil, 10)
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, content, got)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&unrestricts), "should unrestrict once when the link expires")
+
+	r, err = newChunkedReader(ctx, o, []fs.OpenOption{&fs.RangeOption{Start: 5, End: 24}}, 10)
+	require.NoError(t, err)
+	got, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, content[5:25], got)
+}
+
+func TestFileCounts(t *testing.T) {
+	defer func() {
+		plays = map[string]int64{}
+		finished = map[string]int64{}
+	}()
+	countPlay("a", 0)
+	countPlay("a", 0)
+	countPlay("a", 100)
+	countPlay("b", 0)
+	countPlay("", 0)
+
+	r := &finishCounter{ReadCloser: ioutil.NopCloser(strings.NewReader("6789")), fileKey: "b", offset: 6, size: 10}
+	got, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "6789", string(got))
+	_, _ = r.Read(make([]byte, 1))
+	r = &finishCounter{ReadCloser: ioutil.NopCloser(strings.NewReader("01")), fileKey: "c", size: 10}
+	_, _ = ioutil.ReadAll(r)
+
+	assert.Equal(t, []fileCount{
+		{File: "a", Plays: 2},
+		{File: "b", Plays: 1, Finished: 1},
+	}, fileCounts(10))
+	assert.Len(t, fileCounts(1), 1)
+}
+
+func TestRegexSorter(t *testing.T) {
+	var entries fs.CommaSepList
+	require.NoError(t, entries.Set(` shows/anime/ =(?i)\[SubsPlease\],"kids=(?i)pixar|disney, inc",shows/anime/old=(?i)subsplease`))
+	folders, err := parseRuleFolders(entries)
+	require.NoError(t, err)
+	require.Len(t, folders, 3)
+	assert.Equal(t, "shows/anime", folders[0].path, "spaces and slashes around the path are trimmed")
+
+	sorter, err := newRegexSorter(folders, `S\d\dE\d\d`, `(19|20)\d\d`)
+	require.NoError(t, err)
+	var _ torrentSorter = sorter
+	for _, test := range []struct {
+		name   string
+		folder string
+		ok     bool
+	}{
+		{"[SubsPlease] Some Anime - 01", "shows/anime", true},
+		{"[subsplease] Some Anime - 02", "shows/anime", true},     // first matching rule wins
+		{"SubsPlease Some Anime S01E01", "shows/anime/old", true}, // regex_folders before regex_shows
+		{"Toy.Story.1995.Pixar", "kids", true},                    // regex_folders before regex_movies
+		{"Disney, Inc. Special", "kids", true},                    // comma inside a quoted entry
+		{"Some.Show.S01E01.2019", "shows", true},                  // regex_shows before regex_movies
+		{"Some.Film.2019", "movies", true},
+		{"Holiday Photos", "", false},
+		{"[SubsPlease] ", "shows/anime", true},
+	} {
+		folder, ok := sorter.sort(test.name)
+		assert.Equal(t, test.folder, folder, test.name)
+		assert.Equal(t, test.ok, ok, test.name)
+	}
+
+	_, err = newRegexSorter(nil, "(", "")
+	assert.ErrorContains(t, err, "regex_shows")
+	_, err = newRegexSorter(nil, "", "[")
+	assert.ErrorContains(t, err, "regex_movies")
+}
+
+func TestManifest(t *testing.T) {
+	defer func() {
+		torrents, cached = nil, nil
+		indexCached()
+		lastManifest = nil
+	}()
+	fileName := filepath.Join(t.TempDir(), "library.json")
+	f := &Fs{opt: Options{SharedFolder: "folders", RegexShows: `S\d\d`, RegexMovies: `^$`, ManifestFile: fileName, LinkValidity: fs.Duration(time.Hour)}}
+	torrents = []api.Item{{ID: "T1", Name: "Show.S01", TorrentHash: "abc", Links: []string{"a", "b"}}}
+	cached = []api.Item{
+		{ID: "1", Name: "E01.mkv", OriginalLink: "a", Size: 10, Generated: "2022-05-01T10:00:00.000Z"},
+	}
+	indexCached()
+	f.writeManifest()
+	data, err := ioutil.ReadFile(fileName)
+	require.NoError(t, err)
+	var got map[string]manifestEntry
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, map[string]manifestEntry{
+		"shows/Show.S01/E01.mkv": {Size: 10, Hash: "abc", TorrentID: "T1", LinkExpires: "2022-05-01T11:00:00Z"},
+	}, got)
+
+	// unchanged so not written again
+	require.NoError(t, os.Remove(fileName))
+	f.writeManifest()
+	_, err = os.Stat(fileName)
+	assert.True(t, os.IsNotExist(err))
+
+	cached = append(cached, api.Item{ID: "2", Name: "E02.mkv", OriginalLink: "b", Size: 20})
+	indexCached()
+	f.writeManifest()
+	data, err = ioutil.ReadFile(fileName)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "E02.mkv")
+}
+
+func TestRefreshLink(t *testing.T) {
+	defer func() {
+		cached = nil
+		indexCached()
+		sizeChanges = nil
+	}()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"download":"https://node2/file","filesize":10}`)
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	f := &Fs{
+		srv:      rest.NewClient(http.DefaultClient).SetRoot(server.URL),
+		pacer:    fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(time.Millisecond))),
+		accounts: newAccounts("", nil),
+	}
+	cached = []api.Item{{ID: "1", Name: "file", OriginalLink: "hoster", Link: "https://node1/file", Size: 10}}
+	indexCached()
+
+	o := &Object{fs: f, remote: "file", size: 10, url: "https://node1/file", originalLink: "hoster"}
+	r := f.refreshLink(ctx, o)
+	assert.Equal(t, linkRefreshResult{Path: "file", Link: "https://node2/file"}, r)
+	assert.Equal(t, "https://node2/file", o.url)
+	assert.Equal(t, "https://node2/file", cached[0].Link)
+	assert.False(t, parseGenerated(cached[0].Generated).IsZero())
+
+	o.size = 20
+	r = f.refreshLink(ctx, o)
+	assert.Contains(t, r.Error, "size")
+	r = f.refreshLink(ctx, &Object{fs: f, remote: "other"})
+	assert.NotEmpty(t, r.Error)
+}
+
+func TestRootLayout(t *testing.T) {
+	defer func() {
+		torrents, cached = nil, nil
+		indexCached()
+	}()
+	f := &Fs{}
+	f.opt.SharedFolder = "folders"
+	f.opt.RegexShows = `S\d\dE\d\d`
+	f.opt.RegexMovies = `^$`
+	torrents = []api.Item{{ID: "T1", Name: "Other.Show.S02E01", Links: []string{"a"}}}
+	cached = []api.Item{
+		{ID: "1", OriginalLink: "a", Name: "Other.Show.S02E01.mkv"},
+		{ID: "2", OriginalLink: "b", Name: "Show.S01E01.mkv"},
+		{ID: "3", OriginalLink: "c", Name: "Holiday.mp4"},
+		{ID: "4", OriginalLink: "b", Name: "Show.S01E01.mkv"},
+	}
+	indexCached()
+
+	downloads := hosterDownloads()
+	require.Equal(t, 2, len(downloads), "torrent links and repeated links are left out")
+	assert.Equal(t, "2", downloads[0].ID)
+	assert.Equal(t, "3", downloads[1].ID)
+	assert.Equal(t, api.ItemTypeFile, downloads[0].Type)
+
+	items, _ := f.folderItems("default")
+	assert.Empty(t, items, "torrents only by default")
+	f.opt.RootLayout = layoutMerged
+	items, _ = f.folderItems("default")
+	require.Equal(t, 1, len(items))
+	assert.Equal(t, "Holiday.mp4", items[0].Name)
+	assert.Equal(t, "shows/Other.Show.S02E01", f.torrentPath(&torrents[0]))
+
+	f.opt.RootLayout = layoutSplit
+	root := splitItems()
+	require.Equal(t, 2, len(root))
+	assert.Equal(t, "torrents", root[0].Name)
+	assert.Equal(t, splitDownloadsDirID, root[1].ID)
+	assert.Equal(t, "torrents/shows/Other.Show.S02E01", f.torrentPath(&torrents[0]))
+}
+
+func TestFolderLocks(t *testing.T) {
+	defer func() {
+		torrents = nil
+		lockedFolders, frozen = map[string]bool{}, map[string]string{}
+	}()
+	f := &Fs{}
+	f.opt.SharedFolder = "folders"
+	f.opt.RegexShows = `S\d\dE\d\d`
+	f.opt.RegexMovies = `(19|20)\d\d`
+	torrents = []api.Item{
+		{ID: "T1", Name: "Some.Show.S01E01"},
+		{ID: "T2", Name: "Some.Film.2019"},
+	}
+
+	assert.Equal(t, 1, f.lockFolder("shows"))
+	assert.Equal(t, []string{"shows"}, lockedList())
+	assert.True(t, f.torrentLocked("Some.Show.S01E01"))
+	assert.False(t, f.torrentLocked("Some.Film.2019"))
+	assert.True(t, f.dirLocked("T1"))
+	assert.True(t, f.dirLocked(byHashPrefix+"T1"))
+	assert.True(t, f.dirLocked("shows"))
+	assert.False(t, f.dirLocked("movies"))
+
+	f.opt.RegexShows = `^$`
+	assert.Equal(t, "shows", f.category("Some.Show.S01E01"), "pinned while locked")
+	assert.False(t, unlockFolder("movies"))
+	assert.True(t, unlockFolder("shows"))
+	assert.Equal(t, "default", f.category("Some.Show.S01E01"))
+	assert.False(t, f.dirLocked("T1"))
+	assert.Empty(t, lockedList())
+}
+
+func TestStateRejected(t *testing.T) {
+	defer func() {
+		stateLoaded = sync.Once{}
+		torrents, cached, names = nil, nil, map[string]string{}
+		indexCached()
+	}()
+	dir := t.TempDir()
+	f := &Fs{}
+	f.opt.StateFile = filepath.Join(dir, "state.json")
+
+	hash := strings.Repeat("ab", 20)
+	s := &librarySnapshot{
+		Version:  snapshotVersion,
+		Torrents: []api.Item{{ID: "T1"}, {}, {ID: "T1"}},
+		Names:    map[string]string{hash: "Some.Film", "abc": "Bogus", strings.Repeat("cd", 20): ""},
+	}
+	require.NoError(t, writeSnapshot(f.opt.StateFile, s))
+	require.NoError(t, f.loadState())
+	assert.Equal(t, 1, len(torrents))
+	assert.Equal(t, map[string]string{hash: "Some.Film"}, copyNames())
+	files, err := filepath.Glob(f.opt.StateFile + rejectedSuffix + ".*")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files))
+	data, err := ioutil.ReadFile(files[0])
+	require.NoError(t, err)
+	var rejected []rejectedEntry
+	require.NoError(t, json.Unmarshal(data, &rejected))
+	assert.Equal(t, 4, len(rejected))
+
+	// a state_file cut short is moved out of the way whole
+	data, err = ioutil.ReadFile(f.opt.StateFile)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(f.opt.StateFile, data[:len(data)/2], 0600))
+	require.NoError(t, f.loadState())
+	// without overwriting the parts rejected before
+	files, err = filepath.Glob(f.opt.StateFile + rejectedSuffix + ".*")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(files))
+	rejectedData, err := ioutil.ReadFile(files[1])
+	require.NoError(t, err)
+	assert.Equal(t, data[:len(data)/2], rejectedData)
+}
+
+func TestConditionalVerify(t *testing.T) {
+	defer func() { validators = map[string]validator{} }()
+	var full, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&full, 1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", "10")
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	f := &Fs{
+		srv:   rest.NewClient(http.DefaultClient).SetRoot(server.URL),
+		pacer: fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(time.Millisecond))),
+	}
+	f.dl = f.srv
+	o := &Object{fs: f, remote: "file", size: 10, url: server.URL + "/file"}
+	for i := 0; i < 3; i++ {
+		r := f.verifyObject(ctx, o)
+		assert.Equal(t, verifyOK, r.Status)
+		assert.Equal(t, int64(10), r.RemoteSize)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&full))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&notModified))
+
+	o.size = 20
+	assert.Equal(t, verifySizeMismatch, f.verifyObject(ctx, o).Status)
+
+	resp := &http.Response{StatusCode: http.StatusPartialContent, Header: http.Header{}}
+	resp.Header.Set("Content-Range", "bytes 0-99/1234")
+	assert.Equal(t, int64(1234), responseSize(resp))
+}
+
+func TestPathIndex(t *testing.T) {
+	var x *pathIndex
+	x.put("dir", nil)
+	info, listed := x.get("dir", "a")
+	assert.Nil(t, info)
+	assert.False(t, listed)
+
+	x = newPathIndex()
+	x.put("dir", map[string]api.Item{"a": {ID: "1", Name: "A"}})
+	info, listed = x.get("dir", "a")
+	require.NotNil(t, info)
+	assert.True(t, listed)
+	assert.Equal(t, "1", info.ID)
+	info.ID = "changed"
+	info, _ = x.get("dir", "a")
+	assert.Equal(t, "1", info.ID, "items are copies")
+
+	info, listed = x.get("dir", "b")
+	assert.Nil(t, info)
+	assert.True(t, listed, "known not to exist")
+	_, listed = x.get("other", "a")
+	assert.False(t, listed)
+
+	old := atomic.LoadInt64(&lastcheck)
+	defer atomic.StoreInt64(&lastcheck, old)
+	atomic.StoreInt64(&lastcheck, old+1)
+	_, listed = x.get("dir", "a")
+	assert.False(t, listed, "dropped after a refresh")
+}
+
+func TestObjectState(t *testing.T) {
+	defer func() {
+		torrents, brokenTorrents = nil, map[string]brokenTorrent{}
+		takedowns = map[string]takedown{}
+	}()
+	now := time.Now()
+	f := &Fs{}
+	f.opt.LinkValidity = fs.Duration(time.Hour)
+	torrents = []api.Item{
+		{ID: "T1", Status: "downloaded"},
+		{ID: "T2", Status: "downloading"},
+		{ID: "T3", Status: "dead"},
+	}
+	o := &Object{fs: f, ParentID: "T1", url: "https://x/1", size: 1, originalLink: "https://hoster/1", generated: now}
+	assert.Equal(t, stateOK, o.objectState(now))
+	assert.Equal(t, stateExpired, o.objectState(now.Add(2*time.Hour)))
+	o.url = ""
+	assert.Equal(t, statePending, o.objectState(now))
+	o.url = "https://x/1"
+	o.ParentID = "T2"
+	assert.Equal(t, statePending, o.objectState(now))
+	o.ParentID = "T3"
+	assert.Equal(t, stateBroken, o.objectState(now))
+	o.ParentID = "T1"
+	markBroken("T1")
+	assert.Equal(t, stateBroken, o.objectState(now))
+	markTakenDown(takedown{Link: o.originalLink})
+	assert.Equal(t, stateTakenDown, o.objectState(now))
+}
+
+func TestRepairBackoff(t *testing.T) {
+	defer func() { brokenTorrents = map[string]brokenTorrent{} }()
+	now := time.Now()
+	assert.True(t, markBroken("T1"))
+	assert.False(t, markBroken("T1"))
+	assert.True(t, repairDue("T1", now))
+
+	assert.Equal(t, minRepairBackoff, repairFailed("T1", now))
+	assert.False(t, repairDue("T1", now))
+	assert.True(t, repairDue("T1", now.Add(minRepairBackoff)))
+	assert.Equal(t, 2*minRepairBackoff, repairFailed("T1", now))
+	for i := 0; i < 10; i++ {
+		repairFailed("T1", now)
+	}
+	assert.Equal(t, maxRepairBackoff, repairFailed("T1", now))
+
+	// the timings survive a save and load of the state
+	f := &Fs{}
+	s := f.snapshot()
+	assert.Equal(t, []string{"T1"}, s.Broken)
+	brokenTorrents = map[string]brokenTorrent{}
+	require.NoError(t, f.restore(s))
+	assert.False(t, repairDue("T1", now))
+	assert.Equal(t, 13, brokenTorrents["T1"].Failures)
+
+	// states saved before only have the IDs
+	old, _, err := decodeSnapshot([]byte(`{"version":1,"broken":["T1"]}`))
+	require.NoError(t, err)
+	require.NoError(t, f.restore(old))
+	assert.True(t, isBroken("T1"))
+	assert.True(t, repairDue("T1", now))
+	unmarkBroken("T1")
+	assert.Empty(t, brokenIDs())
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	f := &Fs{}
+	assert.NoError(t, f.checkWritable())
+	f.opt.ReadOnly = true
+	assert.Equal(t, errReadOnly, f.checkWritable())
+
+	assert.True(t, commandWrites("lock", nil))
+	assert.True(t, commandWrites("tag", map[string]string{"add": "kids"}))
+	assert.False(t, commandWrites("tag", map[string]string{}))
+	assert.True(t, commandWrites("orphan-scan", map[string]string{"prune": ""}))
+	assert.False(t, commandWrites("stats", nil))
+	_, err := f.Command(ctx, "sort-import", []string{"file"}, nil)
+	assert.Equal(t, errReadOnly, err)
+
+	assert.Equal(t, errReadOnly, f.deleteTorrent(ctx, "T1"))
+	assert.Equal(t, errReadOnly, f.deleteDownload(ctx, "D1"))
+	o := &Object{fs: f, remote: "file"}
+	assert.Equal(t, errReadOnly, o.Remove(ctx))
+	assert.Equal(t, errReadOnly, o.SetModTime(ctx, time.Now()))
+}
+
+func TestRuleEdits(t *testing.T) {
+	defer func() {
+		torrents = nil
+		placements = map[string]string{}
+	}()
+	ctx := context.Background()
+	f := &Fs{}
+	f.opt.SharedFolder = "folders"
+	f.opt.RegexShows = `S\d\dE\d\d`
+	f.opt.RegexMovies = `^$`
+	require.NoError(t, f.addRule("/kids/", "(?i)pixar"))
+	assert.Equal(t, fs.CommaSepList{"kids=(?i)pixar"}, f.opt.RegexFolders)
+	assert.Error(t, f.addRule("kids", "(?i)pixar"), "duplicate")
+	assert.Error(t, f.addRule("kids", "("), "bad regex")
+	assert.Error(t, f.addRule(".hidden", "x"), "bad path")
+	require.NoError(t, f.addRule("kids", "(?i)disney, inc"))
+	assert.Equal(t, "kids", f.category("Disney, Inc. Special"))
+	assert.True(t, f.isSortingFolder("kids"))
+	assert.True(t, f.isSortingFolder("movies"))
+	assert.False(t, f.isSortingFolder("nowhere"))
+
+	deleted, err := f.deleteRule("kids")
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	assert.Empty(t, f.opt.RegexFolders)
+	assert.Equal(t, "default", f.category("Disney, Inc. Special"))
+	_, err = f.deleteRule("kids")
+	assert.Error(t, err)
+
+	f.opt.ReadOnly = true
+	assert.Equal(t, errReadOnly, f.addRule("kids", "x"))
+	f.opt.ReadOnly = false
+
+	placements["Some.Film"] = "shows"
+	assert.Equal(t, "shows", f.category("Some.Film"))
+	_, _, err = f.moveTorrent(ctx, "x", "nowhere")
+	assert.ErrorContains(t, err, "isn't a sorting folder")
+}
+
+func TestRefreshKeepsSnapshot(t *testing.T) {
+	defer func() {
+		torrents, cached = nil, nil
+		indexCached()
+	}()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/downloads", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Total-Count", "1")
+		_, _ = fmt.Fprint(w, `[{"id":"new","link":"https://hoster/new"}]`)
+	})
+	mux.HandleFunc("/torrents", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"bad_request","error_code":-1}`, http.StatusBadRequest)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	f := &Fs{
+		srv:      rest.NewClient(http.DefaultClient).SetRoot(server.URL).SetErrorHandler(errorHandler),
+		pacer:    fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(time.Millisecond))),
+		accounts: newAccounts("", nil),
+	}
+	torrents = []api.Item{{ID: "T1"}}
+	cached = []api.Item{{ID: "old", OriginalLink: "https://hoster/old"}, {ID: "older"}}
+	indexCached()
+
+	_, err := f.refreshLibrary(ctx)
+	require.Error(t, err)
+	assert.Equal(t, "old", cached[0].ID, "links kept with the torrents they go with")
+	assert.Equal(t, 1, len(torrents))
+}
+
+func TestNameParser(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name string
+		want releaseInfo
+	}{
+		{"Some.Show.S02E03.2160p.WEB", releaseInfo{Title: "Some Show", Season: 2, Episode: 3, Quality: "2160p"}},
+		{"Some Show 1x02 720p", releaseInfo{Title: "Some Show", Season: 1, Episode: 2, Quality: "720p"}},
+		{"Some.Show.Season.4.1080p", releaseInfo{Title: "Some Show", Season: 4, Quality: "1080p"}},
+		{"2001.A.Space.Odyssey.1968.1080p", releaseInfo{Title: "2001 A Space Odyssey", Year: 1968, Quality: "1080p"}},
+		{"[Group] Film (2020)", releaseInfo{Title: "Film", Year: 2020}},
+		{"Something", releaseInfo{Title: "Something"}},
+	} {
+		got, err := heuristicParser{}.parse(ctx, test.name)
+		assert.NoError(t, err)
+		assert.Equal(t, test.want, got, test.name)
+	}
+
+	hits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Query().Get("name") == "bad" {
+			http.Error(w, "nope", http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"title":"Parsed","year":2001}`))
+	}))
+	defer ts.Close()
+	parser, err := newNameParser(ctx, ts.URL)
+	require.NoError(t, err)
+	f := &Fs{opt: Options{RegexShows: `S\d\d`, RegexMovies: `\bxyz\b`, Enc: encoder.Display}}
+	f.setSortRules(nil, nil, parser)
+	assert.Equal(t, releaseInfo{Title: "Parsed", Year: 2001}, f.parseName("Anything"))
+	assert.Equal(t, "movies", f.category("Anything"))
+	assert.Equal(t, 1, hits, "results are cached")
+	// a failing parser falls back to the built in one
+	assert.Equal(t, releaseInfo{Title: "bad"}, f.parseName("bad"))
+	assert.Equal(t, "default", f.category("bad"))
+
+	f.setSortRules(nil, nil, nil)
+	assert.Equal(t, "default", f.category("Some Show 1x02"))
+	f.setSortRules(nil, nil, heuristicParser{})
+	assert.Equal(t, "shows", f.category("Some Show 1x02"))
+	r, err := f.sortTest("Some Show 1x02")
+	require.NoError(t, err)
+	assert.Equal(t, "shows", r.Category)
+	assert.Equal(t, 2, r.Parsed.Episode)
+	assert.Equal(t, "name_parser", r.Rules[len(r.Rules)-1].Rule)
+	assert.True(t, r.Rules[len(r.Rules)-1].Matched)
+}
+
+func TestStateUpgrade(t *testing.T) {
+	defer func() {
+		stateLoaded = sync.Once{}
+		torrents, cached = nil, nil
+		brokenTorrents = map[string]brokenTorrent{}
+		indexCached()
+	}()
+	dir := t.TempDir()
+	f := &Fs{}
+	f.opt.StateFile = filepath.Join(dir, "state.json")
+	old := []byte(`{"version":1,"created":"2022-01-02T03:04:05Z","torrents":[{"id":"T1"}],"broken":["T1"]}`)
+	require.NoError(t, ioutil.WriteFile(f.opt.StateFile, old, 0600))
+
+	// an instance which doesn't keep the state_file leaves it alone
+	lease := filepath.Join(dir, "lease.json")
+	leader := &coordinator{fileName: lease, owner: "leader"}
+	require.True(t, leader.isLeaderAt(time.Now()))
+	f.coord = &coordinator{fileName: lease, owner: "follower"}
+	require.NoError(t, f.loadState())
+	_, version, err := readSnapshot(f.opt.StateFile)
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+	assert.NoFileExists(t, f.opt.StateFile+".v1")
+
+	leader.release()
+	require.NoError(t, f.loadState())
+	assert.True(t, isBroken("T1"))
+	assert.Equal(t, 2022, brokenTorrents["T1"].Since.Year())
+
+	backup, err := ioutil.ReadFile(f.opt.StateFile + ".v1")
+	require.NoError(t, err)
+	assert.Equal(t, old, backup)
+	s, version, err := readSnapshot(f.opt.StateFile)
+	require.NoError(t, err)
+	assert.Equal(t, snapshotVersion, version)
+	assert.Equal(t, "T1", s.Torrents[0].ID)
+
+	_, _, err = decodeSnapshot([]byte(`{"version":99}`))
+	assert.Error(t, err)
+}
+
+func TestMigrateFileKeys(t *testing.T) {
+	defer func() {
+		modTimes = map[string]int64{}
+		plays = map[string]int64{}
+	}()
+	hash := "0123456789abcdef0123456789abcdef01234567"
+	s, version, err := decodeSnapshot([]byte(`{"version":2,"mod_times":{"` + hash + `/1":10,"` + hash + `/2":20,"https://host/1":30},"plays":{"` + hash + `/2":3}}`))
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+	assert.Equal(t, map[string]int64{positionKey(hash, 1): 10, positionKey(hash, 2): 20, "https://host/1": 30}, s.ModTimes)
+	assert.Equal(t, map[string]int64{positionKey(hash, 2): 3}, s.Plays)
+
+	// the files are moved to their file IDs once these are known
+	modTimes, plays = s.ModTimes, s.Plays
+	adoptPositionKeys(hash, []int64{4, 7})
+	assert.Equal(t, map[string]int64{hash + "/4": 10, hash + "/7": 20, "https://host/1": 30}, modTimes)
+	assert.Equal(t, map[string]int64{hash + "/7": 3}, plays)
+}
+
+func TestRefreshDoesntBlockListings(t *testing.T) {
+	defer func() {
+		torrents, cached = nil, nil
+		indexCached()
+	}()
+	reached := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/downloads", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Total-Count", "0")
+		_, _ = fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc("/torrents", func(w http.ResponseWriter, r *http.Request) {
+		close(reached)
+		<-release
+		w.Header().Set("X-Total-Count", "1")
+		_, _ = fmt.Fprint(w, `[{"id":"T2","filename":"New","status":"downloaded"}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	f := &Fs{
+		srv:      rest.NewClient(http.DefaultClient).SetRoot(server.URL).SetErrorHandler(errorHandler),
+		pacer:    fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(time.Millisecond))),
+		accounts: newAccounts("", nil),
+	}
+	torrents = []api.Item{{ID: "T1"}, {ID: "T0"}}
+	indexCached()
+
+	done := make(chan error)
+	go func() {
+		_, err := f.refreshLibrary(ctx)
+		done <- err
+	}()
+	<-reached
+	// the old library can still be read while the new one is fetched
+	listMu.RLock()
+	assert.Equal(t, "T1", torrents[0].ID)
+	listMu.RUnlock()
+	close(release)
+	require.NoError(t, <-done)
+	assert.Equal(t, "T2", torrents[0].ID)
+}
+
+func TestCrawlFlagsStatusChanges(t *testing.T) {
+	defer func() {
+		torrents, cached = nil, nil
+		brokenTorrents = map[string]brokenTorrent{}
+		indexCached()
+	}()
+	defer atomic.StoreInt64(&lastcheck, atomic.LoadInt64(&lastcheck))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/downloads", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Total-Count", "0")
+		_, _ = fmt.Fprint(w, `[]`)
+	})
+	mux.HandleFunc("/torrents", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Total-Count", "1")
+		_, _ = fmt.Fprint(w, `[{"id":"T1","filename":"Film","status":"error"}]`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	f := &Fs{
+		opt:      Options{ReadOnly: true},
+		srv:      rest.NewClient(http.DefaultClient).SetRoot(server.URL).SetErrorHandler(errorHandler),
+		pacer:    fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(time.Millisecond))),
+		accounts: newAccounts("", nil),
+	}
+	torrents = []api.Item{{ID: "T1", Name: "Film", Status: "downloaded"}}
+	indexCached()
+	atomic.StoreInt64(&lastcheck, 0)
+
+	// the crawl swaps in the library as a refresh does
+	f.crawl(ctx)
+	assert.Equal(t, "error", torrents[0].Status)
+	assert.True(t, isBroken("T1"))
+	assert.False(t, refreshIsDue())
+}
+
+func TestWatch(t *testing.T) {
+	defer func() {
+		eventsMu.Lock()
+		eventLog = nil
+		eventsMu.Unlock()
+	}()
+	f := &Fs{opt: Options{SharedFolder: "folders", RegexShows: `S\d\d`, RegexMovies: `(19|20)\d\d`, Enc: encoder.Display}}
+	seq := lastEventSeq()
+	f.torrentChanges([]api.Item{{ID: "T1", Name: "Old", TorrentHash: "a"}}, []api.Item{{ID: "T2", Name: "Film.2020", TorrentHash: "b"}})
+	events := eventsSince(context.Background(), seq)
+	require.Equal(t, 2, len(events))
+	assert.Equal(t, eventAdd, events[0].Event)
+	assert.Equal(t, "movies/Film.2020", events[0].Path)
+	assert.Equal(t, eventRemove, events[1].Event)
+
+	// nothing new so it waits until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Empty(t, eventsSince(ctx, events[1].Seq))
+
+	// the rc call gives the latest seq first then waits for events
+	out, err := rcEvents(context.Background(), rc.Params{})
+	require.NoError(t, err)
+	assert.Equal(t, events[1].Seq, out["seq"])
+	go recordEvent(libraryEvent{hookEvent: hookEvent{Event: eventMove, Name: "Film.2020"}, From: "movies"})
+	out, err = rcEvents(context.Background(), rc.Params{"since": events[1].Seq, "timeout": "10s"})
+	require.NoError(t, err)
+	moved := out["events"].([]libraryEvent)
+	require.Equal(t, 1, len(moved))
+	assert.Equal(t, "movies", moved[0].From)
+
+	// and a remote watch streams them
+	polling := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in rc.Params
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		if since, _ := in.GetInt64("since"); since >= 0 {
+			once.Do(func() { close(polling) })
+		}
+		out, err := rcEvents(r.Context(), in)
+		if err == nil {
+			_ = json.NewEncoder(w).Encode(out)
+		}
+	}))
+	defer server.Close()
+	var buf bytes.Buffer
+	watchOutput = &buf
+	defer func() { watchOutput = os.Stdout }()
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		<-polling
+		recordEvent(libraryEvent{hookEvent: hookEvent{Event: eventRepair, Name: "Fixed"}})
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = f.watchCommand(ctx, map[string]string{"rc": server.URL, "interval": "20ms"})
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), `"event":"repair"`)
+}
+
+func TestShowStatuses(t *testing.T) {
+	defer func() { torrents = nil }()
+	torrents = []api.Item{
+		{ID: "T1", Name: "Done.2020", TorrentHash: "a", Status: "downloaded"},
+		{ID: "T2", Name: "Uploading.2020", TorrentHash: "b", Status: "uploading"},
+		{ID: "T3", Name: "Bad.2020", TorrentHash: "c", Status: "virus"},
+	}
+	f := &Fs{opt: Options{SharedFolder: "folders", RegexShows: `S\d\d`, RegexMovies: `(19|20)\d\d`}}
+	items, _ := f.folderItems("movies")
+	assert.Equal(t, 2, len(items), "every status but virus shown by default")
+	assert.Equal(t, 2, len(f.byHashItems()))
+
+	f.opt.ShowStatuses = fs.CommaSepList{"downloaded"}
+	items, _ = f.folderItems("movies")
+	require.Equal(t, 1, len(items))
+	assert.Equal(t, "T1", items[0].ID)
+
+	// a torrent with a single file is left for flatFiles to list
+	torrents[0].Links = []string{"link"}
+	f.opt.FlattenSingle = true
+	items, flat := f.folderItems("movies")
+	assert.Empty(t, items)
+	require.Equal(t, 1, len(flat))
+	assert.Equal(t, "T1", flat[0].ID)
+	assert.Equal(t, 1, len(f.byHashItems()))
+	assert.False(t, f.statusShown("virus"))
+
+	assert.NoError(t, checkStatuses([]string{"downloaded", "uploading"}))
+	assert.Error(t, checkStatuses([]string{"done"}))
+}
+
+func TestPendingPruned(t *testing.T) {
+	defer func() { pending = map[string]pendingFile{} }()
+	torrent := api.Item{ID: "T1", Name: "Film", Links: []string{"https://hoster/a"}}
+	setPending("https://hoster/a", 0, &torrent, api.Item{})
+	setPending("https://hoster/gone", 0, &api.Item{Name: "Failed"}, api.Item{})
+	now := time.Now()
+	prunePending([]api.Item{torrent}, now)
+	items := pendingItems()
+	require.Equal(t, 1, len(items))
+	assert.Equal(t, "Film - file 1", items[0].Name)
+
+	prunePending([]api.Item{torrent}, now.Add(pendingExpiry+time.Minute))
+	assert.Empty(t, pendingItems())
+}
+
+func TestSnapshotRulesRoundTrip(t *testing.T) {
+	defer func() {
+		torrents, cached = nil, nil
+		indexCached()
+	}()
+	ctx := context.Background()
+	opt := Options{
+		RegexShows:   `S\d\d`,
+		RegexMovies:  `(19|20)\d\d`,
+		RegexFolders: fs.CommaSepList{"shows/anime=(?i)subsplease"},
+		NameParser:   "builtin",
+		SharedFolder: "folders",
+	}
+	from := &Fs{opt: opt, m: configmap.Simple{}}
+	folders, err := parseRuleFolders(opt.RegexFolders)
+	require.NoError(t, err)
+	from.setSortRules(folders, nil, heuristicParser{})
+	fileName := filepath.Join(t.TempDir(), "snapshot.json")
+	_, err = from.exportLibrary(ctx, fileName)
+	require.NoError(t, err)
+
+	to := &Fs{opt: Options{RegexShows: `^$`, RegexMovies: `^$`, SharedFolder: "folders"}, m: configmap.Simple{}}
+	_, err = to.importLibrary(ctx, fileName)
+	require.NoError(t, err)
+	assert.Equal(t, opt.RegexFolders, to.opt.RegexFolders)
+	assert.Equal(t, "builtin", to.opt.NameParser)
+	for _, name := range []string{"[SubsPlease] Anime - 01", "Show S01", "Film 1999", "Show 1x02"} {
+		assert.Equal(t, from.category(name), to.category(name), name)
+	}
+	assert.Equal(t, "shows/anime", to.category("[SubsPlease] Anime - 01"))
+
+	// bad rules in a snapshot are refused before anything is changed
+	s := from.snapshot()
+	s.Rules.RegexFolders = fs.CommaSepList{"bad"}
+	assert.Error(t, to.restore(s))
+	assert.Equal(t, opt.RegexFolders, to.opt.RegexFolders)
+}
+
+func TestAccounts(t *testing.T) {
+	a := newAccounts("k1", []string{"k2"})
+	a.root = "https://api.example.com"
+	a.setOwner("T2", 1)
+	sign := func(u string) string {
+		req, err := http.NewRequest("GET", u, nil)
+		require.NoError(t, err)
+		require.NoError(t, a.sign(req))
+		return req.URL.Query().Get("auth_token")
+	}
+	assert.Equal(t, "k1", sign(a.root+"/torrents"))
+	assert.Equal(t, "k2", sign(a.root+"/torrents/info/T2"), "sent to the owner")
+	assert.Equal(t, "k2", sign(a.root+"/torrents/delete/T2"))
+	assert.Equal(t, "k1", sign(a.root+"/torrents/info/T1"))
+	assert.Equal(t, "", sign("https://node1.example.com/d/T2"), "hosts not signed")
+
+	respond := func(key string, status int) *http.Response {
+		req, err := http.NewRequest("GET", a.root+"/torrents?auth_token="+key, nil)
+		require.NoError(t, err)
+		return &http.Response{StatusCode: status, Request: req}
+	}
+	a.checkResponse(respond("k1", http.StatusForbidden), &api.Error{ErrorCode: api.CodePermissionDenied})
+	assert.Equal(t, 0, a.current, "no failover on a refused call")
+	a.checkResponse(respond("k1", http.StatusTooManyRequests), api.ErrTooManyRequests)
+	assert.Equal(t, 1, a.current, "failover when rate limited")
+	a.checkResponse(respond("k1", http.StatusTooManyRequests), api.ErrTooManyRequests)
+	assert.Equal(t, 1, a.current, "no failover from a key no longer used")
+	a.checkResponse(respond("k2", http.StatusServiceUnavailable), api.ErrTrafficExhausted)
+	assert.Equal(t, 0, a.current, "failover when out of traffic")
+
+	// a listing which fails over part way is read again from the start
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, offset := r.URL.Query().Get("auth_token"), r.URL.Query().Get("offset")
+		calls = append(calls, key+"@"+offset)
+		w.Header().Set("X-Total-Count", "2")
+		switch {
+		case key == "k1" && offset == "":
+			_, _ = fmt.Fprint(w, `[{"id":"A"}]`)
+		case key == "k1":
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = fmt.Fprint(w, `{"error":"too_many_requests","error_code":34}`)
+		case offset == "":
+			_, _ = fmt.Fprint(w, `[{"id":"X"},{"id":"Y"}]`)
+		default:
+			_, _ = fmt.Fprint(w, `[{"id":"Y"}]`)
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+	f := &Fs{
+		pacer:    fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(time.Millisecond))),
+		accounts: newAccounts("k1", []string{"k2"}),
+	}
+	f.accounts.root = server.URL
+	f.srv = rest.NewClient(http.DefaultClient).SetRoot(server.URL).SetSigner(f.accounts.sign).SetErrorHandler(func(resp *http.Response) error {
+		err := errorHandler(resp)
+		f.accounts.checkResponse(resp, err)
+		return err
+	})
+	list, err := f.fetchAll(ctx, "/torrents")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"k1@", "k1@1", "k2@1", "k2@"}, calls)
+	require.Len(t, list, 2)
+	assert.Equal(t, "X", list[0].ID)
+	assert.Equal(t, 1, list[0].Account)
+	assert.Equal(t, 1, list[1].Account)
+	f.accounts.own(list)
+	assert.Equal(t, 1, f.accounts.owners["Y"])
+	assert.NotContains(t, f.accounts.owners, "T2")
+}
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	w, err := parseMaintenanceWindow("")
+	require.NoError(t, err)
+	assert.Nil(t, w)
+	assert.True(t, w.contains(time.Now()))
+
+	for _, bad := range []string{"03:00", "3-5", "03:00-25:00", "05:00-05:00", "03:00-04:00-05:00"} {
+		_, err := parseMaintenanceWindow(bad)
+		assert.Error(t, err, bad)
+	}
+
+	at := func(hour, minute int) time.Time {
+		return time.Date(2022, 5, 1, hour, minute, 0, 0, time.Local)
+	}
+	for _, test := range []struct {
+		window string
+		t      time.Time
+		want   bool
+	}{
+		{"03:00-05:00", at(2, 59), false},
+		{"03:00-05:00", at(3, 0), true},
+		{"03:00-05:00", at(4, 59), true},
+		{"03:00-05:00", at(5, 0), false},
+		{"23:30-01:00", at(23, 45), true},
+		{"23:30-01:00", at(0, 30), true},
+		{"23:30-01:00", at(1, 0), false},
+		{"23:30-01:00", at(12, 0), false},
+	} {
+		w, err := parseMaintenanceWindow(test.window)
+		require.NoError(t, err)
+		assert.Equal(t, test.window, w.String())
+		assert.Equal(t, test.want, w.contains(test.t), test.window+" at "+test.t.Format("15:04"))
+	}
+}
+
+func TestScanGuard(t *testing.T) {
+	var disabled *scanGuard
+	disabled.listed(time.Now())
+	assert.False(t, disabled.storming())
+	assert.Nil(t, newScanGuard(0))
+
+	g := newScanGuard(3)
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	g.listed(now)
+	g.listed(now.Add(time.Second))
+	assert.False(t, g.stormingAt(now.Add(time.Second)))
+	g.listed(now.Add(2 * time.Second))
+	assert.True(t, g.stormingAt(now.Add(2*time.Second)))
+	assert.True(t, g.stormingAt(now.Add(31*time.Second)))
+	assert.False(t, g.stormingAt(now.Add(33*time.Second)))
+
+	// listings spread out don't count
+	g = newScanGuard(3)
+	for i := 0; i < 5; i++ {
+		g.listed(now.Add(time.Duration(i) * 6 * time.Second))
+	}
+	assert.False(t, g.stormingAt(now.Add(24*time.Second)))
+
+	// a read ends the storm and keeps the next one from starting
+	g = newScanGuard(2)
+	g.listed(now)
+	g.listed(now)
+	assert.True(t, g.stormingAt(now))
+	g.read(now)
+	assert.False(t, g.stormingAt(now))
+	g.listed(now)
+	assert.False(t, g.stormingAt(now))
+}
+
+func TestBlockCache(t *testing.T) {
+	dir := t.TempDir()
+	c, err := newBlockCache(dir, 10)
+	require.NoError(t, err)
+
+	c.put("a", []byte("aaaa"))
+	c.put("b", []byte("bbbb"))
+	data, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "aaaa", string(data))
+
+	// b is the least recently used so goes first
+	c.put("c", []byte("cccc"))
+	_, ok = c.get("b")
+	assert.False(t, ok)
+	_, ok = c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, int64(8), c.size)
+
+	// blocks on disk are picked up again and unfinished writes dropped
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "d-123.tmp"), []byte("dd"), 0600))
+	c, err = newBlockCache(dir, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), c.size)
+	infos, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, infos, 2)
+
+	// readers storing the same block at once leave one copy
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.put("e", []byte("ee"))
+			data, ok := c.get("e")
+			if ok {
+				assert.Equal(t, "ee", string(data))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(10), c.size)
+	infos, err = ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, infos, 3)
+}
+
+func TestCacheKey(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	o := &Object{remote: "shows/Show S01/E01.mkv", size: 1, TorrentHash: "ABC", fileID: 1, originalLink: "https://host/1"}
+	renamed := *o
+	renamed.remote = "shows/Show S01/E01 (2).mkv"
+	assert.Equal(t, newCacheReader(ctx, o, nil, nil, 0, -1).key, newCacheReader(ctx, &renamed, nil, nil, 0, -1).key)
+	other := *o
+	other.fileID = 2
+	assert.NotEqual(t, newCacheReader(ctx, o, nil, nil, 0, -1).key, newCacheReader(ctx, &other, nil, nil, 0, -1).key)
+	resized := *o
+	resized.size = 2
+	assert.NotEqual(t, newCacheReader(ctx, o, nil, nil, 0, -1).key, newCacheReader(ctx, &resized, nil, nil, 0, -1).key, "blocks of another size")
+
+	// the options given to Open are passed on for each block
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		_, _ = w.Write([]byte("0123456789")[:1])
+	}))
+	defer server.Close()
+	f := &Fs{
+		srv:   rest.NewClient(http.DefaultClient).SetRoot(server.URL),
+		pacer: fs.NewPacer(ctx, pacer.NewDefault(pacer.MinSleep(time.Millisecond))),
+		slots: newDownloadSlots(1, time.Millisecond),
+	}
+	f.dl = f.srv
+	f.cache, err = newBlockCache(t.TempDir(), 1<<20)
+	require.NoError(t, err)
+	o.fs, o.url, o.originalLink = f, server.URL+"/file", ""
+	in := newCacheReader(ctx, o, f.cache, []fs.OpenOption{&fs.HTTPOption{Key: "X-Test", Value: "yes"}}, 0, -1)
+	data, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	assert.Equal(t, "0", string(data))
+	assert.Equal(t, "yes", got.Get("X-Test"))
+	assert.Equal(t, "bytes=0-0", got.Get("Range"))
+	assert.Equal(t, 0, f.slots.stats().Open, "slot given back")
+
+	// blocks not cached wait for a download slot
+	require.NoError(t, f.slots.acquire(ctx))
+	o.size = 2
+	_, err = ioutil.ReadAll(newCacheReader(ctx, o, f.cache, nil, 0, -1))
+	assert.Error(t, err)
+	f.slots.release()
+}
+
+func TestCheckSize(t *testing.T) {
+	defer func() { sizeChanges = nil }()
+	c, err := newBlockCache(t.TempDir(), 1<<30)
+	require.NoError(t, err)
+	o := &Object{fs: &Fs{cache: c}, remote: "shows/file.mkv", size: 2*cacheBlockSize + 1, TorrentHash: "aaaa"}
+	key := cacheKey(o)
+	for index := int64(0); index < 3; index++ {
+		c.put(blockName(key, index), []byte("x"))
+	}
+	c.put(blockName("other", 0), []byte("x"))
+
+	assert.NoError(t, o.checkSize(&api.Item{Size: o.size}))
+	assert.NoError(t, o.checkSize(&api.Item{}))
+	assert.Empty(t, recentSizeChanges())
+
+	err = o.checkSize(&api.Item{Size: 100})
+	assert.True(t, errors.Is(err, errSizeChanged))
+	assert.Equal(t, int64(100), recentSizeChanges()[0].New)
+	assert.Equal(t, 1, c.lru.Len())
+}
+
+func TestCoordinator(t *testing.T) {
+	var none *coordinator
+	assert.True(t, none.isLeaderAt(time.Now()))
+	assert.Nil(t, getCoordinator(""))
+
+	fileName := filepath.Join(t.TempDir(), "lease.json")
+	a := &coordinator{fileName: fileName, owner: "a"}
+	b := &coordinator{fileName: fileName, owner: "b"}
+	now := time.Now()
+
+	assert.True(t, a.isLeaderAt(now))
+	assert.False(t, b.isLeaderAt(now))
+	assert.True(t, a.isLeaderAt(now.Add(time.Minute)))
+
+	// b takes over once a stops renewing
+	assert.True(t, b.isLeaderAt(now.Add(time.Minute+coordinationTTL)))
+	assert.False(t, a.isLeaderAt(now.Add(time.Minute+coordinationTTL)))
+
+	// releasing lets the other take over straight away
+	b.release()
+	assert.True(t, a.isLeaderAt(now.Add(2*time.Minute+coordinationTTL)))
+
+	// while another instance holds the lock the lease is only read
+	unlock, err := b.lock()
+	assert.NoError(t, err)
+	_, err = a.lock()
+	assert.Equal(t, errLockBusy, err)
+	assert.True(t, a.isLeaderAt(now.Add(3*time.Minute+coordinationTTL)))
+	assert.False(t, b.isLeaderAt(now.Add(3*time.Minute+coordinationTTL)))
+	unlock()
+
+	// a lock left behind by a crash is taken over once stale
+	unlock, err = b.lock()
+	assert.NoError(t, err)
+	old := time.Now().Add(-2 * lockStale)
+	assert.NoError(t, os.Chtimes(fileName+lockSuffix, old, old))
+	unlock2, err := a.lock()
+	assert.NoError(t, err)
+	unlock2()
+
+	// edits of the state are refused on the follower
+	f := &Fs{coord: b}
+	_, err = f.Command(context.Background(), "lock", []string{"shows"}, nil)
+	assert.Equal(t, errNotLeader, err)
+}
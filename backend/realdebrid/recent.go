@@ -0,0 +1,58 @@
+package realdebrid
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+)
+
+// recentDirID is the ID of the /.recent view which lists the files of
+// the torrents added most recently
+const recentDirID = ".recent"
+
+// recentOrder returns the indexes of the torrents added since cutoff,
+// newest first
+//
+// Call with listMu held.
+func (f *Fs) recentOrder(cutoff time.Time) (order []int) {
+	for i := range torrents {
+		if addedAt(&torrents[i]) >= cutoff.Unix() && f.statusShown(torrents[i].Status) {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return addedAt(&torrents[order[a]]) > addedAt(&torrents[order[b]])
+	})
+	return order
+}
+
+// recentTorrents returns copies of the torrents added within
+// recent_window, newest first
+//
+// Call with listMu held.
+func (f *Fs) recentTorrents() (recent []api.Item) {
+	cutoff := time.Now().Add(-time.Duration(f.opt.RecentWindow))
+	for _, i := range f.recentOrder(cutoff) {
+		recent = append(recent, torrents[i])
+	}
+	return recent
+}
+
+// recentItems returns up to recent_files files of the torrents in
+// recent, as returned by recentTorrents
+//
+// Call without listMu held.
+func (f *Fs) recentItems(ctx context.Context, recent []api.Item) (result []api.Item) {
+	for _, torrent := range recent {
+		for _, item := range f.torrentFiles(ctx, torrent, false) {
+			if len(result) >= f.opt.RecentFiles {
+				return result
+			}
+			item.Type = api.ItemTypeFile
+			result = append(result, item)
+		}
+	}
+	return result
+}
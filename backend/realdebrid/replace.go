@@ -0,0 +1,304 @@
+package realdebrid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// Defaults for the replace command
+const (
+	defaultReplaceWait = 10 * time.Minute
+	replacePoll        = 5 * time.Second
+)
+
+// names holds the names torrents are shown with instead of their own,
+// by lower case info hash, so that a release replaced by the replace
+// command keeps the path of the one it replaced
+var names = map[string]string{}
+var namesMu sync.Mutex
+
+// pinName makes the torrent with hash show up as name
+func pinName(hash, name string) {
+	namesMu.Lock()
+	names[strings.ToLower(hash)] = name
+	namesMu.Unlock()
+}
+
+// carryOver moves what is kept about the torrent old over to torrent,
+// the release replacing it: the name it is shown with, its tags and
+// when it was last opened. The pinned modification times, last access
+// and play counts of the files are moved for the files paired up in
+// files, the file IDs in torrent by file ID in old, from matchFiles.
+func carryOver(old, torrent *api.Item, files map[int64]int64) {
+	pinName(torrent.TorrentHash, old.Name)
+	unpinName(old.TorrentHash)
+	editTags(torrent.TorrentHash, torrentTags(old.TorrentHash), nil)
+	modTimesMu.Lock()
+	defer modTimesMu.Unlock()
+	openedMu.Lock()
+	defer openedMu.Unlock()
+	if t, ok := opened[old.ID]; ok {
+		opened[torrent.ID] = t
+	}
+	for oldID, newID := range files {
+		from, to := modTimeKey(old.TorrentHash, oldID, ""), modTimeKey(torrent.TorrentHash, newID, "")
+		rekey(modTimes, from, to, false)
+		rekey(accessed, from, to, false)
+		rekey(plays, from, to, true)
+		rekey(finished, from, to, true)
+	}
+}
+
+// rekey moves the entry of m under from to to, adding it to any
+// already there if add is set or replacing it if not
+//
+// Call with the mutex protecting m held.
+func rekey(m map[string]int64, from, to string, add bool) {
+	value, ok := m[from]
+	if !ok {
+		return
+	}
+	if add {
+		value += m[to]
+	}
+	m[to] = value
+	delete(m, from)
+}
+
+// matchFiles pairs up the selected files of a release, oldFiles, with
+// the selected files of the release replacing it, newFiles, returning
+// the file IDs in newFiles by file ID in oldFiles
+//
+// Files are paired by their normalised name, else by their season and
+// episode. Releases seldom have the same files in the same order, so
+// files which can't be paired with exactly one file are left out.
+func matchFiles(oldFiles, newFiles []api.File) map[int64]int64 {
+	pairs := map[int64]int64{}
+	for _, key := range []func(api.File) string{fileNameKey, fileEpisodeKey} {
+		olds, news := filesByKey(oldFiles, key), filesByKey(newFiles, key)
+		for k, from := range olds {
+			to, ok := news[k]
+			if !ok || len(from) != 1 || len(to) != 1 {
+				continue
+			}
+			if _, done := pairs[from[0]]; !done {
+				pairs[from[0]] = to[0]
+			}
+		}
+	}
+	// a file in the new release can only take over one old file
+	taken := map[int64]int{}
+	for _, to := range pairs {
+		taken[to]++
+	}
+	for from, to := range pairs {
+		if taken[to] > 1 {
+			delete(pairs, from)
+		}
+	}
+	return pairs
+}
+
+// filesByKey returns the IDs of the selected files in files by the key
+// made by key, leaving out those with no key
+func filesByKey(files []api.File, key func(api.File) string) map[string][]int64 {
+	out := map[string][]int64{}
+	for _, file := range files {
+		if file.Selected != 1 {
+			continue
+		}
+		if k := key(file); k != "" {
+			out[k] = append(out[k], file.ID)
+		}
+	}
+	return out
+}
+
+// fileNameKey returns the name of file in lower case without its
+// extension and anything but letters and digits
+func fileNameKey(file api.File) string {
+	name := path.Base(file.Path)
+	name = strings.ToLower(strings.TrimSuffix(name, path.Ext(name)))
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, name)
+}
+
+// fileEpisodeKey returns the season and episode in the name of file,
+// or "" if it hasn't got one
+func fileEpisodeKey(file api.File) string {
+	m := episodeRe.FindStringSubmatch(path.Base(file.Path))
+	if m == nil {
+		return ""
+	}
+	season, _ := strconv.Atoi(m[1])
+	episode, _ := strconv.Atoi(m[2])
+	return fmt.Sprintf("s%de%d", season, episode)
+}
+
+// unpinName makes the torrent with hash show up with its own name again
+func unpinName(hash string) {
+	namesMu.Lock()
+	delete(names, strings.ToLower(hash))
+	namesMu.Unlock()
+}
+
+// applyNames gives the torrents in list their pinned names
+func applyNames(list []api.Item) {
+	namesMu.Lock()
+	defer namesMu.Unlock()
+	if len(names) == 0 {
+		return
+	}
+	for i := range list {
+		if name, ok := names[strings.ToLower(list[i].TorrentHash)]; ok {
+			list[i].Name = name
+		}
+	}
+}
+
+// copyNames returns a copy of names
+func copyNames() map[string]string {
+	namesMu.Lock()
+	defer namesMu.Unlock()
+	out := make(map[string]string, len(names))
+	for hash, name := range names {
+		out[hash] = name
+	}
+	return out
+}
+
+// waitDownloaded waits up to wait for the torrent with ID id to finish
+// downloading, returning its info
+func (f *Fs) waitDownloaded(ctx context.Context, id string, wait time.Duration) (torrent api.Item, err error) {
+	opts := rest.Opts{
+		Method:     "GET",
+		Path:       "/torrents/info/" + id,
+		Parameters: f.baseParams(),
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		var resp *http.Response
+		err = f.pacer.Call(func() (bool, error) {
+			resp, err = f.srv.CallJSON(ctx, &opts, nil, &torrent)
+			return shouldRetry(ctx, resp, err)
+		})
+		if err != nil {
+			return torrent, fmt.Errorf("couldn't read torrent info: %w", err)
+		}
+		switch torrent.Status {
+		case "downloaded":
+			return torrent, nil
+		case "magnet_error", "error", "virus", "dead":
+			return torrent, fmt.Errorf("torrent failed with status %q", torrent.Status)
+		}
+		if time.Now().After(deadline) {
+			return torrent, fmt.Errorf("torrent still %q at %.0f%% after %v", torrent.Status, torrent.Progress, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return torrent, ctx.Err()
+		case <-time.After(replacePoll):
+		}
+	}
+}
+
+// replaceResult is the output of the replace command
+type replaceResult struct {
+	Path      string `json:"path"`
+	Old       string `json:"old"`
+	OldHash   string `json:"old_hash"`
+	New       string `json:"new"`
+	NewHash   string `json:"new_hash"`
+	TorrentID string `json:"torrent_id"`
+}
+
+// replaceCommand replaces the torrent at the path in arg[0] with the
+// release in the magnet or info hash in arg[1]
+//
+// The new release is added and waited for, then shown under the name
+// of the old one so it keeps the same path. Its tags, when it was last
+// opened and what is kept about the files matchFiles pairs up are
+// carried over, then the old release is deleted and
+// put in /.orphaned so it can be reacquired if the new one is no good.
+func (f *Fs) replaceCommand(ctx context.Context, arg []string, opt map[string]string) (interface{}, error) {
+	if len(arg) != 2 {
+		return nil, errors.New("need the path of a torrent and a magnet link or info hash")
+	}
+	if err := checkOnline(); err != nil {
+		return nil, err
+	}
+	wait := defaultReplaceWait
+	if s, ok := opt["wait"]; ok {
+		d, err := fs.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad wait: %w", err)
+		}
+		wait = d
+	}
+	remote := parsePath(arg[0])
+	old, err := f.torrentForPath(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	magnet, err := parseMagnet([]byte(arg[1]))
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(magnetHash(magnet), old.TorrentHash) {
+		return nil, errors.New("that is the release already there")
+	}
+	result := &replaceResult{
+		Path:    f.torrentPath(old),
+		Old:     old.Name,
+		OldHash: old.TorrentHash,
+	}
+	if operations.SkipDestructive(ctx, old.Name, "replace with "+magnet) {
+		return result, nil
+	}
+	added, err := f.addMagnet(ctx, magnet, remote)
+	if err != nil {
+		return nil, err
+	}
+	torrent, err := f.waitDownloaded(ctx, added.ID, wait)
+	if err != nil {
+		return nil, fmt.Errorf("new release %s isn't ready so %q was kept: %w", added.ID, old.Name, err)
+	}
+	result.New = torrent.Name
+	result.NewHash = torrent.TorrentHash
+	result.TorrentID = torrent.ID
+	oldFiles, err := f.torrentFileList(ctx, old.ID)
+	if err != nil {
+		fs.Debugf(f, "Not carrying over the file times of %q: %v", old.Name, err)
+	}
+	carryOver(old, &torrent, matchFiles(oldFiles, torrent.Files))
+	err = f.deleteTorrent(ctx, old.ID)
+	if err != nil {
+		return nil, fmt.Errorf("new release added but failed to delete the old one: %w", err)
+	}
+	listMu.Lock()
+	if orphanIndex(old.TorrentHash) < 0 {
+		orphans = append(orphans, *old)
+	}
+	listMu.Unlock()
+	fs.Infof(f, "Replaced %q with %q", old.Name, torrent.Name)
+	forceRefresh()
+	f.saveState()
+	return result, nil
+}
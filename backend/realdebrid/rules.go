@@ -0,0 +1,83 @@
+package realdebrid
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// sortRules are the regex_folders rules in use, the sorter made from
+// them and the other sorting options, and the name_parser. They are
+// replaced as a whole when the rules are edited so they can be read
+// without a lock.
+type sortRules struct {
+	folders []ruleFolder
+	sorter  torrentSorter // nil to use the options directly
+	parser  nameParser    // nil to sort by the regexes alone
+}
+
+// sortRules returns the sorting rules in use
+func (f *Fs) sortRules() *sortRules {
+	if rules, ok := f.rules.Load().(*sortRules); ok {
+		return rules
+	}
+	return &sortRules{}
+}
+
+// setSortRules makes folders, sorter and parser the sorting rules in
+// use
+func (f *Fs) setSortRules(folders []ruleFolder, sorter torrentSorter, parser nameParser) {
+	f.rules.Store(&sortRules{folders: folders, sorter: sorter, parser: parser})
+}
+
+// ruleFolders returns the regex_folders rules in use
+func (f *Fs) ruleFolders() []ruleFolder {
+	return f.sortRules().folders
+}
+
+// torrentSorter decides which folder of "folders" folder_mode a torrent
+// goes into from its name alone, so the rules can be tested without an
+// Fs or a library
+type torrentSorter interface {
+	// sort returns the folder name is sorted into, or false if no
+	// rule matches it and it should go into default
+	sort(name string) (folder string, ok bool)
+}
+
+// regexSorter is the torrentSorter configured by regex_folders,
+// regex_shows and regex_movies, tried in that order
+type regexSorter struct {
+	folders []ruleFolder
+	shows   *regexp.Regexp
+	movies  *regexp.Regexp
+}
+
+// newRegexSorter compiles the rules, failing if a regex is bad
+func newRegexSorter(folders []ruleFolder, shows, movies string) (*regexSorter, error) {
+	s := &regexSorter{folders: folders}
+	var err error
+	s.shows, err = regexp.Compile(shows)
+	if err != nil {
+		return nil, fmt.Errorf("bad regex_shows: %w", err)
+	}
+	s.movies, err = regexp.Compile(movies)
+	if err != nil {
+		return nil, fmt.Errorf("bad regex_movies: %w", err)
+	}
+	return s, nil
+}
+
+// sort returns the folder of the first rule matching name
+func (s *regexSorter) sort(name string) (string, bool) {
+	for _, rule := range s.folders {
+		if rule.re.MatchString(name) {
+			return rule.path, true
+		}
+	}
+	if s.shows.MatchString(name) {
+		return "shows", true
+	}
+	if s.movies.MatchString(name) {
+		return "movies", true
+	}
+	return "", false
+}
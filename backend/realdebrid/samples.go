@@ -0,0 +1,99 @@
+package realdebrid
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+)
+
+// What to do with sample files
+const (
+	samplesShow       = "show"
+	samplesHide       = "hide"
+	samplesQuarantine = "quarantine"
+)
+
+// samplesDirID is the ID of the directory in the root which lists the
+// files hidden by samples = "quarantine"
+const samplesDirID = ".samples"
+
+// sampleRatio is the fraction of the largest file of a torrent a video
+// must be smaller than to be taken as a sample
+const sampleRatio = 0.02
+
+// videoExtensions are the extensions of the files which may be samples
+var videoExtensions = map[string]bool{
+	".avi": true, ".m2ts": true, ".m4v": true, ".mkv": true, ".mov": true,
+	".mp4": true, ".mpeg": true, ".mpg": true, ".ts": true, ".webm": true, ".wmv": true,
+}
+
+// sampleNameRe matches the names of files which call themselves samples
+var sampleNameRe = regexp.MustCompile(`(?i)\bsample\b`)
+
+// quarantined holds the sample files by their hoster link
+var quarantined = map[string]api.Item{}
+var quarantinedMu sync.Mutex
+
+// isSample returns whether the file item of a torrent whose largest
+// file is largest looks like a sample
+//
+// Only videos are samples, and only if they are smaller than
+// sampleRatio of the largest file or than sampleSize, or are named as
+// one.
+func isSample(item *api.Item, largest, sampleSize int64) bool {
+	if !videoExtensions[strings.ToLower(path.Ext(item.Name))] || item.Size >= largest {
+		return false
+	}
+	if sampleNameRe.MatchString(item.Name) {
+		return true
+	}
+	if float64(item.Size) < sampleRatio*float64(largest) {
+		return true
+	}
+	return sampleSize > 0 && item.Size < sampleSize
+}
+
+// filterSamples takes the samples out of the files of a torrent,
+// quarantining them if set
+func (f *Fs) filterSamples(files []api.Item) []api.Item {
+	if len(files) < 2 {
+		return files
+	}
+	var largest int64
+	for i := range files {
+		if files[i].Size > largest {
+			largest = files[i].Size
+		}
+	}
+	kept := files[:0]
+	for _, item := range files {
+		if !isSample(&item, largest, int64(f.opt.SampleSize)) {
+			kept = append(kept, item)
+			continue
+		}
+		if f.opt.Samples == samplesQuarantine {
+			item.Type = api.ItemTypeFile
+			quarantinedMu.Lock()
+			quarantined[item.OriginalLink] = item
+			quarantinedMu.Unlock()
+		}
+	}
+	return kept
+}
+
+// sampleItems returns the quarantined samples sorted by name
+func sampleItems() (items []api.Item) {
+	quarantinedMu.Lock()
+	for _, item := range quarantined {
+		items = append(items, item)
+	}
+	quarantinedMu.Unlock()
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+	return items
+}
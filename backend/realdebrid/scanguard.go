@@ -0,0 +1,84 @@
+package realdebrid
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// scanWindow is the time over which listings are counted to detect a
+// scan storm. A storm lasts until there have been no storm-like
+// listings for scanQuiet.
+const (
+	scanWindow = 10 * time.Second
+	scanQuiet  = 30 * time.Second
+)
+
+// scanGuard detects scan storms, bursts of listings by library
+// scanners which don't read any files, so that they can be served
+// from what is already known without calling the API.
+type scanGuard struct {
+	mu         sync.Mutex
+	threshold  int         // listings in scanWindow which start a storm
+	listings   []time.Time // times of listings in the last scanWindow
+	lastRead   time.Time   // when a file was last opened
+	stormUntil time.Time   // when the current storm ends
+}
+
+// newScanGuard makes a scanGuard which detects a storm when there are
+// threshold listings in scanWindow, returning nil if threshold is 0
+func newScanGuard(threshold int) *scanGuard {
+	if threshold <= 0 {
+		return nil
+	}
+	return &scanGuard{threshold: threshold}
+}
+
+// listed records a listing at now
+func (g *scanGuard) listed(now time.Time) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	cutoff := now.Add(-scanWindow)
+	i := 0
+	for i < len(g.listings) && g.listings[i].Before(cutoff) {
+		i++
+	}
+	g.listings = append(g.listings[i:], now)
+	if len(g.listings) < g.threshold || g.lastRead.After(cutoff) {
+		return
+	}
+	if !now.Before(g.stormUntil) {
+		fs.Infof(nil, "realdebrid: scan storm detected, deferring refreshes")
+	}
+	g.stormUntil = now.Add(scanQuiet)
+}
+
+// read records that a file was opened at now which ends any storm
+func (g *scanGuard) read(now time.Time) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.lastRead = now
+	g.stormUntil = time.Time{}
+	g.mu.Unlock()
+}
+
+// stormingAt returns whether there is a scan storm at now
+func (g *scanGuard) stormingAt(now time.Time) bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return now.Before(g.stormUntil)
+}
+
+// storming returns whether there is a scan storm now
+func (g *scanGuard) storming() bool {
+	return g.stormingAt(time.Now())
+}
@@ -0,0 +1,36 @@
+package realdebrid
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// refreshJitter is how many seconds past the refresh interval the next
+// refresh is due, picked at random after each refresh so that instances
+// sharing an account don't all refresh at once. It is only accessed
+// atomically.
+var refreshJitter int64
+
+// jitter returns a random duration from 0 up to max
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// scheduleRefresh picks when the next refresh is due after one has
+// just been done, adding up to refresh_jitter to the interval
+func (f *Fs) scheduleRefresh() {
+	atomic.StoreInt64(&refreshJitter, int64(jitter(time.Duration(f.opt.RefreshJitter))/time.Second))
+}
+
+// repairBudget returns how many of the dead or broken torrents found
+// may be repaired in this refresh, or -1 for all of them
+func (f *Fs) repairBudget() int {
+	if f.opt.MaxRepairs <= 0 {
+		return -1
+	}
+	return f.opt.MaxRepairs
+}
@@ -0,0 +1,106 @@
+package realdebrid
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// crawling is 1 while a background crawl started by scoped_refresh is
+// running, only accessed atomically
+var crawling int32
+
+// revalidated holds the lastcheck at which each torrent was last read
+// again by scoped_refresh, by torrent ID
+var revalidated = map[string]int64{}
+var revalidatedMu sync.Mutex
+
+// refreshIsDue returns whether the library is due a full refresh
+func refreshIsDue() bool {
+	return time.Now().Unix()-atomic.LoadInt64(&lastcheck) > interval+atomic.LoadInt64(&refreshJitter)
+}
+
+// crawlInBackground starts a crawl of the whole library unless one is
+// running already
+func (f *Fs) crawlInBackground() {
+	if !atomic.CompareAndSwapInt32(&crawling, 0, 1) {
+		return
+	}
+	fs.Debugf(f, "Refreshing the library in the background")
+	go func() {
+		defer atomic.StoreInt32(&crawling, 0)
+		f.crawl(context.Background())
+	}()
+}
+
+// scopedRefresh brings the directory dirID up to date for
+// scoped_refresh when the library is due a refresh
+//
+// A torrent folder is read again on its own so its files are up to
+// date, and the rest of the library is crawled in the background
+// instead of holding up the listing.
+func (f *Fs) scopedRefresh(ctx context.Context, dirID string) {
+	if !refreshIsDue() || !f.canRunMaintenance() {
+		return
+	}
+	listMu.RLock()
+	isTorrent := torrentIndex(dirID) >= 0
+	listMu.RUnlock()
+	if isTorrent {
+		f.revalidateTorrent(ctx, dirID)
+	}
+	f.crawlInBackground()
+}
+
+// revalidateTorrent reads the torrent with ID id again and updates it
+// in the library, once per refresh interval
+func (f *Fs) revalidateTorrent(ctx context.Context, id string) {
+	check := atomic.LoadInt64(&lastcheck)
+	revalidatedMu.Lock()
+	done := revalidated[id] == check
+	revalidated[id] = check
+	revalidatedMu.Unlock()
+	if done {
+		return
+	}
+	opts := rest.Opts{
+		Method:     "GET",
+		Path:       "/torrents/info/" + id,
+		Parameters: f.baseParams(),
+	}
+	var info api.Item
+	var resp *http.Response
+	err := f.pacer.Call(func() (bool, error) {
+		var err error
+		resp, err = f.srv.CallJSON(ctx, &opts, nil, &info)
+		return shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		// the background crawl will sort it out
+		fs.Debugf(f, "Torrent %s: couldn't read it again: %v", id, err)
+		return
+	}
+	listMu.Lock()
+	defer listMu.Unlock()
+	if i := torrentIndex(id); i >= 0 {
+		updateTorrent(&torrents[i], &info)
+	}
+}
+
+// updateTorrent updates torrent from info read from /torrents/info
+func updateTorrent(torrent, info *api.Item) {
+	torrent.Status = info.Status
+	torrent.Progress = info.Progress
+	if info.Bytes > 0 {
+		torrent.Bytes = info.Bytes
+	}
+	if info.Links != nil {
+		torrent.Links = info.Links
+	}
+}
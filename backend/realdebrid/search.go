@@ -0,0 +1,110 @@
+package realdebrid
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+)
+
+// defaultSearchLimit is the default number of results search returns
+const defaultSearchLimit = 100
+
+// searchResult is a torrent folder or file matching a search
+type searchResult struct {
+	Path      string `json:"path"`
+	Type      string `json:"type"`
+	TorrentID string `json:"torrent_id"`
+	Size      int64  `json:"size"`
+}
+
+// searchWords splits s into lower case words, treating anything which
+// isn't a letter or a digit as a separator so "Breath.of.the.Wild"
+// has the same words as "breath of the wild"
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchesWords returns whether every one of words is found in the
+// words of name, either whole or as the start of one
+func matchesWords(name string, words []string) bool {
+	nameWords := searchWords(name)
+outer:
+	for _, word := range words {
+		for _, nameWord := range nameWords {
+			if strings.HasPrefix(nameWord, word) {
+				continue outer
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// search returns the torrent folders and files whose names contain all
+// the words of query, at most limit of them
+//
+// Torrents matching come first, then files matching inside torrents
+// which don't. It only looks at the library in memory so it makes no
+// API calls.
+func (f *Fs) search(query string, limit int) ([]searchResult, error) {
+	words := searchWords(query)
+	if len(words) == 0 {
+		return nil, errors.New("need something to search for")
+	}
+	listMu.RLock()
+	defer listMu.RUnlock()
+	var folders, files []searchResult
+	for i := range torrents {
+		torrent := &torrents[i]
+		dir := f.torrentPath(torrent)
+		if matchesWords(torrent.Name, words) {
+			folders = append(folders, searchResult{
+				Path:      dir,
+				Type:      api.ItemTypeFolder,
+				TorrentID: torrent.ID,
+				Size:      torrent.Bytes,
+			})
+			continue
+		}
+		for _, link := range torrent.Links {
+			j, ok := cachedLinks[link]
+			if !ok || !matchesWords(cached[j].Name, words) {
+				continue
+			}
+			files = append(files, searchResult{
+				Path:      path.Join(dir, f.standardName(cached[j].Name, cached[j].ID)),
+				Type:      api.ItemTypeFile,
+				TorrentID: torrent.ID,
+				Size:      cached[j].Size,
+			})
+		}
+	}
+	sort.SliceStable(folders, func(i, j int) bool { return folders[i].Path < folders[j].Path })
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	out := append(folders, files...)
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// searchCommand runs the search backend command
+func (f *Fs) searchCommand(arg []string, opt map[string]string) (interface{}, error) {
+	limit := defaultSearchLimit
+	if s, ok := opt["limit"]; ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("bad limit %q", s)
+		}
+		limit = n
+	}
+	return f.search(strings.Join(arg, " "), limit)
+}
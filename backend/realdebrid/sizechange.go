@@ -0,0 +1,60 @@
+package realdebrid
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// maxSizeChanges is the number of size changes kept for stats
+const maxSizeChanges = 20
+
+// errSizeChanged is returned by reads of a file whose size changed when
+// its link was unrestricted again, as the rest of the data may not
+// match what was read already
+var errSizeChanged = errors.New("file size changed when its link was regenerated")
+
+// sizeChange records a file whose size changed
+type sizeChange struct {
+	File string    `json:"file"`
+	Old  int64     `json:"old"`
+	New  int64     `json:"new"`
+	Time time.Time `json:"time"`
+}
+
+// sizeChanges holds the most recent size changes, newest last
+var sizeChanges []sizeChange
+var sizeChangesMu sync.Mutex
+
+// recentSizeChanges returns a copy of sizeChanges
+func recentSizeChanges() []sizeChange {
+	sizeChangesMu.Lock()
+	defer sizeChangesMu.Unlock()
+	return append([]sizeChange(nil), sizeChanges...)
+}
+
+// checkSize returns an error wrapping errSizeChanged if item, just
+// unrestricted again for o, has a different size to o
+//
+// The change is logged and recorded for stats, and any blocks of o in
+// the disk cache are dropped.
+func (o *Object) checkSize(item *api.Item) error {
+	if item.Size <= 0 || o.size <= 0 || item.Size == o.size {
+		return nil
+	}
+	fs.Errorf(o, "Size changed from %d to %d when the link was regenerated - failing the open file", o.size, item.Size)
+	sizeChangesMu.Lock()
+	sizeChanges = append(sizeChanges, sizeChange{File: o.remote, Old: o.size, New: item.Size, Time: time.Now()})
+	if len(sizeChanges) > maxSizeChanges {
+		sizeChanges = sizeChanges[len(sizeChanges)-maxSizeChanges:]
+	}
+	sizeChangesMu.Unlock()
+	if o.fs.cache != nil {
+		o.fs.cache.invalidate(cacheKey(o), o.size)
+	}
+	return fmt.Errorf("%w: was %d bytes, now %d", errSizeChanged, o.size, item.Size)
+}
@@ -0,0 +1,143 @@
+package realdebrid
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// downloadSlots counts the downloads streaming from RealDebrid and, if
+// max_downloads is set, makes new ones wait for a free slot
+type downloadSlots struct {
+	mu      sync.Mutex
+	limit   int           // most downloads at once, 0 for no limit
+	timeout time.Duration // how long to wait for a slot, 0 for as long as it takes
+	free    chan struct{} // signalled when a slot is released
+	open    int           // downloads streaming now
+	queued  int           // downloads waiting for a slot
+	peak    int           // most downloads streaming at once
+}
+
+// slotStats is the download slot usage shown by the stats command
+type slotStats struct {
+	Open   int `json:"open"`
+	Queued int `json:"queued"`
+	Peak   int `json:"peak"`
+	Limit  int `json:"limit,omitempty"`
+}
+
+// newDownloadSlots makes a downloadSlots for limit downloads at once
+// waiting up to timeout for one
+func newDownloadSlots(limit int, timeout time.Duration) *downloadSlots {
+	if limit < 0 {
+		limit = 0
+	}
+	return &downloadSlots{
+		limit:   limit,
+		timeout: timeout,
+		free:    make(chan struct{}, 1),
+	}
+}
+
+// take claims a slot if one is free, returning whether it did
+//
+// Call with s.mu held.
+func (s *downloadSlots) take() bool {
+	if s.limit > 0 && s.open >= s.limit {
+		return false
+	}
+	s.open++
+	if s.open > s.peak {
+		s.peak = s.open
+	}
+	return true
+}
+
+// acquire waits for a free slot and claims it
+//
+// It fails if none becomes free within the timeout, so the caller
+// gets a clear error rather than whatever RealDebrid returns when
+// there are too many downloads on the account.
+func (s *downloadSlots) acquire(ctx context.Context) error {
+	s.mu.Lock()
+	if s.take() {
+		s.mu.Unlock()
+		return nil
+	}
+	s.queued++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.queued--
+		s.mu.Unlock()
+	}()
+	var timeout <-chan time.Time
+	if s.timeout > 0 {
+		timer := time.NewTimer(s.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		select {
+		case <-s.free:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return fmt.Errorf("all %d download slots in use for %v", s.limit, s.timeout)
+		}
+		s.mu.Lock()
+		ok := s.take()
+		if s.queued > 1 && (s.limit == 0 || s.open < s.limit) {
+			// more slots are free so wake up the next waiter too
+			s.signal()
+		}
+		s.mu.Unlock()
+		if ok {
+			return nil
+		}
+	}
+}
+
+// signal wakes up one waiter if there are any
+func (s *downloadSlots) signal() {
+	select {
+	case s.free <- struct{}{}:
+	default:
+	}
+}
+
+// release gives back a slot claimed by acquire
+func (s *downloadSlots) release() {
+	s.mu.Lock()
+	s.open--
+	s.mu.Unlock()
+	s.signal()
+}
+
+// stats returns the slot usage
+func (s *downloadSlots) stats() *slotStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &slotStats{
+		Open:   s.open,
+		Queued: s.queued,
+		Peak:   s.peak,
+		Limit:  s.limit,
+	}
+}
+
+// slotReader releases its download slot when it is closed
+type slotReader struct {
+	io.ReadCloser
+	once  sync.Once
+	slots *downloadSlots
+}
+
+// Close closes the download and releases its slot once
+func (r *slotReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.slots.release)
+	return err
+}
@@ -0,0 +1,105 @@
+package realdebrid
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// ruleResult is the outcome of one sorting rule in sort-test
+type ruleResult struct {
+	Rule    string `json:"rule"`
+	Pattern string `json:"pattern"`
+	Matched bool   `json:"matched"`
+	Match   string `json:"match,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// sortTestResult is the output of the sort-test command
+type sortTestResult struct {
+	Name     string       `json:"name"`
+	Category string       `json:"category"`
+	Path     string       `json:"path"`
+	Hidden   bool         `json:"hidden"`
+	Parsed   releaseInfo  `json:"parsed"`
+	Rules    []ruleResult `json:"rules"`
+}
+
+// testRule runs name through the rule called rule with pattern
+func testRule(rule, pattern, name string) (ruleResult, error) {
+	r := ruleResult{Rule: rule, Pattern: pattern}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return r, fmt.Errorf("bad %s: %w", rule, err)
+	}
+	if loc := re.FindStringIndex(name); loc != nil {
+		r.Matched = true
+		r.Match = name[loc[0]:loc[1]]
+		r.Reason = fmt.Sprintf("matched %q", r.Match)
+	} else {
+		r.Reason = "no match"
+	}
+	return r, nil
+}
+
+// sortTest shows how a torrent called name would be sorted in
+// "folders" folder_mode, rule by rule
+func (f *Fs) sortTest(name string) (*sortTestResult, error) {
+	out := &sortTestResult{Name: name, Parsed: f.parseName(name)}
+	for _, rule := range f.ruleFolders() {
+		r, _ := testRule("regex_folders", rule.re.String(), name)
+		if r.Matched {
+			r.Reason += " so sorted into " + rule.path
+			out.Rules = append(out.Rules, r)
+			out.Category = rule.path
+			break
+		}
+		out.Rules = append(out.Rules, r)
+	}
+	shows, err := testRule("regex_shows", f.opt.RegexShows, name)
+	if err != nil {
+		return nil, err
+	}
+	out.Rules = append(out.Rules, shows)
+	movies, err := testRule("regex_movies", f.opt.RegexMovies, name)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case out.Category != "":
+		shows.Reason += " but regex_folders matched first"
+		movies.Reason += " but regex_folders matched first"
+	case shows.Matched:
+		out.Category = "shows"
+		if movies.Matched {
+			movies.Reason += " but regex_shows matched first"
+		} else {
+			movies.Reason += " and not needed as regex_shows matched"
+		}
+	case movies.Matched:
+		out.Category = "movies"
+	}
+	out.Rules = append(out.Rules, movies)
+	if out.Category == "" && f.sortRules().parser != nil {
+		parsed := ruleResult{Rule: "name_parser", Pattern: f.opt.NameParser, Reason: "no year or episode found"}
+		if category, ok := out.Parsed.category(); ok {
+			parsed.Matched = true
+			parsed.Reason = "parsed so sorted into " + category
+			out.Category = category
+		}
+		out.Rules = append(out.Rules, parsed)
+	}
+	if out.Category == "" {
+		out.Category = "default"
+	}
+	out.Path = path.Join(out.Category, f.standardName(name, name))
+	remote := out.Path + "/"
+	if f.rootInclude != nil && !f.rootInclude.MatchString(remote) {
+		out.Hidden = true
+		out.Rules = append(out.Rules, ruleResult{Rule: "root_include", Pattern: f.rootInclude.String(), Reason: "path not matched so hidden"})
+	} else if f.rootExclude != nil && f.rootExclude.MatchString(remote) {
+		out.Hidden = true
+		out.Rules = append(out.Rules, ruleResult{Rule: "root_exclude", Pattern: f.rootExclude.String(), Matched: true, Reason: "path matched so hidden"})
+	}
+	return out, nil
+}
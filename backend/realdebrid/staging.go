@@ -0,0 +1,121 @@
+package realdebrid
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// stagedObject is a file stored in the staging_remote which is shown in
+// the library at remote
+type stagedObject struct {
+	fs.Object
+	f      *Fs
+	remote string
+}
+
+// Fs returns the parent Fs
+func (o *stagedObject) Fs() fs.Info {
+	return o.f
+}
+
+// Remote returns the remote path in the library
+func (o *stagedObject) Remote() string {
+	return o.remote
+}
+
+// String returns a description of the Object
+func (o *stagedObject) String() string {
+	return o.remote
+}
+
+// UnWrap returns the Object in the staging_remote
+func (o *stagedObject) UnWrap() fs.Object {
+	return o.Object
+}
+
+// stagedInfo is src stored under remote in the staging_remote
+type stagedInfo struct {
+	fs.ObjectInfo
+	remote string
+}
+
+// Remote returns the path in the staging_remote
+func (i stagedInfo) Remote() string {
+	return i.remote
+}
+
+// stagingPath returns the path of remote in the staging_remote
+func (f *Fs) stagingPath(remote string) string {
+	return path.Join(f.root, remote)
+}
+
+// newStagedObject finds the file at remote in the staging_remote
+func (f *Fs) newStagedObject(ctx context.Context, remote string) (fs.Object, error) {
+	o, err := f.staging.NewObject(ctx, f.stagingPath(remote))
+	if err != nil {
+		return nil, err
+	}
+	return &stagedObject{Object: o, f: f, remote: remote}, nil
+}
+
+// putStaged stores the file being uploaded in the staging_remote
+func (f *Fs) putStaged(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	remote := src.Remote()
+	o, err := f.staging.Put(ctx, in, stagedInfo{ObjectInfo: src, remote: f.stagingPath(remote)}, options...)
+	if err != nil {
+		return nil, err
+	}
+	fs.Debugf(f, "Staged %q in %v", remote, f.staging)
+	return &stagedObject{Object: o, f: f, remote: remote}, nil
+}
+
+// listStaged lists the files and directories in dir of the
+// staging_remote as entries of the library
+func (f *Fs) listStaged(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	staged, err := f.staging.List(ctx, f.stagingPath(dir))
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range staged {
+		remote := path.Join(dir, path.Base(entry.Remote()))
+		switch x := entry.(type) {
+		case fs.Object:
+			entries = append(entries, &stagedObject{Object: x, f: f, remote: remote})
+		case fs.Directory:
+			entries = append(entries, fs.NewDirCopy(ctx, x).SetRemote(remote))
+		}
+	}
+	return entries, nil
+}
+
+// mergeStaged adds the entries of dir in the staging_remote to entries,
+// leaving out any which have the same name as one from RealDebrid
+func (f *Fs) mergeStaged(ctx context.Context, dir string, entries fs.DirEntries) (fs.DirEntries, error) {
+	staged, err := f.listStaged(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[f.nameKey(path.Base(entry.Remote()))] = true
+	}
+	for _, entry := range staged {
+		if !names[f.nameKey(path.Base(entry.Remote()))] {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Object          = (*stagedObject)(nil)
+	_ fs.ObjectUnWrapper = (*stagedObject)(nil)
+)
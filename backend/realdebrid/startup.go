@@ -0,0 +1,102 @@
+package realdebrid
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// warming returns whether the background crawl started by
+// async_startup is still running
+func (f *Fs) warming() bool {
+	if f.warm == nil {
+		return false
+	}
+	select {
+	case <-f.warm:
+		return false
+	default:
+		return true
+	}
+}
+
+// fetchAll reads every page of the listing at path
+func (f *Fs) fetchAll(ctx context.Context, path string) (result []api.Item, err error) {
+	opts := rest.Opts{
+		Method:     "GET",
+		Path:       path,
+		Parameters: f.baseParams(),
+	}
+	opts.Parameters.Set("limit", "2500")
+	totalcount := 1
+	for len(result) < totalcount {
+		var partialresult []api.Item
+		var resp *http.Response
+		err = f.pacer.Call(func() (bool, error) {
+			partialresult = nil
+			resp, err = f.srv.CallJSON(ctx, &opts, nil, &partialresult)
+			return shouldRetry(ctx, resp, err)
+		})
+		if err != nil {
+			return nil, err
+		}
+		account := f.accounts.tag(partialresult, resp)
+		if len(result) > 0 && result[0].Account != account {
+			fs.Debugf(f, "Account changed while listing %s, starting again", path)
+			result = nil
+			opts.Parameters.Del("offset")
+			totalcount = 1
+			continue
+		}
+		totalcount, err = strconv.Atoi(resp.Header.Get("X-Total-Count"))
+		if err != nil {
+			return nil, fmt.Errorf("bad X-Total-Count: %w", err)
+		}
+		if len(partialresult) == 0 {
+			break
+		}
+		result = append(result, partialresult...)
+		opts.Parameters.Set("offset", strconv.Itoa(len(result)))
+	}
+	return result, nil
+}
+
+// warmUp crawls the library in the background for async_startup
+//
+// The root is served from the state loaded from the state_file while
+// this runs. The crawl is done without holding listMu so listings
+// aren't held up, and the results are swapped in at the end.
+func (f *Fs) warmUp(ctx context.Context) {
+	defer close(f.warm)
+	f.crawl(ctx)
+}
+
+// crawl reads the whole library without holding listMu and swaps it in
+// as refreshLibrary does
+//
+// Call without listMu held.
+func (f *Fs) crawl(ctx context.Context) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+	start := time.Now()
+	newcached, err := f.fetchAll(ctx, "/downloads")
+	if err == nil {
+		var newtorrents []api.Item
+		newtorrents, err = f.fetchAll(ctx, "/torrents")
+		if err == nil {
+			f.swapLibrary(ctx, newcached, newtorrents, true)
+			f.saveState()
+			fs.Infof(f, "Background crawl found %d torrents in %v", len(newtorrents), time.Since(start).Round(time.Millisecond))
+			return
+		}
+	}
+	if _, err = f.refreshFailed(err); err != nil {
+		fs.Errorf(f, "Background crawl failed: %v", err)
+	}
+}
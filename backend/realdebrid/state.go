@@ -0,0 +1,623 @@
+package realdebrid
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// snapshotVersion is the version of the librarySnapshot format. When
+// the format changes bump it and add a migration from the version
+// before to snapshotMigrations.
+const snapshotVersion = 3
+
+// rejectedSuffix is added to the name of the state_file, followed by
+// the time, for the files the parts of it which couldn't be loaded are
+// moved to
+const rejectedSuffix = ".rejected"
+
+// infoHashRe matches a lower case torrent info hash
+var infoHashRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// snapshotRules are the sorting rules stored in a librarySnapshot
+//
+// RegexFolders is nil in snapshots made before it was stored, so the
+// rules in use are kept when they are restored.
+type snapshotRules struct {
+	RegexShows   string          `json:"regex_shows"`
+	RegexMovies  string          `json:"regex_movies"`
+	RegexFolders fs.CommaSepList `json:"regex_folders"`
+	NameParser   string          `json:"name_parser,omitempty"`
+}
+
+// librarySnapshot is a portable copy of the complete library state
+type librarySnapshot struct {
+	Version   int                      `json:"version"`
+	Created   time.Time                `json:"created"`
+	Rules     snapshotRules            `json:"rules"`
+	Torrents  []api.Item               `json:"torrents"`
+	Links     []api.Item               `json:"links"`
+	Broken    []string                 `json:"broken"`
+	Repairs   map[string]brokenTorrent `json:"repairs,omitempty"`
+	ModTimes  map[string]int64         `json:"mod_times,omitempty"`
+	Opened    map[string]int64         `json:"opened,omitempty"`
+	Accessed  map[string]int64         `json:"accessed,omitempty"`
+	Tags      map[string][]string      `json:"tags,omitempty"`
+	Orphaned  []api.Item               `json:"orphaned,omitempty"`
+	Legacy    []string                 `json:"legacy,omitempty"`
+	TakenDown []takedown               `json:"taken_down,omitempty"`
+	Names     map[string]string        `json:"names,omitempty"`
+	Plays     map[string]int64         `json:"plays,omitempty"`
+	Finished  map[string]int64         `json:"finished,omitempty"`
+	Locked    []string                 `json:"locked,omitempty"`
+	Frozen    map[string]string        `json:"frozen,omitempty"`
+	Placed    map[string]string        `json:"placed,omitempty"`
+	FileIDs   map[string][]int64       `json:"file_ids,omitempty"`
+}
+
+// copyTimes returns a copy of times
+func copyTimes(times map[string]int64) map[string]int64 {
+	out := make(map[string]int64, len(times))
+	for key, t := range times {
+		out[key] = t
+	}
+	return out
+}
+
+// modTimes pins the modification time of each file to when it was
+// first seen, by modTimeKey, so that regenerated links and repaired
+// torrents don't make unchanged files look modified.
+var modTimes = map[string]int64{}
+var modTimesMu sync.Mutex
+
+// stateMu serialises writes of the state_file
+var stateMu sync.Mutex
+
+// stateLoaded makes sure the state_file is only loaded once as
+// the state is shared by all the Fs
+var stateLoaded sync.Once
+
+// modTimeKey returns the key in modTimes for a file
+//
+// Torrent files are identified by the lower case torrent hash and their
+// RealDebrid file ID, which survive repairs, renames, conflict
+// resolution and selecting more files of the torrent, other files by
+// their hoster link. It returns "" for files with neither, e.g. files
+// which aren't selected.
+func modTimeKey(hash string, fileID int64, link string) string {
+	if hash != "" && fileID > 0 {
+		return strings.ToLower(hash) + "/" + strconv.FormatInt(fileID, 10)
+	}
+	return link
+}
+
+// pinModTime returns the pinned modification time for key, pinning it
+// to modTime if it hasn't been seen before which is reported in added
+//
+// legacy are the keys the file may have had before, e.g. when torrent
+// files were keyed by torrent hash and name or when the file ID wasn't
+// known. What was kept under the first of them found is moved to key
+// the first time the file is seen so it isn't lost.
+func pinModTime(key string, modTime int64, legacy ...string) (pinned int64, added bool) {
+	modTimesMu.Lock()
+	defer modTimesMu.Unlock()
+	if pinned, ok := modTimes[key]; ok {
+		return pinned, false
+	}
+	for _, old := range legacy {
+		pinned, ok := modTimes[old]
+		if !ok || old == "" || old == key {
+			continue
+		}
+		moveKey(old, key)
+		return pinned, true
+	}
+	modTimes[key] = modTime
+	return modTime, true
+}
+
+// moveKey moves what is kept for a file under the key old to key,
+// keeping what is under key already
+//
+// Call with modTimesMu held.
+func moveKey(old, key string) {
+	openedMu.Lock()
+	defer openedMu.Unlock()
+	for _, m := range []map[string]int64{modTimes, accessed, plays, finished} {
+		if n, ok := m[old]; ok {
+			delete(m, old)
+			if _, ok := m[key]; !ok {
+				m[key] = n
+			}
+		}
+	}
+}
+
+// positionKey returns the key of the file with number, its position
+// among the links of the torrent from 1, in state_files of version 2
+// which is waiting for the file ID to be known
+func positionKey(hash string, number int) string {
+	return strings.ToLower(hash) + "/#" + strconv.Itoa(number)
+}
+
+// adoptPositionKeys moves what is kept under the positionKey of each
+// file of the torrent with hash to its modTimeKey, ids being the file
+// IDs of its links
+func adoptPositionKeys(hash string, ids []int64) {
+	if hash == "" {
+		return
+	}
+	modTimesMu.Lock()
+	defer modTimesMu.Unlock()
+	for i, id := range ids {
+		moveKey(positionKey(hash, i+1), modTimeKey(hash, id, ""))
+	}
+}
+
+// setModTime changes the pinned modification time for key
+func setModTime(key string, modTime int64) {
+	modTimesMu.Lock()
+	modTimes[key] = modTime
+	modTimesMu.Unlock()
+}
+
+// snapshot makes a librarySnapshot of the current state
+func (f *Fs) snapshot() *librarySnapshot {
+	listMu.RLock()
+	s := &librarySnapshot{
+		Version: snapshotVersion,
+		Created: time.Now(),
+		Rules: snapshotRules{
+			RegexShows:   f.opt.RegexShows,
+			RegexMovies:  f.opt.RegexMovies,
+			RegexFolders: append(fs.CommaSepList{}, f.opt.RegexFolders...),
+			NameParser:   f.opt.NameParser,
+		},
+		Torrents: append([]api.Item{}, torrents...),
+		Links:    append([]api.Item{}, cached...),
+		Orphaned: append([]api.Item(nil), orphans...),
+	}
+	listMu.RUnlock()
+	brokenMu.Lock()
+	s.Broken = make([]string, 0, len(brokenTorrents))
+	s.Repairs = make(map[string]brokenTorrent, len(brokenTorrents))
+	for id, b := range brokenTorrents {
+		s.Broken = append(s.Broken, id)
+		s.Repairs[id] = b
+	}
+	brokenMu.Unlock()
+	sort.Strings(s.Broken)
+	modTimesMu.Lock()
+	s.ModTimes = copyTimes(modTimes)
+	modTimesMu.Unlock()
+	openedMu.Lock()
+	s.Opened = copyTimes(opened)
+	s.Accessed = copyTimes(accessed)
+	s.Plays = copyTimes(plays)
+	s.Finished = copyTimes(finished)
+	openedMu.Unlock()
+	tagsMu.Lock()
+	s.Tags = make(map[string][]string, len(tags))
+	for hash, t := range tags {
+		s.Tags[hash] = append([]string(nil), t...)
+	}
+	tagsMu.Unlock()
+	s.Legacy = legacyLinks()
+	s.TakenDown = takenDown()
+	s.Names = copyNames()
+	s.Locked = lockedList()
+	locksMu.Lock()
+	s.Frozen = make(map[string]string, len(frozen))
+	for name, category := range frozen {
+		s.Frozen[name] = category
+	}
+	locksMu.Unlock()
+	s.Placed = copyPlacements()
+	s.FileIDs = copyFileIDs()
+	return s
+}
+
+// restore replaces the current state with the snapshot s
+func (f *Fs) restore(s *librarySnapshot) error {
+	if s.Version > snapshotVersion {
+		return fmt.Errorf("snapshot version %d is newer than the supported version %d", s.Version, snapshotVersion)
+	}
+	shows, movies := f.opt.RegexShows, f.opt.RegexMovies
+	if s.Rules.RegexShows != "" {
+		shows = s.Rules.RegexShows
+	}
+	if s.Rules.RegexMovies != "" {
+		movies = s.Rules.RegexMovies
+	}
+	rules := f.sortRules()
+	folders, parser := rules.folders, rules.parser
+	var err error
+	if s.Rules.RegexFolders != nil {
+		folders, err = parseRuleFolders(s.Rules.RegexFolders)
+		if err != nil {
+			return fmt.Errorf("snapshot has %w", err)
+		}
+	}
+	sorter, err := newRegexSorter(folders, shows, movies)
+	if err != nil {
+		return fmt.Errorf("snapshot has %w", err)
+	}
+	if s.Rules.NameParser != "" {
+		parser, err = newNameParser(context.Background(), s.Rules.NameParser)
+		if err != nil {
+			return fmt.Errorf("snapshot has bad name_parser: %w", err)
+		}
+	}
+	listMu.Lock()
+	torrents = s.Torrents
+	cached = s.Links
+	indexCached()
+	orphans = s.Orphaned
+	f.accounts.own(cached, torrents)
+	if s.Rules.RegexShows != "" {
+		f.opt.RegexShows = s.Rules.RegexShows
+		f.m.Set("regex_shows", s.Rules.RegexShows)
+	}
+	if s.Rules.RegexMovies != "" {
+		f.opt.RegexMovies = s.Rules.RegexMovies
+		f.m.Set("regex_movies", s.Rules.RegexMovies)
+	}
+	if s.Rules.RegexFolders != nil {
+		f.opt.RegexFolders = s.Rules.RegexFolders
+		if f.m != nil {
+			f.m.Set("regex_folders", s.Rules.RegexFolders.String())
+		}
+	}
+	if s.Rules.NameParser != "" {
+		f.opt.NameParser = s.Rules.NameParser
+		if f.m != nil {
+			f.m.Set("name_parser", s.Rules.NameParser)
+		}
+	}
+	f.setSortRules(folders, sorter, parser)
+	listMu.Unlock()
+	brokenMu.Lock()
+	brokenTorrents = map[string]brokenTorrent{}
+	for _, id := range s.Broken {
+		brokenTorrents[id] = s.Repairs[id]
+	}
+	brokenMu.Unlock()
+	if s.ModTimes != nil {
+		modTimesMu.Lock()
+		modTimes = s.ModTimes
+		modTimesMu.Unlock()
+	}
+	openedMu.Lock()
+	if s.Opened != nil {
+		opened = s.Opened
+	}
+	if s.Accessed != nil {
+		accessed = s.Accessed
+	}
+	if s.Plays != nil {
+		plays = s.Plays
+	}
+	if s.Finished != nil {
+		finished = s.Finished
+	}
+	openedMu.Unlock()
+	if s.Tags != nil {
+		tagsMu.Lock()
+		tags = s.Tags
+		tagsMu.Unlock()
+	}
+	legacyMu.Lock()
+	legacy = map[string]bool{}
+	for _, link := range s.Legacy {
+		legacy[link] = true
+	}
+	legacyMu.Unlock()
+	takedownsMu.Lock()
+	takedowns = map[string]takedown{}
+	for _, t := range s.TakenDown {
+		takedowns[t.Link] = t
+	}
+	takedownsMu.Unlock()
+	if s.Names != nil {
+		namesMu.Lock()
+		names = s.Names
+		namesMu.Unlock()
+	}
+	locksMu.Lock()
+	lockedFolders = map[string]bool{}
+	for _, dir := range s.Locked {
+		lockedFolders[dir] = true
+	}
+	frozen = map[string]string{}
+	for name, category := range s.Frozen {
+		frozen[name] = category
+	}
+	locksMu.Unlock()
+	placementsMu.Lock()
+	placements = map[string]string{}
+	for name, category := range s.Placed {
+		placements[name] = category
+	}
+	placementsMu.Unlock()
+	fileIDsMu.Lock()
+	fileIDs = map[string][]int64{}
+	for id, ids := range s.FileIDs {
+		fileIDs[id] = ids
+	}
+	fileIDsMu.Unlock()
+	return nil
+}
+
+// rejectedEntry is an entry of a snapshot which failed validation
+type rejectedEntry struct {
+	Store  string      `json:"store"`
+	Key    string      `json:"key,omitempty"`
+	Value  interface{} `json:"value"`
+	Reason string      `json:"reason"`
+}
+
+// validate removes the entries of s which can't be right, e.g. cut
+// short by a crash or mangled by hand editing, and returns them
+func (s *librarySnapshot) validate() (rejected []rejectedEntry) {
+	reject := func(store, key string, value interface{}, reason string) {
+		rejected = append(rejected, rejectedEntry{Store: store, Key: key, Value: value, Reason: reason})
+	}
+	seen := map[string]bool{}
+	kept := s.Torrents[:0]
+	for _, torrent := range s.Torrents {
+		switch {
+		case torrent.ID == "":
+			reject("torrents", "", torrent, "no torrent ID")
+		case seen[torrent.ID]:
+			reject("torrents", torrent.ID, torrent, "duplicate torrent ID")
+		default:
+			seen[torrent.ID] = true
+			kept = append(kept, torrent)
+		}
+	}
+	s.Torrents = kept
+	for hash, name := range s.Names {
+		if !infoHashRe.MatchString(hash) || name == "" {
+			reject("names", hash, name, "not an info hash and a name")
+			delete(s.Names, hash)
+		}
+	}
+	for name, category := range s.Frozen {
+		if name == "" || cleanDir(category) == "" {
+			reject("frozen", name, category, "not a torrent name and a folder")
+			delete(s.Frozen, name)
+		}
+	}
+	for name, category := range s.Placed {
+		if name == "" || cleanDir(category) == "" {
+			reject("placed", name, category, "not a torrent name and a folder")
+			delete(s.Placed, name)
+		}
+	}
+	locked := s.Locked[:0]
+	for _, dir := range s.Locked {
+		if cleanDir(dir) == "" {
+			reject("locked", "", dir, "not a folder")
+			continue
+		}
+		locked = append(locked, dir)
+	}
+	s.Locked = locked
+	return rejected
+}
+
+// rejectState moves the parts of the state_file which couldn't be
+// loaded to a rejected file next to it so they aren't lost. Each gets
+// a file of its own so a later rejection can't overwrite an earlier
+// one.
+func (f *Fs) rejectState(data []byte, reason string) {
+	base := f.opt.StateFile + rejectedSuffix + "." + time.Now().UTC().Format("20060102T150405Z")
+	fileName := base
+	for i := 2; ; i++ {
+		if _, err := os.Stat(fileName); os.IsNotExist(err) {
+			break
+		}
+		fileName = fmt.Sprintf("%s.%d", base, i)
+	}
+	fs.Logf(f, "Ignoring %s in state_file %q - moved to %q", reason, f.opt.StateFile, fileName)
+	err := writeFileAtomic(fileName, data)
+	if err != nil {
+		fs.Errorf(f, "Failed to save rejected state: %v", err)
+	}
+}
+
+// readSnapshot reads the snapshot stored in fileName, migrating it
+// if it is older, and returns the version it was stored as
+func readSnapshot(fileName string) (*librarySnapshot, int, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	s, version, err := decodeSnapshot(data)
+	if err != nil {
+		return nil, version, fmt.Errorf("failed to parse snapshot %q: %w", fileName, err)
+	}
+	return s, version, nil
+}
+
+// writeSnapshot writes s to fileName
+//
+// It writes to a temporary file first so a crash can't leave a half
+// written snapshot behind.
+func writeSnapshot(fileName string, s *librarySnapshot) error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fileName, data)
+}
+
+// loadState loads the state_file if it exists
+//
+// The sorting rules in it are ignored so they don't override the
+// config, and the next listing of the root refreshes the library as
+// the state may be out of date.
+func (f *Fs) loadState() error {
+	s, version, err := readSnapshot(f.opt.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		// most likely cut short so start again rather than fail
+		data, _ := ioutil.ReadFile(f.opt.StateFile)
+		f.rejectState(data, "malformed JSON")
+		forceRefresh()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if rejected := s.validate(); len(rejected) > 0 {
+		data, _ := json.MarshalIndent(rejected, "", "\t")
+		f.rejectState(data, fmt.Sprintf("%d malformed entries", len(rejected)))
+	}
+	if version < snapshotVersion {
+		f.upgradeState(s, version)
+	}
+	s.Rules = snapshotRules{}
+	err = f.restore(s)
+	if err != nil {
+		return err
+	}
+	forceRefresh()
+	fs.Debugf(f, "Loaded state from %q saved at %v", f.opt.StateFile, s.Created)
+	return nil
+}
+
+// upgradeState rewrites the state_file, stored as the older version,
+// as s in the current format. The old file is kept next to it with
+// the version added to its name in case the upgrade loses something.
+//
+// Only the instance keeping the state_file upgrades it, the others
+// read the old version until it does.
+func (f *Fs) upgradeState(s *librarySnapshot, version int) {
+	if f.opt.ReadOnly || fs.GetConfig(context.Background()).DryRun {
+		return
+	}
+	if !f.coord.isLeaderAt(time.Now()) {
+		fs.Debugf(f, "Not upgrading state_file as another instance keeps it")
+		return
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	backup := fmt.Sprintf("%s.v%d", f.opt.StateFile, version)
+	data, err := ioutil.ReadFile(f.opt.StateFile)
+	if err == nil {
+		err = writeFileAtomic(backup, data)
+	}
+	if err != nil {
+		fs.Errorf(f, "Not upgrading state_file as it couldn't be backed up: %v", err)
+		return
+	}
+	err = writeSnapshot(f.opt.StateFile, s)
+	if err != nil {
+		fs.Errorf(f, "Failed to upgrade state_file: %v", err)
+		return
+	}
+	fs.Logf(f, "Upgraded state_file %q from version %d to %d, the old one is in %q", f.opt.StateFile, version, snapshotVersion, backup)
+}
+
+// stateSaveDelay is how long saveStateSoon waits so the files pinned
+// by a run of listings are saved together
+const stateSaveDelay = 10 * time.Second
+
+// saveTimer is the save started by saveStateSoon which hasn't run yet,
+// protected by saveTimerMu
+var saveTimer *time.Timer
+var saveTimerMu sync.Mutex
+
+// saveStateSoon saves the state after stateSaveDelay unless a save is
+// already waiting, so listings aren't held up writing the state_file
+func (f *Fs) saveStateSoon() {
+	saveTimerMu.Lock()
+	defer saveTimerMu.Unlock()
+	if saveTimer == nil {
+		saveTimer = time.AfterFunc(stateSaveDelay, f.saveState)
+	}
+}
+
+// saveState writes the state to the state_file if set, and the
+// manifest_file if set
+//
+// Any save waiting from saveStateSoon is dropped as this saves it.
+func (f *Fs) saveState() {
+	saveTimerMu.Lock()
+	if saveTimer != nil {
+		saveTimer.Stop()
+		saveTimer = nil
+	}
+	saveTimerMu.Unlock()
+	if fs.GetConfig(context.Background()).DryRun {
+		fs.Debugf(f, "Not saving state as --dry-run is set")
+		return
+	}
+	if f.opt.ReadOnly {
+		return
+	}
+	f.writeManifest()
+	if f.opt.StateFile == "" {
+		return
+	}
+	if !f.coord.isLeaderAt(time.Now()) {
+		// edits of the state are refused by checkLeader so this
+		// should only be reached by what the leader saves too
+		fs.Errorf(f, "Not saving state_file: %v", errNotLeader)
+		return
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	err := writeSnapshot(f.opt.StateFile, f.snapshot())
+	if err != nil {
+		fs.Errorf(f, "Failed to save state: %v", err)
+	}
+}
+
+// exportLibrary returns a snapshot of the library, writing it to
+// fileName instead if that is set
+func (f *Fs) exportLibrary(ctx context.Context, fileName string) (interface{}, error) {
+	s := f.snapshot()
+	if fileName == "" {
+		return s, nil
+	}
+	err := writeSnapshot(fileName, s)
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("Exported %d torrents and %d links to %q", len(s.Torrents), len(s.Links), fileName), nil
+}
+
+// importLibrary restores the library from the snapshot in fileName
+func (f *Fs) importLibrary(ctx context.Context, fileName string) (interface{}, error) {
+	s, _, err := readSnapshot(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if rejected := s.validate(); len(rejected) > 0 {
+		fs.Logf(f, "Skipped %d malformed entries in snapshot %q", len(rejected), fileName)
+	}
+	err = f.restore(s)
+	if err != nil {
+		return nil, err
+	}
+	f.saveState()
+	fs.Infof(f, "Imported snapshot from %v", s.Created)
+	return fmt.Sprintf("Imported %d torrents and %d links from %q", len(s.Torrents), len(s.Links), fileName), nil
+}
@@ -0,0 +1,111 @@
+package realdebrid
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// defaultColdAfter is how long a torrent must not have been opened for
+// before the stats command counts it as cold
+const defaultColdAfter = 30 * 24 * time.Hour
+
+// maxRecent is the number of recently accessed files stats shows
+const maxRecent = 20
+
+// accessEntry is a file and when it was last opened
+type accessEntry struct {
+	File     string    `json:"file"`
+	Accessed time.Time `json:"accessed"`
+}
+
+// libraryStats is the output of the stats command
+type libraryStats struct {
+	Torrents     int           `json:"torrents"`
+	Bytes        int64         `json:"bytes"`
+	Links        int           `json:"links"`
+	Broken       int           `json:"broken"`
+	Pending      int           `json:"pending"`
+	Watched      int           `json:"watched"`
+	WatchedBytes int64         `json:"watched_bytes"`
+	Cold         int           `json:"cold"`
+	ColdBytes    int64         `json:"cold_bytes"`
+	ColdAfter    string        `json:"cold_after"`
+	Tag          string        `json:"tag,omitempty"`
+	Recent       []accessEntry `json:"recent,omitempty"`
+	LastUpdate   time.Time     `json:"last_update"`
+	Quota        *apiQuota     `json:"quota,omitempty"`
+	SizeChanges  []sizeChange  `json:"size_changes,omitempty"`
+	TakenDown    []takedown    `json:"taken_down,omitempty"`
+	Downloads    *slotStats    `json:"downloads"`
+	Played       []fileCount   `json:"played,omitempty"`
+}
+
+// stats works out the libraryStats counting torrents which haven't
+// been opened for coldAfter as cold, only counting torrents tagged
+// with tag if set
+func (f *Fs) stats(coldAfter time.Duration, tag string) *libraryStats {
+	s := &libraryStats{
+		ColdAfter: fs.Duration(coldAfter).String(),
+		Tag:       tag,
+	}
+	cutoff := time.Now().Add(-coldAfter).Unix()
+	listMu.RLock()
+	openedMu.Lock()
+	s.Links = len(cached)
+	for _, torrent := range torrents {
+		if tag != "" && !hasTag(torrent.TorrentHash, tag) {
+			continue
+		}
+		s.Torrents++
+		s.Bytes += torrent.Bytes
+		if opened[torrent.ID] >= cutoff {
+			s.Watched++
+			s.WatchedBytes += torrent.Bytes
+		} else {
+			s.Cold++
+			s.ColdBytes += torrent.Bytes
+		}
+	}
+	for file, t := range accessed {
+		s.Recent = append(s.Recent, accessEntry{File: file, Accessed: time.Unix(t, 0)})
+	}
+	openedMu.Unlock()
+	listMu.RUnlock()
+	sort.Slice(s.Recent, func(i, j int) bool {
+		return s.Recent[i].Accessed.After(s.Recent[j].Accessed)
+	})
+	if len(s.Recent) > maxRecent {
+		s.Recent = s.Recent[:maxRecent]
+	}
+	brokenMu.Lock()
+	s.Broken = len(brokenTorrents)
+	brokenMu.Unlock()
+	pendingMu.Lock()
+	s.Pending = len(pending)
+	pendingMu.Unlock()
+	s.LastUpdate = time.Unix(atomic.LoadInt64(&lastcheck), 0)
+	s.Quota = currentQuota()
+	s.SizeChanges = recentSizeChanges()
+	s.TakenDown = takenDown()
+	s.Downloads = f.slots.stats()
+	s.Played = fileCounts(maxRecent)
+	return s
+}
+
+// statsCommand runs the stats backend command
+func (f *Fs) statsCommand(ctx context.Context, opt map[string]string) (interface{}, error) {
+	coldAfter := defaultColdAfter
+	if s, ok := opt["cold"]; ok {
+		d, err := fs.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad cold: %w", err)
+		}
+		coldAfter = d
+	}
+	return f.stats(coldAfter, opt["tag"]), nil
+}
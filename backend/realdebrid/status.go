@@ -0,0 +1,59 @@
+package realdebrid
+
+import (
+	"context"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+)
+
+// torrentStatus is the output of the status command for one torrent
+type torrentStatus struct {
+	Path      string  `json:"path"`
+	Name      string  `json:"name"`
+	TorrentID string  `json:"torrent_id"`
+	Hash      string  `json:"hash"`
+	Status    string  `json:"status"`
+	Progress  float64 `json:"progress"`
+	Files     int     `json:"files"`
+	Bytes     int64   `json:"bytes"`
+	Broken    bool    `json:"broken,omitempty"`
+	TakenDown int     `json:"taken_down,omitempty"`
+}
+
+// newTorrentStatus makes the torrentStatus of torrent
+func (f *Fs) newTorrentStatus(torrent *api.Item) torrentStatus {
+	return torrentStatus{
+		Path:      f.torrentPath(torrent),
+		Name:      torrent.Name,
+		TorrentID: torrent.ID,
+		Hash:      torrent.TorrentHash,
+		Status:    torrent.Status,
+		Progress:  torrent.Progress,
+		Files:     len(torrent.Links),
+		Bytes:     torrent.Bytes,
+		Broken:    isBroken(torrent.ID),
+		TakenDown: torrentTakedowns(torrent),
+	}
+}
+
+// statusCommand runs the status backend command
+func (f *Fs) statusCommand(ctx context.Context, arg []string, opt map[string]string) (interface{}, error) {
+	if len(arg) > 0 {
+		torrent, err := f.torrentForPath(ctx, parsePath(arg[0]))
+		if err != nil {
+			return nil, err
+		}
+		return f.newTorrentStatus(torrent), nil
+	}
+	status, filter := opt["status"]
+	out := []torrentStatus{}
+	listMu.RLock()
+	defer listMu.RUnlock()
+	for i := range torrents {
+		if filter && torrents[i].Status != status {
+			continue
+		}
+		out = append(out, f.newTorrentStatus(&torrents[i]))
+	}
+	return out, nil
+}
@@ -0,0 +1,51 @@
+package realdebrid
+
+import "fmt"
+
+// torrentStatuses are the statuses RealDebrid gives torrents
+var torrentStatuses = []string{
+	"magnet_error",
+	"magnet_conversion",
+	"waiting_files_selection",
+	"queued",
+	"downloading",
+	"downloaded",
+	"error",
+	"virus",
+	"compressing",
+	"uploading",
+	"dead",
+}
+
+// checkStatuses returns an error if any of statuses isn't one of the
+// torrentStatuses
+func checkStatuses(statuses []string) error {
+	for _, status := range statuses {
+		known := false
+		for _, s := range torrentStatuses {
+			known = known || s == status
+		}
+		if !known {
+			return fmt.Errorf("unknown status %q", status)
+		}
+	}
+	return nil
+}
+
+// statusShown returns whether the torrents with status are listed in
+// the library, which they are if show_statuses is empty or has it.
+// Quarantined torrents are only ever listed in /.quarantine.
+func (f *Fs) statusShown(status string) bool {
+	if isQuarantined(status) {
+		return false
+	}
+	if len(f.opt.ShowStatuses) == 0 {
+		return true
+	}
+	for _, s := range f.opt.ShowStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
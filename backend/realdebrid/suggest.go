@@ -0,0 +1,179 @@
+package realdebrid
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Defaults for the sort-suggest command
+const (
+	defaultSuggestMin  = 2
+	defaultSuggestMax  = 20
+	maxSuggestExamples = 3
+)
+
+// groupTagRe matches a release group tag at the start of a name, e.g.
+// "[SubsPlease] Show - 01"
+var groupTagRe = regexp.MustCompile(`^\[([^\]]+)\]`)
+
+// episodicRe matches names which look like episodes of a show
+var episodicRe = regexp.MustCompile(`(?i)(\bS\d{1,2}E\d{1,3}\b|\bE\d{2,3}\b|\b\d{1,2}x\d{2}\b| - \d{2,4}\b)`)
+
+// wordRe matches the words of a name
+var wordRe = regexp.MustCompile(`[\p{L}\p{N}']+`)
+
+// separator matches a character between words, which unlike \b
+// includes "_"
+const separator = `[^\p{L}\p{N}']`
+
+// suggestion is a sorting rule proposed by sort-suggest
+type suggestion struct {
+	Rule     string   `json:"rule"`
+	Folder   string   `json:"folder"`
+	Regex    string   `json:"regex"`
+	Matched  int      `json:"matched"`
+	Examples []string `json:"examples"`
+}
+
+// suggestResult is the output of the sort-suggest command
+type suggestResult struct {
+	Unmatched   int          `json:"unmatched"`
+	Suggestions []suggestion `json:"suggestions"`
+}
+
+// cluster is a candidate rule and the names it was made from
+type cluster struct {
+	label string   // what the names have in common
+	regex string   // matches the names
+	names []string // names the cluster was made from
+}
+
+// clusters groups names by release group tag and by their first two
+// words, returning the candidate clusters by key
+func clusters(names []string) map[string]*cluster {
+	out := map[string]*cluster{}
+	add := func(key, label, regex, name string) {
+		c, ok := out[key]
+		if !ok {
+			c = &cluster{label: label, regex: regex}
+			out[key] = c
+		}
+		c.names = append(c.names, name)
+	}
+	for _, name := range names {
+		if m := groupTagRe.FindStringSubmatch(name); m != nil {
+			tag := strings.TrimSpace(m[1])
+			if tag != "" {
+				add("group:"+strings.ToLower(tag), tag, `(?i)^\[`+regexp.QuoteMeta(tag)+`\]`, name)
+			}
+			name = strings.TrimSpace(name[len(m[0]):])
+		}
+		words := wordRe.FindAllString(name, 3)
+		if len(words) < 2 {
+			continue
+		}
+		if _, err := strconv.Atoi(words[0]); err == nil {
+			// names starting with a number rarely share more
+			continue
+		}
+		label := words[0] + " " + words[1]
+		add("title:"+strings.ToLower(label), label, `(?i)(^|`+separator+`)`+regexp.QuoteMeta(words[0])+separator+`+`+regexp.QuoteMeta(words[1])+`(`+separator+`|$)`, name)
+	}
+	return out
+}
+
+// suggestRules proposes regex_folders rules for the names which no
+// sorting rule matched, each matching at least min names, at most max
+// of them, largest first
+//
+// Each name is only used for one rule, and the number matched is found
+// by running the rule against all the names.
+func suggestRules(names []string, min, max int) []suggestion {
+	var candidates []*cluster
+	for _, c := range clusters(names) {
+		if len(c.names) >= min {
+			candidates = append(candidates, c)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if len(candidates[i].names) != len(candidates[j].names) {
+			return len(candidates[i].names) > len(candidates[j].names)
+		}
+		return candidates[i].label < candidates[j].label
+	})
+	out := []suggestion{}
+	used := map[string]bool{}
+	for _, c := range candidates {
+		if len(out) >= max {
+			break
+		}
+		re := regexp.MustCompile(c.regex)
+		var matched []string
+		for _, name := range names {
+			if !used[name] && re.MatchString(name) {
+				matched = append(matched, name)
+			}
+		}
+		if len(matched) < min {
+			continue
+		}
+		episodic := 0
+		for _, name := range matched {
+			used[name] = true
+			if episodicRe.MatchString(name) {
+				episodic++
+			}
+		}
+		category := "default"
+		if episodic*2 > len(matched) {
+			category = "shows"
+		}
+		folder := category + "/" + strings.NewReplacer("/", " ", "=", " ", ",", " ").Replace(c.label)
+		s := suggestion{
+			Rule:    folder + "=" + c.regex,
+			Folder:  folder,
+			Regex:   c.regex,
+			Matched: len(matched),
+		}
+		if len(matched) > maxSuggestExamples {
+			matched = matched[:maxSuggestExamples]
+		}
+		s.Examples = matched
+		out = append(out, s)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Matched > out[j].Matched
+	})
+	return out
+}
+
+// sortSuggest proposes sorting rules for the torrents which end up in
+// default
+func (f *Fs) sortSuggest(ctx context.Context, opt map[string]string) (interface{}, error) {
+	min, max := defaultSuggestMin, defaultSuggestMax
+	for key, value := range map[string]*int{"min": &min, "max": &max} {
+		if s, ok := opt[key]; ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("bad %s %q", key, s)
+			}
+			*value = n
+		}
+	}
+	var names []string
+	listMu.RLock()
+	for _, torrent := range torrents {
+		if f.category(torrent.Name) == "default" {
+			names = append(names, torrent.Name)
+		}
+	}
+	listMu.RUnlock()
+	return &suggestResult{
+		Unmatched:   len(names),
+		Suggestions: suggestRules(names, min, max),
+	}, nil
+}
@@ -0,0 +1,82 @@
+package realdebrid
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// lastSweep is the unix time the statuses of all the torrents were
+// last read. It is only accessed atomically.
+var lastSweep int64
+
+// statusChange is a torrent whose status changed between two listings
+type statusChange struct {
+	ID   string
+	Name string
+	From string
+	To   string
+}
+
+// sweepIsDue returns whether the statuses of all the torrents should be
+// read again at now because status_sweep has passed since the last time
+func (f *Fs) sweepIsDue(now time.Time) bool {
+	if f.opt.StatusSweep <= 0 {
+		return false
+	}
+	last := time.Unix(atomic.LoadInt64(&lastSweep), 0)
+	return now.Sub(last) >= time.Duration(f.opt.StatusSweep)
+}
+
+// markSwept records that the statuses of all the torrents were read at
+// now
+func markSwept(now time.Time) {
+	atomic.StoreInt64(&lastSweep, now.Unix())
+}
+
+// isBadStatus returns whether status means the files of a torrent are
+// gone or never arrived
+func isBadStatus(status string) bool {
+	switch status {
+	case "dead", "error", "magnet_error", "virus":
+		return true
+	}
+	return false
+}
+
+// statusChanges returns the torrents in current which have gone into a
+// bad status since old
+func statusChanges(old, current []api.Item) (out []statusChange) {
+	if len(old) == 0 {
+		return nil
+	}
+	oldStatus := make(map[string]string, len(old))
+	for _, torrent := range old {
+		oldStatus[torrent.ID] = torrent.Status
+	}
+	for _, torrent := range current {
+		from, ok := oldStatus[torrent.ID]
+		if !ok || from == torrent.Status || !isBadStatus(torrent.Status) {
+			continue
+		}
+		out = append(out, statusChange{ID: torrent.ID, Name: torrent.Name, From: from, To: torrent.Status})
+	}
+	return out
+}
+
+// flagStatusChanges logs the torrents which went into a bad status
+// between old and current and queues the ones re-adding can fix for
+// repair, so they are fixed before anyone tries to play them
+//
+// A torrent flagged as a virus would only be flagged again so it is
+// just logged.
+func (f *Fs) flagStatusChanges(old, current []api.Item) {
+	for _, c := range statusChanges(old, current) {
+		fs.Logf(f, "Torrent %q went from %s to %s", c.Name, c.From, c.To)
+		if c.To != "virus" {
+			markBroken(c.ID)
+		}
+	}
+}
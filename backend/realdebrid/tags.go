@@ -0,0 +1,140 @@
+package realdebrid
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// tags holds the user defined tags of each torrent by lower case info
+// hash so they survive repairs
+var tags = map[string][]string{}
+var tagsMu sync.Mutex
+
+// torrentTags returns the tags of the torrent with hash
+func torrentTags(hash string) []string {
+	tagsMu.Lock()
+	defer tagsMu.Unlock()
+	return append([]string(nil), tags[strings.ToLower(hash)]...)
+}
+
+// hasTag returns whether the torrent with hash is tagged with tag
+func hasTag(hash, tag string) bool {
+	for _, t := range torrentTags(hash) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// editTags adds and removes tags from the torrent with hash, returning
+// the tags it ends up with
+func editTags(hash string, add, remove []string) []string {
+	hash = strings.ToLower(hash)
+	tagsMu.Lock()
+	defer tagsMu.Unlock()
+	set := map[string]bool{}
+	for _, t := range tags[hash] {
+		set[t] = true
+	}
+	for _, t := range add {
+		set[t] = true
+	}
+	for _, t := range remove {
+		delete(set, t)
+	}
+	var out []string
+	for t := range set {
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	if len(out) == 0 {
+		delete(tags, hash)
+	} else {
+		tags[hash] = out
+	}
+	return append([]string(nil), out...)
+}
+
+// splitTags splits a comma separated list of tags
+func splitTags(s string) (out []string) {
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		if t != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// torrentForPath returns the torrent the folder or file at remote
+// belongs to
+func (f *Fs) torrentForPath(ctx context.Context, remote string) (*api.Item, error) {
+	var id string
+	if dirID, err := f.dirCache.FindDir(ctx, remote, false); err == nil {
+		id = strings.TrimPrefix(dirID, byHashPrefix)
+	} else {
+		o, err := f.NewObject(ctx, remote)
+		if err != nil {
+			return nil, err
+		}
+		id = o.(*Object).ParentID
+	}
+	listMu.RLock()
+	defer listMu.RUnlock()
+	if i := torrentIndex(id); i >= 0 {
+		torrent := torrents[i]
+		return &torrent, nil
+	}
+	return nil, errors.New("not a torrent or a file in one")
+}
+
+// tagResult is the output of the tag command for one torrent
+type tagResult struct {
+	Path string   `json:"path"`
+	Name string   `json:"name"`
+	Hash string   `json:"hash"`
+	Tags []string `json:"tags"`
+}
+
+// tagCommand runs the tag backend command
+//
+// With a path it shows or edits the tags of that torrent, without one
+// it lists all the tagged torrents, optionally only those with -o tag.
+func (f *Fs) tagCommand(ctx context.Context, arg []string, opt map[string]string) (interface{}, error) {
+	if len(arg) == 0 {
+		tag := opt["tag"]
+		out := []tagResult{}
+		listMu.RLock()
+		for i := range torrents {
+			torrent := &torrents[i]
+			t := torrentTags(torrent.TorrentHash)
+			if len(t) == 0 || (tag != "" && !hasTag(torrent.TorrentHash, tag)) {
+				continue
+			}
+			out = append(out, tagResult{Path: f.torrentPath(torrent), Name: torrent.Name, Hash: torrent.TorrentHash, Tags: t})
+		}
+		listMu.RUnlock()
+		return out, nil
+	}
+	torrent, err := f.torrentForPath(ctx, parsePath(arg[0]))
+	if err != nil {
+		return nil, err
+	}
+	result := tagResult{Path: f.torrentPath(torrent), Name: torrent.Name, Hash: torrent.TorrentHash}
+	add, remove := splitTags(opt["add"]), splitTags(opt["remove"])
+	if len(add) == 0 && len(remove) == 0 {
+		result.Tags = torrentTags(torrent.TorrentHash)
+		return result, nil
+	}
+	result.Tags = editTags(torrent.TorrentHash, add, remove)
+	fs.Infof(f, "Tags of %q are now %v", torrent.Name, result.Tags)
+	f.saveState()
+	return result, nil
+}
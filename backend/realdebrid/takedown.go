@@ -0,0 +1,120 @@
+package realdebrid
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+)
+
+// errTakenDown is returned when opening a file RealDebrid has taken
+// down as infringing
+var errTakenDown = errors.New("file has been taken down by RealDebrid as infringing")
+
+// takedown records a link RealDebrid refuses to unrestrict because the
+// file is infringing
+type takedown struct {
+	Link      string    `json:"link"`
+	TorrentID string    `json:"torrent_id,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// takedowns holds the links which have been taken down by link. They
+// are permanent so are never unrestricted again and don't mark their
+// torrent for repair, as re-adding it would only get the same links.
+var takedowns = map[string]takedown{}
+var takedownsMu sync.Mutex
+
+// isInfringing returns whether err is RealDebrid refusing a file as
+// infringing
+func isInfringing(err error) bool {
+	return errors.Is(err, api.ErrInfringingFile)
+}
+
+// markTakenDown records t as taken down, filling in any details
+// missing from an earlier record, returning whether it is new
+func markTakenDown(t takedown) bool {
+	takedownsMu.Lock()
+	defer takedownsMu.Unlock()
+	old, found := takedowns[t.Link]
+	if found {
+		if t.TorrentID == "" {
+			t.TorrentID = old.TorrentID
+		}
+		if t.Name == "" {
+			t.Name = old.Name
+		}
+		t.Time = old.Time
+	} else {
+		t.Time = time.Now()
+	}
+	takedowns[t.Link] = t
+	return !found
+}
+
+// isTakenDown returns whether link has been taken down
+func isTakenDown(link string) bool {
+	takedownsMu.Lock()
+	defer takedownsMu.Unlock()
+	_, found := takedowns[link]
+	return found
+}
+
+// takenDown returns the takedowns, oldest first
+func takenDown() []takedown {
+	takedownsMu.Lock()
+	out := make([]takedown, 0, len(takedowns))
+	for _, t := range takedowns {
+		out = append(out, t)
+	}
+	takedownsMu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Time.Before(out[j].Time)
+	})
+	return out
+}
+
+// torrentTakedowns returns how many links of torrent have been taken
+// down
+func torrentTakedowns(torrent *api.Item) (n int) {
+	takedownsMu.Lock()
+	defer takedownsMu.Unlock()
+	for _, link := range torrent.Links {
+		if _, found := takedowns[link]; found {
+			n++
+		}
+	}
+	return n
+}
+
+// noteTakedown logs and records that link, file index of torrent, has
+// been taken down
+func (f *Fs) noteTakedown(torrent *api.Item, link string, index int) {
+	// Links are in the order of the selected files
+	name := ""
+	n := 0
+	for _, file := range torrent.Files {
+		if file.Selected != 1 {
+			continue
+		}
+		if n == index {
+			name = file.Path
+			break
+		}
+		n++
+	}
+	if markTakenDown(takedown{Link: link, TorrentID: torrent.ID, Name: name}) {
+		fs.Logf(f, "Torrent %q: file %d has been taken down as infringing and will be left out", torrent.Name, index+1)
+	}
+}
+
+// takenDownError returns an error for opening link which has been
+// taken down
+func takenDownError(link string) error {
+	return fmt.Errorf("%w: %s", errTakenDown, link)
+}
@@ -0,0 +1,79 @@
+package realdebrid
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/rclone/rclone/fs"
+	"golang.org/x/time/rate"
+)
+
+// backgroundHeader marks an Open as a background transfer when passed
+// with --header-download, so it is limited to background_bwlimit
+const backgroundHeader = "X-Rclone-Background"
+
+// minBurst is the smallest burst of the background limiter so reads
+// aren't split up too finely
+const minBurst = 64 * 1024
+
+// newBackgroundLimiter returns the limiter shared by all the background
+// transfers, or nil if limit is off
+func newBackgroundLimiter(limit fs.SizeSuffix) *rate.Limiter {
+	if limit <= 0 {
+		return nil
+	}
+	burst := int(limit)
+	if burst < minBurst {
+		burst = minBurst
+	}
+	return rate.NewLimiter(rate.Limit(limit), burst)
+}
+
+// isBackground returns whether the options passed to Open mark it as
+// a background transfer
+func isBackground(options []fs.OpenOption) bool {
+	for _, option := range options {
+		key, value := option.Header()
+		if http.CanonicalHeaderKey(key) == backgroundHeader && value != "" && value != "0" && value != "false" {
+			return true
+		}
+	}
+	return false
+}
+
+// throttledReader limits the rate of reads from in with limiter
+type throttledReader struct {
+	ctx     context.Context
+	in      io.ReadCloser
+	limiter *rate.Limiter
+}
+
+// Read reads at most a burst at a time, waiting for the limiter
+// afterwards
+func (r *throttledReader) Read(p []byte) (n int, err error) {
+	if burst := r.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err = r.in.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// Close closes the underlying reader
+func (r *throttledReader) Close() error {
+	return r.in.Close()
+}
+
+// throttle wraps in so it is read no faster than background_bwlimit if
+// the Open is a background transfer
+func (f *Fs) throttle(ctx context.Context, in io.ReadCloser, background bool) io.ReadCloser {
+	if f.background == nil || !background {
+		return in
+	}
+	return &throttledReader{ctx: ctx, in: in, limiter: f.background}
+}
@@ -0,0 +1,306 @@
+package realdebrid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// The /.unselected view shows the files of torrents which weren't
+// selected when they were added, so more of them can be chosen with the
+// select command. The folders in it have IDs of unselectedPrefix
+// followed by the torrent ID.
+const (
+	unselectedDirID  = ".unselected"
+	unselectedPrefix = unselectedDirID + "/"
+)
+
+// torrentInfos caches the files of torrents by torrent ID, as reading
+// them takes a call per torrent and they don't change
+var torrentInfos = map[string][]api.File{}
+var torrentInfosMu sync.Mutex
+
+// torrentFileList returns the files of the torrent with ID id, reading
+// them from RealDebrid if they aren't known yet
+func (f *Fs) torrentFileList(ctx context.Context, id string) ([]api.File, error) {
+	torrentInfosMu.Lock()
+	files, ok := torrentInfos[id]
+	torrentInfosMu.Unlock()
+	if ok {
+		return files, nil
+	}
+	opts := rest.Opts{
+		Method:     "GET",
+		Path:       "/torrents/info/" + id,
+		Parameters: f.baseParams(),
+	}
+	var info api.Item
+	var resp *http.Response
+	err := f.pacer.Call(func() (bool, error) {
+		var err error
+		resp, err = f.srv.CallJSON(ctx, &opts, nil, &info)
+		return shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read torrent info: %w", err)
+	}
+	torrentInfosMu.Lock()
+	torrentInfos[id] = info.Files
+	torrentInfosMu.Unlock()
+	return info.Files, nil
+}
+
+// cachedFileList returns the files of the torrent with ID id if they
+// have been read already
+func cachedFileList(id string) ([]api.File, bool) {
+	torrentInfosMu.Lock()
+	defer torrentInfosMu.Unlock()
+	files, ok := torrentInfos[id]
+	return files, ok
+}
+
+// fetchTorrentInfos forgets the files of the torrents no longer in
+// list, the torrents in the library, and with show_unselected reads
+// the files of the downloaded torrents which aren't known yet, so
+// /.unselected can be listed from torrentInfos without calling
+// RealDebrid while holding listMu
+//
+// Call without listMu held.
+func (f *Fs) fetchTorrentInfos(ctx context.Context, list []api.Item) {
+	live := make(map[string]bool, len(list))
+	for _, torrent := range list {
+		live[torrent.ID] = true
+	}
+	torrentInfosMu.Lock()
+	for id := range torrentInfos {
+		if !live[id] {
+			delete(torrentInfos, id)
+		}
+	}
+	torrentInfosMu.Unlock()
+	if f.opt.SharedFolder != "folders" || !f.opt.ShowUnselected {
+		return
+	}
+	for _, torrent := range list {
+		if torrent.Status != "downloaded" {
+			continue
+		}
+		if _, ok := cachedFileList(torrent.ID); ok {
+			continue
+		}
+		_, err := f.torrentFileList(ctx, torrent.ID)
+		if err != nil {
+			fs.Debugf(f, "Torrent %q: %v", torrent.Name, err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// forgetTorrentInfo drops the cached files of the torrent with ID id
+func forgetTorrentInfo(id string) {
+	torrentInfosMu.Lock()
+	delete(torrentInfos, id)
+	torrentInfosMu.Unlock()
+}
+
+// unselected returns the files in files which aren't selected
+func unselected(files []api.File) (out []api.File) {
+	for _, file := range files {
+		if file.Selected != 1 {
+			out = append(out, file)
+		}
+	}
+	return out
+}
+
+// unselectedItems returns a folder for each downloaded torrent which
+// has files which aren't selected. Torrents whose files haven't been
+// read by fetchTorrentInfos yet are left out.
+//
+// Call with listMu held.
+func (f *Fs) unselectedItems() (result []api.Item) {
+	for i := range torrents {
+		torrent := &torrents[i]
+		if torrent.Status != "downloaded" {
+			continue
+		}
+		files, ok := cachedFileList(torrent.ID)
+		if !ok {
+			continue
+		}
+		if len(unselected(files)) == 0 {
+			continue
+		}
+		result = append(result, api.Item{
+			ID:          unselectedPrefix + torrent.ID,
+			Name:        f.standardName(torrent.Name, torrent.ID),
+			Type:        api.ItemTypeFolder,
+			CreatedAt:   torrent.CreatedAt,
+			Generated:   torrent.Generated,
+			TorrentHash: torrent.TorrentHash,
+		})
+	}
+	return result
+}
+
+// unselectedFiles returns the files of the torrent with ID id which
+// aren't selected. They have no links so can't be opened.
+//
+// Call with listMu held.
+func (f *Fs) unselectedFiles(id string) (result []api.Item) {
+	i := torrentIndex(id)
+	if i < 0 {
+		return nil
+	}
+	torrent := &torrents[i]
+	files, ok := cachedFileList(id)
+	if !ok {
+		return nil
+	}
+	for _, file := range unselected(files) {
+		result = append(result, api.Item{
+			ID:          unselectedPrefix + id + "/" + strconv.FormatInt(file.ID, 10),
+			Name:        path.Base(file.Path),
+			Type:        api.ItemTypeFile,
+			Size:        file.Bytes,
+			CreatedAt:   torrent.CreatedAt,
+			Generated:   torrent.Generated,
+			ParentID:    id,
+			TorrentHash: torrent.TorrentHash,
+		})
+	}
+	return result
+}
+
+// selectResult is the output of the select command for one torrent
+type selectResult struct {
+	Name      string   `json:"name"`
+	Files     []string `json:"files"`
+	TorrentID string   `json:"torrent_id,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// findUnselected returns the torrent ID and the files to select for
+// the path p of a folder or file in /.unselected
+func (f *Fs) findUnselected(ctx context.Context, p string) (id string, files []api.File, err error) {
+	leaf := ""
+	dirID, err := f.dirCache.FindDir(ctx, p, false)
+	if err != nil || !strings.HasPrefix(dirID, unselectedPrefix) {
+		leaf = path.Base(p)
+		dirID, err = f.dirCache.FindDir(ctx, parentDir(p), false)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if !strings.HasPrefix(dirID, unselectedPrefix) {
+		return "", nil, fmt.Errorf("%q isn't in %s", p, unselectedDirID)
+	}
+	id = strings.TrimPrefix(dirID, unselectedPrefix)
+	all, err := f.torrentFileList(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, file := range unselected(all) {
+		if leaf == "" || path.Base(file.Path) == leaf {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return "", nil, fmt.Errorf("%q: %w", p, fs.ErrorObjectNotFound)
+	}
+	return id, files, nil
+}
+
+// selectCommand selects the files in /.unselected given by arg
+//
+// RealDebrid can't change the selection of a torrent, so the torrent
+// is added again selecting the same files as before and the new ones,
+// then the old one is deleted. As RealDebrid usually has the torrent
+// cached the new files are available straight away.
+func (f *Fs) selectCommand(ctx context.Context, arg []string) (interface{}, error) {
+	if len(arg) == 0 {
+		return nil, errors.New("need the path of at least one file or folder in " + unselectedDirID)
+	}
+	if err := checkOnline(); err != nil {
+		return nil, err
+	}
+	var ids []string
+	want := map[string]map[int64]api.File{}
+	for _, a := range arg {
+		id, files, err := f.findUnselected(ctx, parsePath(a))
+		if err != nil {
+			return nil, err
+		}
+		if want[id] == nil {
+			want[id] = map[int64]api.File{}
+			ids = append(ids, id)
+		}
+		for _, file := range files {
+			want[id][file.ID] = file
+		}
+	}
+	out := []selectResult{}
+	for _, id := range ids {
+		listMu.RLock()
+		i := torrentIndex(id)
+		var torrent api.Item
+		if i >= 0 {
+			torrent = torrents[i]
+		}
+		listMu.RUnlock()
+		if i < 0 {
+			continue
+		}
+		result := selectResult{Name: torrent.Name}
+		for _, file := range want[id] {
+			result.Files = append(result.Files, file.Path)
+		}
+		if operations.SkipDestructive(ctx, torrent.Name, fmt.Sprintf("select %d more files", len(want[id]))) {
+			out = append(out, result)
+			continue
+		}
+		old, err := f.torrentFileList(ctx, id)
+		if err != nil {
+			result.Error = err.Error()
+			out = append(out, result)
+			continue
+		}
+		var selected []string
+		for _, file := range old {
+			if _, ok := want[id][file.ID]; ok || file.Selected == 1 {
+				selected = append(selected, strconv.FormatInt(file.ID, 10))
+			}
+		}
+		added, err := f.addTorrent(ctx, "magnet:?xt=urn:btih:"+torrent.TorrentHash, func([]api.File) string {
+			// file IDs are the same as the torrent is the same
+			return strings.Join(selected, ",")
+		})
+		if err != nil {
+			result.Error = err.Error()
+			out = append(out, result)
+			continue
+		}
+		result.TorrentID = added.ID
+		err = f.deleteTorrent(ctx, id)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		forgetTorrentInfo(id)
+		fs.Infof(f, "Torrent %q: selected %d more files as torrent %s", torrent.Name, len(want[id]), added.ID)
+		out = append(out, result)
+	}
+	forceRefresh()
+	return out, nil
+}
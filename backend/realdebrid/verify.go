@@ -0,0 +1,160 @@
+package realdebrid
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/rest"
+)
+
+// Results of verifying a file
+const (
+	verifyOK             = "ok"
+	verifyNoLink         = "no-link"
+	verifyDead           = "dead"
+	verifySizeMismatch   = "size-mismatch"
+	verifyDeletedTorrent = "deleted-torrent"
+)
+
+// defaultVerifyDelay is the default time waited between checking links
+const defaultVerifyDelay = 250 * time.Millisecond
+
+// verifyResult is the outcome of checking the link of one file
+type verifyResult struct {
+	Path       string `json:"path"`
+	Status     string `json:"status"`
+	TorrentID  string `json:"torrent_id,omitempty"`
+	Link       string `json:"link,omitempty"`
+	Size       int64  `json:"size"`
+	RemoteSize int64  `json:"remote_size,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// torrentExists returns whether a torrent with torrentID is in the
+// library
+func torrentExists(torrentID string) bool {
+	listMu.RLock()
+	defer listMu.RUnlock()
+	for _, torrent := range torrents {
+		if torrent.ID == torrentID {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyObject checks the link of o with a HEAD request
+//
+// The request is conditional on the validators of an earlier response
+// for the link, if there was one, and a 304 Not Modified reply is taken
+// as the link still working with the size it had then.
+func (f *Fs) verifyObject(ctx context.Context, o *Object) verifyResult {
+	r := verifyResult{
+		Path:      o.remote,
+		Status:    verifyOK,
+		TorrentID: o.ParentID,
+		Link:      o.url,
+		Size:      o.size,
+	}
+	if o.ParentID != "" && !torrentExists(o.ParentID) {
+		r.Status = verifyDeletedTorrent
+		return r
+	}
+	if o.url == "" {
+		r.Status = verifyNoLink
+		return r
+	}
+	opts := rest.Opts{
+		Method:       "HEAD",
+		RootURL:      o.url,
+		ExtraHeaders: conditionalHeaders(o.url),
+		IgnoreStatus: true,
+	}
+	var resp *http.Response
+	err := f.pacer.Call(func() (bool, error) {
+		var err error
+		resp, err = f.dl.Call(ctx, &opts)
+		if err == nil && resp.StatusCode != http.StatusNotModified && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+			err = errorHandler(resp)
+		}
+		return shouldRetry(ctx, resp, err)
+	})
+	if err != nil {
+		r.Status = verifyDead
+		r.Error = err.Error()
+		return r
+	}
+	_ = resp.Body.Close()
+	size := resp.ContentLength
+	if resp.StatusCode == http.StatusNotModified {
+		v, _ := storedValidator(o.url)
+		size = v.size
+	} else {
+		rememberValidator(o.url, resp)
+	}
+	r.RemoteSize = size
+	if size >= 0 && size != o.size {
+		r.Status = verifySizeMismatch
+	}
+	return r
+}
+
+// verify checks the link of every file under dir, waiting delay
+// between each one
+//
+// Only problems are returned unless all is set.
+func (f *Fs) verify(ctx context.Context, dir string, delay time.Duration, all bool) (out []verifyResult, err error) {
+	entries, err := f.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		switch x := entry.(type) {
+		case fs.Directory:
+			if x.ID() == byHashDirID || x.ID() == recentDirID || x.ID() == unselectedDirID {
+				// same torrents again
+				continue
+			}
+			sub, err := f.verify(ctx, x.Remote(), delay, all)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, sub...)
+		case *Object:
+			r := f.verifyObject(ctx, x)
+			if r.Status != verifyOK {
+				fs.Logf(x, "Verify: %s", r.Status)
+			}
+			if all || r.Status != verifyOK {
+				out = append(out, r)
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+	}
+	return out, nil
+}
+
+// verifyCommand runs the verify backend command
+func (f *Fs) verifyCommand(ctx context.Context, arg []string, opt map[string]string) (interface{}, error) {
+	dir := ""
+	if len(arg) > 0 {
+		dir = parsePath(arg[0])
+	}
+	delay := defaultVerifyDelay
+	if s, ok := opt["delay"]; ok {
+		d, err := fs.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad delay: %w", err)
+		}
+		delay = d
+	}
+	_, all := opt["all"]
+	return f.verify(ctx, dir, delay, all)
+}
@@ -0,0 +1,51 @@
+package realdebrid
+
+import (
+	"strings"
+
+	"github.com/rclone/rclone/backend/realdebrid/api"
+)
+
+// The /.by-hash view lists every torrent by its info hash regardless of
+// how it is sorted. byHashDirID is the ID of the view and the folders
+// in it have IDs of byHashPrefix followed by the torrent ID.
+const (
+	byHashDirID  = ".by-hash"
+	byHashPrefix = byHashDirID + "/"
+)
+
+// byHashItems returns a folder for each torrent named by its info hash
+//
+// If a torrent has been added more than once only the first is shown.
+//
+// Call with listMu held.
+func (f *Fs) byHashItems() (result []api.Item) {
+	seen := map[string]bool{}
+	for _, torrent := range torrents {
+		hash := strings.ToLower(torrent.TorrentHash)
+		if hash == "" || seen[hash] || !f.statusShown(torrent.Status) {
+			continue
+		}
+		seen[hash] = true
+		result = append(result, api.Item{
+			ID:        byHashPrefix + torrent.ID,
+			Name:      hash,
+			Type:      api.ItemTypeFolder,
+			Generated: torrent.Generated,
+		})
+	}
+	return result
+}
+
+// torrentIndex returns the index in torrents of the torrent with ID id
+// or -1 if not found
+//
+// Call with listMu held.
+func torrentIndex(id string) int {
+	for i := range torrents {
+		if torrents[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
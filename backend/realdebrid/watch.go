@@ -0,0 +1,235 @@
+package realdebrid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fshttp"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// eventMove is recorded when a torrent is moved into another sorting
+// folder. Unlike the others it has no hook.
+const eventMove = "move"
+
+// eventBacklog is how many of the latest events are kept for watchers
+const eventBacklog = 1000
+
+// libraryEvent is a change of the library as streamed by the watch
+// command
+type libraryEvent struct {
+	Seq int64 `json:"seq"`
+	hookEvent
+	From string `json:"from,omitempty"` // the folder a torrent was moved from
+}
+
+// eventLog holds the latest events, numbered by eventSeq. eventsAdded
+// is closed and replaced whenever one is added to wake up watchers.
+// They are protected by eventsMu.
+var (
+	eventLog    []libraryEvent
+	eventSeq    int64
+	eventsAdded = make(chan struct{})
+	eventsMu    sync.Mutex
+)
+
+// recordEvent adds e to the eventLog
+func recordEvent(e libraryEvent) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	eventSeq++
+	e.Seq = eventSeq
+	eventLog = append(eventLog, e)
+	if len(eventLog) > eventBacklog {
+		eventLog = append([]libraryEvent(nil), eventLog[len(eventLog)-eventBacklog:]...)
+	}
+	close(eventsAdded)
+	eventsAdded = make(chan struct{})
+}
+
+// lastEventSeq returns the number of the latest event
+func lastEventSeq() int64 {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	return eventSeq
+}
+
+// eventsSince returns the events numbered after seq, waiting for one
+// until ctx is done if there aren't any
+func eventsSince(ctx context.Context, seq int64) []libraryEvent {
+	for {
+		eventsMu.Lock()
+		var out []libraryEvent
+		for _, e := range eventLog {
+			if e.Seq > seq {
+				out = append(out, e)
+			}
+		}
+		added := eventsAdded
+		eventsMu.Unlock()
+		if len(out) > 0 {
+			return out
+		}
+		select {
+		case <-added:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// watchOutput is where the watch command writes the events
+var watchOutput io.Writer = os.Stdout
+
+// watchCommand streams the events of the library as JSON lines until
+// interrupted, either from the rclone instance serving the rc at the
+// rc option or by refreshing the library itself
+func (f *Fs) watchCommand(ctx context.Context, opt map[string]string) (interface{}, error) {
+	interval := time.Minute
+	if s, ok := opt["interval"]; ok {
+		d, err := fs.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("bad interval %q", s)
+		}
+		interval = d
+	}
+	out := json.NewEncoder(watchOutput)
+	if addr, ok := opt["rc"]; ok {
+		return nil, f.watchRemote(ctx, out, addr, opt["user"], opt["pass"], interval)
+	}
+	seq := lastEventSeq()
+	for ctx.Err() == nil {
+		if _, err := f.refreshLibrary(ctx); err != nil {
+			fs.Errorf(f, "Watch failed to refresh the library: %v", err)
+		}
+		waitCtx, cancel := context.WithTimeout(ctx, interval)
+		for events := eventsSince(waitCtx, seq); len(events) > 0; events = eventsSince(waitCtx, seq) {
+			for _, e := range events {
+				if err := out.Encode(e); err != nil {
+					cancel()
+					return nil, err
+				}
+				seq = e.Seq
+			}
+		}
+		cancel()
+	}
+	return nil, nil
+}
+
+// eventsReply is the reply of the backend/realdebrid/events rc call
+type eventsReply struct {
+	Seq    int64          `json:"seq"`
+	Events []libraryEvent `json:"events"`
+}
+
+// watchRemote streams the events of the rclone instance serving the rc
+// at addr, asking for more every interval
+func (f *Fs) watchRemote(ctx context.Context, out *json.Encoder, addr, user, pass string, interval time.Duration) error {
+	client := fshttp.NewClient(ctx)
+	endpoint := strings.TrimRight(addr, "/") + "/backend/realdebrid/events"
+	seq := int64(-1)
+	for ctx.Err() == nil {
+		var reply eventsReply
+		err := f.callEvents(ctx, client, endpoint, user, pass, rc.Params{"since": seq, "timeout": interval.String()}, &reply)
+		if err != nil {
+			fs.Errorf(f, "Watch failed to get events from %q: %v", addr, err)
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		for _, e := range reply.Events {
+			if err := out.Encode(e); err != nil {
+				return err
+			}
+		}
+		seq = reply.Seq
+	}
+	return nil
+}
+
+// callEvents makes the backend/realdebrid/events rc call at endpoint
+func (f *Fs) callEvents(ctx context.Context, client *http.Client, endpoint, user, pass string, in rc.Params, reply *eventsReply) (err error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if user != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(resp.Body, &err)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// rcEvents is the backend/realdebrid/events rc call
+func rcEvents(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	since, err := in.GetInt64("since")
+	if rc.IsErrParamNotFound(err) {
+		since = -1
+	} else if err != nil {
+		return nil, err
+	}
+	timeout, err := in.GetDuration("timeout")
+	if rc.IsErrParamNotFound(err) {
+		timeout = 0
+	} else if err != nil {
+		return nil, err
+	}
+	if since < 0 {
+		return rc.Params{"seq": lastEventSeq(), "events": []libraryEvent{}}, nil
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	events := eventsSince(waitCtx, since)
+	seq := since
+	if len(events) > 0 {
+		seq = events[len(events)-1].Seq
+	} else {
+		events = []libraryEvent{}
+	}
+	return rc.Params{"seq": seq, "events": events}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "backend/realdebrid/events",
+		AuthRequired: true,
+		Fn:           rcEvents,
+		Title:        "Get the latest changes of the realdebrid library",
+		Help: `This returns the add, remove, repair and move events of the
+realdebrid remotes after the event numbered since, waiting up to
+timeout for one if there aren't any yet. The latest 1000 events are
+kept. It is what "rclone backend watch" uses with -o rc.
+
+- since - the seq of the last event seen, leave out to get just the
+  latest seq
+- timeout - how long to wait for an event, e.g. "1m", default "0"
+
+It returns the events and the seq to pass as since next time.
+`,
+	})
+}
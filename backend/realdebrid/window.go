@@ -0,0 +1,73 @@
+package realdebrid
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maintenanceWindow is a daily time range in local time in which
+// expensive operations are allowed to run
+type maintenanceWindow struct {
+	start time.Duration // offset from midnight
+	end   time.Duration // offset from midnight, may be before start
+}
+
+// parseClock parses "HH:MM" into an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("bad time %q - want HH:MM: %w", s, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseMaintenanceWindow parses a window like "03:00-05:00"
+//
+// An empty string returns a nil window which means always open.
+func parseMaintenanceWindow(s string) (*maintenanceWindow, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("bad maintenance_window %q - want HH:MM-HH:MM", s)
+	}
+	start, err := parseClock(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if start == end {
+		return nil, fmt.Errorf("bad maintenance_window %q - start and end are the same", s)
+	}
+	return &maintenanceWindow{start: start, end: end}, nil
+}
+
+// contains returns whether t is inside the window
+func (w *maintenanceWindow) contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.start < w.end {
+		return offset >= w.start && offset < w.end
+	}
+	// window wraps past midnight
+	return offset >= w.start || offset < w.end
+}
+
+// String returns the window in the same format it is configured in
+func (w *maintenanceWindow) String() string {
+	if w == nil {
+		return "always"
+	}
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return clock(w.start) + "-" + clock(w.end)
+}